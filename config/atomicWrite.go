@@ -0,0 +1,171 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+/*
+WriteFileAtomic replaces the file with 'data' so that after a crash or power loss the
+file contains either the old data or the new data, never part of either.
+
+See WriteFileAtomicFrom.
+*/
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	_, err := WriteFileAtomicFrom(path, bytes.NewReader(data), perm, true)
+	return err
+}
+
+/*
+WriteFileAtomicFrom writes everything from 'r' to a temp file in the same directory as 'path'.
+The temp file is synced and renamed to 'path' and then the directory is synced.
+
+If 'replace' is false and 'path' exists os.ErrExist is returned and 'path' is not changed.
+
+On any error the temp file is removed and 'path' is not changed.
+Returns the number of bytes written.
+*/
+func WriteFileAtomicFrom(path string, r io.Reader, perm os.FileMode, replace bool) (int64, error) {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	tmpName := tmp.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmpName)
+		}
+	}()
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		return n, err
+	}
+	err = tmp.Chmod(perm)
+	if err != nil {
+		return n, err
+	}
+	err = tmp.Sync()
+	if err != nil {
+		return n, err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return n, err
+	}
+	err = RenameDurable(tmpName, path, replace)
+	if err != nil {
+		return n, err
+	}
+	committed = true
+	return n, nil
+}
+
+/*
+Rename without replacing an existing file.
+
+A hard link fails if 'newPath' exists so there is no window where another writer can be
+overwritten. Some file systems (FAT on USB drives) do not support links so a check then rename is used.
+*/
+func renameNoReplace(oldPath, newPath string) error {
+	err := os.Link(oldPath, newPath)
+	if err == nil {
+		return os.Remove(oldPath)
+	}
+	if errors.Is(err, os.ErrExist) {
+		return os.ErrExist
+	}
+	_, err = os.Stat(newPath)
+	if err == nil {
+		return os.ErrExist
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+/*
+RenameDurable renames the file and syncs the directory so the rename survives a power loss.
+
+If 'replace' is false and 'newPath' exists os.ErrExist is returned.
+*/
+func RenameDurable(oldPath, newPath string, replace bool) error {
+	var err error
+	if replace {
+		err = os.Rename(oldPath, newPath)
+	} else {
+		err = renameNoReplace(oldPath, newPath)
+	}
+	if err != nil {
+		return err
+	}
+	return SyncDir(filepath.Dir(newPath))
+}
+
+/*
+AppendFileDurable appends 'data' to the file (creating it if required) and syncs it before returning.
+
+See AppendFileDurableFrom.
+*/
+func AppendFileDurable(path string, data []byte, perm os.FileMode) error {
+	_, err := AppendFileDurableFrom(path, bytes.NewReader(data), perm)
+	return err
+}
+
+/*
+AppendFileDurableFrom appends everything from 'r' to the file and syncs it before returning.
+
+If the write fails the file is truncated to its original length so a failed append does not
+leave part of the data. A new file is synced in to its directory.
+Returns the number of bytes appended.
+*/
+func AppendFileDurableFrom(path string, r io.Reader, perm os.FileMode) (int64, error) {
+	_, statErr := os.Stat(path)
+	created := statErr != nil
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, perm)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		if created {
+			f.Close()
+			os.Remove(path)
+		} else {
+			f.Truncate(st.Size())
+			f.Sync()
+		}
+		return n, err
+	}
+	err = f.Close()
+	if err != nil {
+		return n, err
+	}
+	if created {
+		return n, SyncDir(filepath.Dir(path))
+	}
+	return n, nil
+}
+
+/*
+SyncDir flushes the directory entry changes (create, rename, remove) to disk.
+*/
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -50,6 +50,38 @@ const panicMessageStatus = "status:"
 const panicMessageLog = "log:"
 const StaticPathName = "static"
 const ImagesPathName = "images"
+const AdminUserName = "admin"
+const defaultSessionMinutes = 720
+const defaultShutdownSeconds = 10
+const defaultUploadExpiryMinutes = 1440
+const defaultTrashRetentionDays = 30
+const defaultThumbnailLocation = "thumbs"
+const defaultThumbnailSize = 200
+const defaultThumbnailQuality = 75
+const defaultThumbnailConcurrent = 2
+const defaultImageCacheMB = 256
+const defaultImageCacheQuality = 85
+const defaultShareHours = 24
+const defaultShareMaxDays = 30
+
+// Partial (chunked) uploads are held in this directory in the root of each location
+const UploadDirName = ".uploads"
+
+// Deleted files are held in this directory in each users root (Home) until purged
+const TrashDirName = ".trash"
+
+// Previous versions of a replaced file are held in this directory next to the file
+const VersionsDirName = ".versions"
+
+// Share links created for a users files are held in this directory of the server log directory (LogData.Path). One file per user
+const SharesDirName = "shares"
+
+// Share links were held in this file in each users root (Home). It is moved to SharesDirName when found
+const LegacySharesFileName = ".shares.json"
+
+// Exec OnExit policy for detached processes when the server stops
+const ExecOnExitLeave = "leave"
+const ExecOnExitStop = "stop"
 
 type UserProperties struct {
 	mu     sync.Mutex
@@ -67,7 +99,7 @@ func NewUserProperties(path string) (*UserProperties, error) {
 	}
 	_, err := os.Stat(path)
 	if err != nil {
-		err = os.WriteFile(path, []byte("{}"), 0644)
+		err = WriteFileAtomic(path, []byte("{}"), 0644)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create user properties file:%s. Error:%s", path, err.Error())
 		}
@@ -97,7 +129,7 @@ func (up *UserProperties) writeToFile() {
 	if err != nil {
 		panic(fmt.Sprintf("Failed to serialise user properties: %s", err.Error()))
 	}
-	err = os.WriteFile(up.path, body, 0644)
+	err = WriteFileAtomic(up.path, body, 0644)
 	if err != nil {
 		panic(fmt.Sprintf("Failed to save user properties: %s", err.Error()))
 	}
@@ -255,6 +287,7 @@ type TemplateStaticFiles struct {
 	DataFile         string
 	flatDataFromFile map[string]string
 	isTemplating     bool
+	dataFilePath     string // DataFile resolved relative to the static path
 }
 
 func (t *TemplateStaticFiles) Init(staticPath string, configErrors *ConfigErrorData, addTemplate func(string)) {
@@ -262,6 +295,7 @@ func (t *TemplateStaticFiles) Init(staticPath string, configErrors *ConfigErrorD
 	t.isTemplating = false
 	if t.DataFile != "" {
 		f := filepath.Join(staticPath, t.DataFile)
+		t.dataFilePath = f
 		content, err := os.ReadFile(f)
 		if err != nil {
 			configErrors.AddError(fmt.Sprintf("failed to read template data file. Error:%s", err.Error()))
@@ -311,6 +345,34 @@ type LogData struct {
 	ConsoleOut     bool
 }
 
+/*
+In-process thumbnail generation. Thumbnails are cached in the users 'Location'.
+*/
+type ThumbnailData struct {
+	Location      string // The location name (in each user) that holds thumbnails. Default "thumbs"
+	Size          int    // Max width or height in pixels. Default 200
+	Quality       int    // JPEG quality 1..100. Default 75
+	MaxConcurrent int    // Thumbnails generated at the same time. Default 2
+}
+
+/*
+Resized pictures are cached in 'Path'. The oldest unused are removed when the cache is larger than 'MaxMB'.
+*/
+type ImageCacheData struct {
+	Path    string // Default 'goWebAppImageCache' in the system temp directory
+	MaxMB   int    // Default 256
+	Quality int    // JPEG quality 1..100. Default 85
+}
+
+/*
+Signed links (/share/<token>) that allow anyone to download a single file until they expire.
+*/
+type ShareData struct {
+	Key          string // Signs share links. If undefined a random key is used and links end when the server restarts
+	DefaultHours int    // How long a link is valid if 'hours' is not given. Default 24
+	MaxDays      int    // The longest a link can be valid. Default 30
+}
+
 type StaticWebData struct {
 	Paths               map[string]string
 	HomePage            string
@@ -431,6 +493,8 @@ type ExecInfo struct {
 	Detached      bool
 	CanStop       bool
 	Description   string
+	Users         []string `json:",omitempty"` // Users (other than admin) permitted to run this Exec id
+	OnExit        string   `json:",omitempty"` // Detached only. 'leave' (default) the process running or 'stop' it when the server stops
 	id            string
 	execPath      string
 }
@@ -467,6 +531,13 @@ func (p *ExecInfo) Validate(execPathRoot string, name string, addError func(stri
 		if p.NzCodeReturns != 0 {
 			addError(fmt.Sprintf("Config Error: Exec [%s] is detached. Cannot have NzCodeReturns='%d'", p.id, p.NzCodeReturns))
 		}
+		if p.OnExit != "" && p.OnExit != ExecOnExitLeave && p.OnExit != ExecOnExitStop {
+			addError(fmt.Sprintf("Config Error: Exec [%s] OnExit='%s' must be '%s' or '%s'", p.id, p.OnExit, ExecOnExitLeave, ExecOnExitStop))
+		}
+	} else {
+		if p.OnExit != "" {
+			addError(fmt.Sprintf("Config Error: Exec [%s] is not detached. Cannot have OnExit='%s'", p.id, p.OnExit))
+		}
 	}
 	if p.LogDir != "" {
 		if strings.HasPrefix(p.LogDir, "..") {
@@ -489,6 +560,10 @@ func (p *ExecInfo) Validate(execPathRoot string, name string, addError func(stri
 	}
 }
 
+func (p *ExecInfo) StopOnExit() bool {
+	return p.Detached && p.OnExit == ExecOnExitStop
+}
+
 func (p *ExecInfo) GetOutLogFile() string {
 	if p.LogDir == "" || p.LogOutFile == "" {
 		return ""
@@ -526,12 +601,19 @@ func (p *ExecInfo) String() string {
 Users Data. Derived from JSON!
 */
 type UserData struct {
-	Hidden    *bool             // If true the user will not appear in the users list "http://server:port/server/users"
-	Name      string            // The name of the user. If the user ID is bob. The name could be Bob.
-	Home      string            // All locations are prefixed with this path when resolved
-	Locations map[string]string // Name,Value list for locations. The names are public the values are resolved relative to Home
-	Env       map[string]string // Name,Value list combined with OS environment for substitutions in resolved locations
-	Info      *bool
+	Hidden       *bool                      // If true the user will not appear in the users list "http://server:port/server/users"
+	Name         string                     // The name of the user. If the user ID is bob. The name could be Bob.
+	Home         string                     // All locations are prefixed with this path when resolved
+	Locations    map[string]string          // Name,Value list for locations. The names are public the values are resolved relative to Home
+	Policies     map[string]*LocationPolicy `json:"-"` // Locations defined as an object have a policy. See LocationPolicy
+	Env          map[string]string          // Name,Value list combined with OS environment for substitutions in resolved locations
+	Info         *bool
+	PasswordHash string `json:",omitempty"` // Optional. Created with the 'hash=' command line option. If defined the user must authenticate
+	Role         string `json:",omitempty"` // Optional. admin, user or guest. Default is admin for the admin user and user for all others
+}
+
+func (p *UserData) HasPassword() bool {
+	return p.PasswordHash != ""
 }
 
 func (p *UserData) CanSeeInfo() bool {
@@ -549,20 +631,33 @@ func (p *UserData) IsHidden() bool {
 }
 
 type ConfigDataFromFile struct {
-	Port               int
-	ThumbnailTrim      []int
-	UserDataPath       string
-	UserPropertiesFile string
-	Users              map[string]UserData
-	ContentTypeCharset string
-	LogData            *LogData
-	ServerName         string
-	FilterFiles        []string
-	ServerDataRoot     string
-	StaticWebData      *StaticWebData
-	Env                map[string]string
-	Exec               map[string]*ExecInfo
-	ExecPath           string
+	Port                int
+	ThumbnailTrim       []int
+	UserDataPath        string
+	UserPropertiesFile  string
+	Users               map[string]UserData
+	ContentTypeCharset  string
+	LogData             *LogData
+	ServerName          string
+	FilterFiles         []string
+	ServerDataRoot      string
+	StaticWebData       *StaticWebData
+	Env                 map[string]string
+	Exec                map[string]*ExecInfo
+	ExecPath            string
+	SessionKey          string          `json:",omitempty"` // Signs session cookies. If undefined a random key is used and sessions end when the server restarts.
+	SessionMinutes      int             `json:",omitempty"` // How long a session cookie remains valid after login.
+	TLSCertFile         string          `json:",omitempty"` // PEM certificate. If defined with TLSKeyFile the server uses HTTPS
+	TLSKeyFile          string          `json:",omitempty"` // PEM private key for TLSCertFile
+	HTTPRedirectPort    int             `json:",omitempty"` // Optional plain HTTP port that redirects to the HTTPS port
+	ShutdownSeconds     int             `json:",omitempty"` // How long in-flight requests have to complete when the server stops
+	ReloadConfigSeconds int             `json:",omitempty"` // How often the config files are checked for changes. 0 is never
+	UploadExpiryMinutes int             `json:",omitempty"` // Partial uploads not written to for this long are removed
+	TrashRetentionDays  int             `json:",omitempty"` // Deleted files are purged from the trash after this many days
+	KeepVersions        map[string]int  `json:",omitempty"` // Versions kept when a file is replaced. Key is 'loc' or 'user.loc'
+	Thumbnails          *ThumbnailData  `json:",omitempty"` // In-process thumbnail generation
+	ImageCache          *ImageCacheData `json:",omitempty"` // Resized pictures
+	Shares              *ShareData      `json:",omitempty"` // Signed share links for single files
 }
 
 func (p *ConfigDataFromFile) String() (string, error) {
@@ -856,6 +951,8 @@ func (p *ConfigData) resolveLocations(createDir bool, configErrors *ConfigErrorD
 		p.SetLogDataPath(f)
 	}
 
+	p.resolveTLS(userConfigEnv, configErrors)
+
 	for execName, execData := range p.ConfigFileData.Exec {
 		if p.GetExecPath() == "" {
 			configErrors.AddError("Config Error: Exec entries found. ExecPath cannot be undefined")
@@ -871,6 +968,11 @@ func (p *ConfigData) resolveLocations(createDir bool, configErrors *ConfigErrorD
 		if execData.StdOutType != "" && !HasContentType(execData.StdOutType) {
 			configErrors.AddError(fmt.Sprintf("Config Error: Exec [%s] StdOutType [%s] not recognised", execName, execData.StdOutType))
 		}
+		for _, u := range execData.Users {
+			if _, ok := p.ConfigFileData.Users[u]; !ok {
+				configErrors.AddError(fmt.Sprintf("Config Error: Exec [%s] Users [%s] is not a user", execName, u))
+			}
+		}
 		execData.id = execName
 	}
 
@@ -882,6 +984,18 @@ func (p *ConfigData) resolveLocations(createDir bool, configErrors *ConfigErrorD
 			b := false
 			userData.Hidden = &b
 		}
+		if userData.Role != "" {
+			_, err := ParseRole(userData.Role)
+			if err != nil {
+				configErrors.AddError(fmt.Sprintf("Config Error: User [%s] %s", userId, err.Error()))
+			}
+		}
+		if userData.HasPassword() {
+			err := validatePasswordHash(userData.PasswordHash)
+			if err != nil {
+				configErrors.AddError(fmt.Sprintf("Config Error: User [%s] %s", userId, err.Error()))
+			}
+		}
 		userConfigEnv = p.GetUserEnv(userId)
 		for locName := range userData.Locations {
 			location := p.GetUserLocPath(userId, locName)
@@ -891,6 +1005,9 @@ func (p *ConfigData) resolveLocations(createDir bool, configErrors *ConfigErrorD
 			}
 			userData.Locations[locName] = f
 		}
+		for locName, lp := range userData.Policies {
+			p.validateLocationPolicy(userId, locName, lp, configErrors)
+		}
 	}
 
 	return p
@@ -971,7 +1088,7 @@ func (p *ConfigData) SaveMe() error {
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile(p.ConfigName, []byte(s), os.ModePerm)
+	err = WriteFileAtomic(p.ConfigName, []byte(s), 0644)
 	if err != nil {
 		return err
 	}
@@ -982,6 +1099,176 @@ func (p *ConfigData) GetServerName() string {
 	return p.ConfigFileData.ServerName
 }
 
+func (p *ConfigData) GetSessionKey() string {
+	return p.ConfigFileData.SessionKey
+}
+
+func (p *ConfigData) GetSessionDuration() time.Duration {
+	if p.ConfigFileData.SessionMinutes <= 0 {
+		return time.Duration(defaultSessionMinutes) * time.Minute
+	}
+	return time.Duration(p.ConfigFileData.SessionMinutes) * time.Minute
+}
+
+/*
+Returns 0 if the config files should not be checked for changes.
+*/
+func (p *ConfigData) GetReloadInterval() time.Duration {
+	if p.ConfigFileData.ReloadConfigSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(p.ConfigFileData.ReloadConfigSeconds) * time.Second
+}
+
+/*
+Returns the files that, if changed, require the config to be re-loaded.
+
+The config file, the UserDataPath file and the template DataFile.
+*/
+func (p *ConfigData) GetWatchedFiles() []string {
+	list := []string{}
+	f, err := filepath.Abs(p.ConfigName)
+	if err == nil {
+		list = append(list, f)
+	}
+	if p.ConfigFileData.UserDataPath != "" {
+		list = append(list, p.ConfigFileData.UserDataPath)
+	}
+	swd := p.ConfigFileData.StaticWebData
+	if swd != nil && swd.TemplateStaticFiles != nil && swd.TemplateStaticFiles.dataFilePath != "" {
+		list = append(list, swd.TemplateStaticFiles.dataFilePath)
+	}
+	return list
+}
+
+func (p *ConfigData) GetShutdownTimeout() time.Duration {
+	if p.ConfigFileData.ShutdownSeconds <= 0 {
+		return time.Duration(defaultShutdownSeconds) * time.Second
+	}
+	return time.Duration(p.ConfigFileData.ShutdownSeconds) * time.Second
+}
+
+func (p *ConfigData) GetUploadExpiry() time.Duration {
+	if p.ConfigFileData.UploadExpiryMinutes <= 0 {
+		return time.Duration(defaultUploadExpiryMinutes) * time.Minute
+	}
+	return time.Duration(p.ConfigFileData.UploadExpiryMinutes) * time.Minute
+}
+
+func (p *ConfigData) GetTrashRetention() time.Duration {
+	if p.ConfigFileData.TrashRetentionDays <= 0 {
+		return time.Duration(defaultTrashRetentionDays) * 24 * time.Hour
+	}
+	return time.Duration(p.ConfigFileData.TrashRetentionDays) * 24 * time.Hour
+}
+
+/*
+The thumbnail settings with defaults for undefined values.
+*/
+func (p *ConfigData) GetThumbnailData() *ThumbnailData {
+	td := ThumbnailData{}
+	if p.ConfigFileData.Thumbnails != nil {
+		td = *p.ConfigFileData.Thumbnails
+	}
+	if td.Location == "" {
+		td.Location = defaultThumbnailLocation
+	}
+	if td.Size <= 0 {
+		td.Size = defaultThumbnailSize
+	}
+	if td.Quality <= 0 || td.Quality > 100 {
+		td.Quality = defaultThumbnailQuality
+	}
+	if td.MaxConcurrent <= 0 {
+		td.MaxConcurrent = defaultThumbnailConcurrent
+	}
+	return &td
+}
+
+/*
+The share link settings with defaults for undefined values.
+*/
+func (p *ConfigData) GetShareData() *ShareData {
+	sd := ShareData{}
+	if p.ConfigFileData.Shares != nil {
+		sd = *p.ConfigFileData.Shares
+	}
+	if sd.MaxDays <= 0 {
+		sd.MaxDays = defaultShareMaxDays
+	}
+	if sd.DefaultHours <= 0 {
+		sd.DefaultHours = defaultShareHours
+	}
+	sd.DefaultHours = min(sd.DefaultHours, sd.MaxDays*24)
+	return &sd
+}
+
+/*
+The resized picture cache settings with defaults for undefined values.
+*/
+func (p *ConfigData) GetImageCacheData() *ImageCacheData {
+	ic := ImageCacheData{}
+	if p.ConfigFileData.ImageCache != nil {
+		ic = *p.ConfigFileData.ImageCache
+	}
+	if ic.Path == "" {
+		ic.Path = filepath.Join(os.TempDir(), "goWebAppImageCache")
+	}
+	if ic.MaxMB <= 0 {
+		ic.MaxMB = defaultImageCacheMB
+	}
+	if ic.Quality <= 0 || ic.Quality > 100 {
+		ic.Quality = defaultImageCacheQuality
+	}
+	return &ic
+}
+
+/*
+The number of previous versions to keep when a file in the location is replaced.
+A 'user.loc' entry is used before a 'loc' entry. 0 if neither is defined.
+*/
+func (p *ConfigData) GetKeepVersions(user string, loc string) int {
+	n, ok := p.ConfigFileData.KeepVersions[user+"."+loc]
+	if !ok {
+		n = p.ConfigFileData.KeepVersions[loc]
+	}
+	return max(n, 0)
+}
+
+/*
+Returns the path of every location of every user. Each path is only returned once.
+*/
+func (p *ConfigData) GetLocationPaths() []string {
+	list := []string{}
+	for _, u := range p.ConfigFileData.Users {
+		for _, l := range u.Locations {
+			if !slices.Contains(list, l) {
+				list = append(list, l)
+			}
+		}
+	}
+	slices.Sort(list)
+	return list
+}
+
+func (p *ConfigData) IsTLS() bool {
+	return p.ConfigFileData.TLSCertFile != "" && p.ConfigFileData.TLSKeyFile != ""
+}
+
+func (p *ConfigData) GetTLSFiles() (string, string) {
+	return p.ConfigFileData.TLSCertFile, p.ConfigFileData.TLSKeyFile
+}
+
+/*
+Returns an empty string if there is no HTTP redirect port.
+*/
+func (p *ConfigData) GetRedirectPortString() string {
+	if !p.IsTLS() || p.ConfigFileData.HTTPRedirectPort <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(":%d", p.ConfigFileData.HTTPRedirectPort)
+}
+
 func (p *ConfigData) GetExecPath() string {
 	return p.ConfigFileData.ExecPath
 }
@@ -1027,6 +1314,13 @@ func (p *ConfigData) GetUserRoot(user string) string {
 	return p.resolvePaths(p.GetUserData(user).Home, p.GetServerDataRoot(), "")
 }
 
+/*
+The file holding the share links of a user. It is in the server log directory so it cannot be reached through a user location.
+*/
+func (p *ConfigData) GetUserSharesFile(user string) string {
+	return filepath.Join(p.GetLogDataPath(), SharesDirName, user+".json")
+}
+
 func (p *ConfigData) GetUserNamesList() []string {
 	unl := []string{}
 	for na, u := range p.ConfigFileData.Users {
@@ -1154,6 +1448,37 @@ func (p *ConfigData) GetUserLocPath(user string, loc string) string {
 	return locData
 }
 
+/*
+PathInLocation returns true if 'file' is the location directory 'root' or is within it.
+*/
+func PathInLocation(root string, file string) bool {
+	rel, err := filepath.Rel(root, file)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+/*
+IsServerDirName returns true if 'name' is a directory the server keeps for itself (uploads, trash and versions).
+*/
+func IsServerDirName(name string) bool {
+	return name == UploadDirName || name == TrashDirName || name == VersionsDirName
+}
+
+/*
+InServerDir returns true if 'file' is, or is within, a server directory (see IsServerDirName) below 'root'.
+*/
+func InServerDir(root string, file string) bool {
+	rel, err := filepath.Rel(root, file)
+	if err != nil {
+		return false
+	}
+	for _, s := range strings.Split(rel, string(filepath.Separator)) {
+		if IsServerDirName(s) {
+			return true
+		}
+	}
+	return false
+}
+
 // PANIC
 func (p *ConfigData) GetExecInfo(execid string) *ExecInfo {
 	exec, ok := p.ConfigFileData.Exec[execid]
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Returns some data then fails. Simulates a client that goes away (or a crash) mid write.
+type failingReader struct {
+	data string
+	done bool
+}
+
+func (r *failingReader) Read(b []byte) (int, error) {
+	if r.done {
+		return 0, errors.New("connection lost")
+	}
+	r.done = true
+	return copy(b, r.data), nil
+}
+
+func assertFileContent(t *testing.T, file string, expected string) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %s", file, err.Error())
+	}
+	if string(b) != expected {
+		t.Fatalf("File %s content '%s' expected '%s'", file, string(b), expected)
+	}
+}
+
+func assertOnlyFiles(t *testing.T, dir string, expected ...string) {
+	entries, _ := os.ReadDir(dir)
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Dir contains %v expected %v. Temp files must not be left behind", names, expected)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "state.json")
+
+	err := WriteFileAtomic(file, []byte("{\"v\":1}"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFileContent(t, file, "{\"v\":1}")
+	st, _ := os.Stat(file)
+	if st.Mode().Perm() != 0640 {
+		t.Fatalf("File mode is %s", st.Mode().Perm())
+	}
+
+	// A failed write does not change the file or leave a temp file
+	_, err = WriteFileAtomicFrom(file, &failingReader{data: "{\"v\":"}, 0644, true)
+	if err == nil {
+		t.Fatalf("Write should fail")
+	}
+	assertFileContent(t, file, "{\"v\":1}")
+	assertOnlyFiles(t, dir, "state.json")
+
+	err = WriteFileAtomic(file, []byte("{\"v\":2}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFileContent(t, file, "{\"v\":2}")
+
+	// Do not replace an existing file
+	_, err = WriteFileAtomicFrom(file, strings.NewReader("other"), 0644, false)
+	if !errors.Is(err, os.ErrExist) {
+		t.Fatalf("Expected ErrExist not %v", err)
+	}
+	assertFileContent(t, file, "{\"v\":2}")
+	n, err := WriteFileAtomicFrom(filepath.Join(dir, "new.json"), strings.NewReader("new"), 0644, false)
+	if err != nil || n != 3 {
+		t.Fatalf("New file not written. n=%d err=%v", n, err)
+	}
+	assertOnlyFiles(t, dir, "new.json", "state.json")
+}
+
+func TestAppendFileDurable(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "log.txt")
+	err := AppendFileDurable(file, []byte("line1\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AppendFileDurable(file, []byte("line2\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFileContent(t, file, "line1\nline2\n")
+
+	// A failed append leaves the file as it was
+	_, err = AppendFileDurableFrom(file, &failingReader{data: "partial"}, 0644)
+	if err == nil {
+		t.Fatalf("Append should fail")
+	}
+	assertFileContent(t, file, "line1\nline2\n")
+
+	// A failed append to a new file does not leave an empty file
+	_, err = AppendFileDurableFrom(filepath.Join(dir, "new.txt"), &failingReader{data: "partial"}, 0644)
+	if err == nil {
+		t.Fatalf("Append should fail")
+	}
+	assertOnlyFiles(t, dir, "log.txt")
+	_, err = AppendFileDurableFrom(file, io.LimitReader(strings.NewReader("line3\nextra"), 6), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFileContent(t, file, "line1\nline2\nline3\n")
+}
+
+func TestUserPropertiesAtomic(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "props.json")
+	up, err := NewUserProperties(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFileContent(t, file, "{}")
+	up.Update("stuart.a", "1")
+	assertFileContent(t, file, "{\"stuart.a\":\"1\"}")
+	assertOnlyFiles(t, dir, "props.json")
+}
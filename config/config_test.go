@@ -1,9 +1,12 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestPanicMessage(t *testing.T) {
@@ -256,6 +259,178 @@ func TestGetUserLocPath(t *testing.T) {
 
 }
 
+func TestPasswordHash(t *testing.T) {
+	h, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %s", err.Error())
+	}
+	if !strings.HasPrefix(h, "pbkdf2-sha256$") {
+		t.Fatalf("Hash has wrong format: %s", h)
+	}
+	if !CheckPasswordHash("secret", h) {
+		t.Fatalf("Hash should match password")
+	}
+	if CheckPasswordHash("Secret", h) {
+		t.Fatalf("Hash should not match wrong password")
+	}
+	if CheckPasswordHash("secret", "plain-text") {
+		t.Fatalf("Invalid hash should never match")
+	}
+	_, err = HashPassword("")
+	if err == nil {
+		t.Fatalf("Empty password should not be hashed")
+	}
+}
+
+func TestSessionNotSaved(t *testing.T) {
+	// Saved config data only has the session values if they were defined
+	b, _ := json.Marshal(&ConfigDataFromFile{})
+	if strings.Contains(string(b), "Session") {
+		t.Fatalf("Session values should be left out:%s", string(b))
+	}
+	b, _ = json.Marshal(&ConfigDataFromFile{SessionKey: "key", SessionMinutes: 5})
+	if !strings.Contains(string(b), "\"SessionKey\":\"key\"") || !strings.Contains(string(b), "\"SessionMinutes\":5") {
+		t.Fatalf("Session values should be saved:%s", string(b))
+	}
+	if (&ConfigData{ConfigFileData: &ConfigDataFromFile{}}).GetSessionDuration() != defaultSessionMinutes*time.Minute {
+		t.Fatalf("Default session duration")
+	}
+}
+
+func TestCheckUserAccess(t *testing.T) {
+	conf := LoadConfigData(t, "../goWebAppTest.json", nil)
+	h, _ := HashPassword("secret")
+	ud := conf.ConfigFileData.Users["stuart"]
+	ud.PasswordHash = h
+	conf.ConfigFileData.Users["stuart"] = ud
+
+	assertAccess := func(user, identity string, status int) {
+		t.Helper()
+		defer func() {
+			r := recover()
+			if status == 0 {
+				if r != nil {
+					t.Fatalf("User=%s Identity=%s should not panic: %v", user, identity, r)
+				}
+				return
+			}
+			pm, ok := r.(*LoggableErrorWithStatus)
+			if !ok || pm == nil {
+				t.Fatalf("User=%s Identity=%s should have returned a ServerError", user, identity)
+			}
+			AssertEquals(t, "TestCheckUserAccess", fmt.Sprintf("%d", pm.Status()), fmt.Sprintf("%d", status))
+		}()
+		conf.CheckUserAccess(user, identity)
+	}
+	assertAccess("bob", "", 0)
+	assertAccess("stuart", "stuart", 0)
+	assertAccess("stuart", "", 401)
+	assertAccess("stuart", "bob", 403)
+	// Access is by role. Not by the admin user name
+	conf.ConfigFileData.Users[AdminUserName] = UserData{}
+	conf.ConfigFileData.Users["boss"] = UserData{Role: "admin"}
+	assertAccess("stuart", AdminUserName, 0)
+	assertAccess("stuart", "boss", 0)
+	assertAccess("bob", "boss", 0)
+	conf.ConfigFileData.Users[AdminUserName] = UserData{Role: "user"}
+	assertAccess("stuart", AdminUserName, 403)
+
+	if !conf.AuthenticateUser("stuart", "secret") {
+		t.Fatalf("stuart should authenticate")
+	}
+	if conf.AuthenticateUser("bob", "secret") {
+		t.Fatalf("bob has no password and should not authenticate")
+	}
+}
+
+func TestRoles(t *testing.T) {
+	conf := LoadConfigData(t, "../goWebAppTest.json", nil)
+	r, err := ParseRole("Admin")
+	if err != nil || r != RoleAdmin {
+		t.Fatalf("ParseRole(Admin) should return RoleAdmin")
+	}
+	_, err = ParseRole("root")
+	if err == nil {
+		t.Fatalf("ParseRole(root) should fail")
+	}
+	AssertEquals(t, "TestRoles guest", conf.GetRole("").String(), "guest")
+	AssertEquals(t, "TestRoles user", conf.GetRole("bob").String(), "user")
+	AssertEquals(t, "TestRoles unknown", conf.GetRole("fred").String(), "guest")
+	bob := conf.ConfigFileData.Users["bob"]
+	bob.Role = "guest"
+	conf.ConfigFileData.Users["bob"] = bob
+	AssertEquals(t, "TestRoles bob", conf.GetRole("bob").String(), "guest")
+
+	// No admin user with a password so roles are not enforced
+	if conf.RolesEnforced() {
+		t.Fatalf("Roles should not be enforced")
+	}
+	conf.CheckRole("", RoleAdmin)
+	conf.CheckExecAccess("", "ls")
+}
+
+func TestLocationPolicy(t *testing.T) {
+	ud := UserData{}
+	err := json.Unmarshal([]byte(`{"Name":"S","locations":{"pics":"p1","orig":{"Path":"p2","ReadOnly":true,"Readers":["bob"],"FilterFiles":["JPEG"]}}}`), &ud)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, "Location string", ud.Locations["pics"], "p1")
+	AssertEquals(t, "Location object", ud.Locations["orig"], "p2")
+	if ud.Policies["pics"] != nil || ud.Policies["orig"] == nil || !ud.Policies["orig"].ReadOnly {
+		t.Fatalf("Policies:%v", ud.Policies)
+	}
+	b, _ := json.Marshal(ud)
+	if !strings.Contains(string(b), `"pics":"p1"`) || !strings.Contains(string(b), `"orig":{"Path":"p2","ReadOnly":true,"Readers":["bob"]`) {
+		t.Fatalf("Marshal:%s", string(b))
+	}
+	err = json.Unmarshal([]byte(`{"locations":{"pics":1}}`), &ud)
+	if err == nil {
+		t.Fatalf("A number is not a location")
+	}
+
+	conf := LoadConfigData(t, "../goWebAppTest.json", nil)
+	h, _ := HashPassword("secret")
+	stuart := conf.ConfigFileData.Users["stuart"]
+	stuart.PasswordHash = h
+	stuart.Policies = map[string]*LocationPolicy{
+		"pics": {ReadOnly: true, Readers: []string{"bob"}, FilterFiles: []string{"JPEG"}},
+		"usr":  {Allow: []string{OpRead, OpWrite}, Writers: []string{"bob"}},
+	}
+	conf.ConfigFileData.Users["stuart"] = stuart
+	errList := NewConfigErrorData()
+	conf.validateLocationPolicy("stuart", "pics", stuart.Policies["pics"], errList)
+	conf.validateLocationPolicy("stuart", "usr", &LocationPolicy{Allow: []string{"rename"}, Readers: []string{"fred"}}, errList)
+	if errList.ErrorCount() != 2 {
+		t.Fatalf("Policy errors:%s", errList.String())
+	}
+	AssertEquals(t, "Location filter", strings.Join(conf.GetLocationFilesFilter("stuart", "pics"), ","), ".jpeg")
+	AssertEquals(t, "Default filter", strings.Join(conf.GetLocationFilesFilter("stuart", "usr"), ","), strings.Join(conf.GetFilesFilter(), ","))
+
+	for _, tc := range []struct {
+		loc, identity, op string
+		status            int
+	}{
+		{"pics", "stuart", OpRead, 0},
+		{"pics", "stuart", OpWrite, 403},
+		{"pics", AdminUserName, OpDelete, 403},
+		{"pics", "bob", OpRead, 0},
+		{"pics", "bob", OpWrite, 403},
+		{"pics", "", OpRead, 401},
+		{"usr", "bob", OpWrite, 0},
+		{"usr", "bob", OpDelete, 403},
+		{"picsPlus", "bob", OpRead, 403},
+		{"picsPlus", "stuart", OpDelete, 0},
+	} {
+		status := 0
+		le := conf.LocationAccessError("stuart", tc.loc, tc.identity, tc.op)
+		if le != nil {
+			status = le.Status()
+		}
+		AssertEquals(t, fmt.Sprintf("Access %s %s %s", tc.loc, tc.identity, tc.op), fmt.Sprintf("%d", status), fmt.Sprintf("%d", tc.status))
+	}
+}
+
 func LoadConfigData(t *testing.T, name string, errList *ConfigErrorData) *ConfigData {
 	maxErr := 9
 	if errList == nil {
@@ -0,0 +1,260 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Operations on the files in a location
+const (
+	OpRead   = "read"   // List, download, search etc.
+	OpWrite  = "write"  // Upload, replace, create directories, move or copy to
+	OpDelete = "delete" // Delete files and directories, move from
+)
+
+var locationOps = []string{OpRead, OpWrite, OpDelete}
+
+/*
+Optional rules for a location. Defined by using an object for the location instead of a path:
+
+	"Locations": {
+	    "pics": "pictures",
+	    "original": {"Path": "originals", "ReadOnly": true, "Readers": ["bob"]}
+	}
+
+ReadOnly and Allow apply to everyone, including the admin user.
+Readers and Writers only matter if the user has a PasswordHash. Otherwise everyone has access.
+*/
+type LocationPolicy struct {
+	ReadOnly    bool     `json:",omitempty"` // Only OpRead is allowed
+	Allow       []string `json:",omitempty"` // The operations allowed (read, write, delete). Default is all
+	Readers     []string `json:",omitempty"` // Other users who can read the location
+	Writers     []string `json:",omitempty"` // Other users who can read and change the location
+	FilterFiles []string `json:",omitempty"` // Used instead of the top level FilterFiles for this location
+}
+
+/*
+The JSON form of a location with a policy.
+*/
+type locationJson struct {
+	Path string
+	LocationPolicy
+}
+
+/*
+Allows returns true if operation 'op' can be done in the location.
+*/
+func (lp *LocationPolicy) Allows(op string) bool {
+	if lp.ReadOnly && op != OpRead {
+		return false
+	}
+	return len(lp.Allow) == 0 || slices.Contains(lp.Allow, op)
+}
+
+/*
+A location in UserData.Locations can be a path or an object with a path and a policy.
+The path is held in Locations and the policy (if any) in Policies.
+*/
+func (p *UserData) UnmarshalJSON(b []byte) error {
+	type userData UserData
+	aux := struct {
+		*userData
+		Locations map[string]json.RawMessage
+	}{userData: (*userData)(p)}
+	err := json.Unmarshal(b, &aux)
+	if err != nil {
+		return err
+	}
+	p.Locations = make(map[string]string, len(aux.Locations))
+	p.Policies = nil
+	for name, raw := range aux.Locations {
+		if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+			lj := locationJson{}
+			err = json.Unmarshal(raw, &lj)
+			if err != nil {
+				return fmt.Errorf("location '%s' %s", name, err.Error())
+			}
+			if p.Policies == nil {
+				p.Policies = map[string]*LocationPolicy{}
+			}
+			p.Locations[name] = lj.Path
+			p.Policies[name] = &lj.LocationPolicy
+			continue
+		}
+		var path string
+		err = json.Unmarshal(raw, &path)
+		if err != nil {
+			return fmt.Errorf("location '%s' must be a path or an object. %s", name, err.Error())
+		}
+		p.Locations[name] = path
+	}
+	return nil
+}
+
+/*
+Locations without a policy are written as a path so the file is unchanged.
+*/
+func (p UserData) MarshalJSON() ([]byte, error) {
+	type userData UserData
+	locs := make(map[string]interface{}, len(p.Locations))
+	for name, path := range p.Locations {
+		lp, ok := p.Policies[name]
+		if ok {
+			locs[name] = locationJson{Path: path, LocationPolicy: *lp}
+		} else {
+			locs[name] = path
+		}
+	}
+	return json.Marshal(struct {
+		userData
+		Locations map[string]interface{}
+	}{userData: userData(p), Locations: locs})
+}
+
+/*
+Check the policy values and add the '.' to FilterFiles.
+*/
+func (p *ConfigData) validateLocationPolicy(user string, loc string, lp *LocationPolicy, configErrors *ConfigErrorData) {
+	for _, op := range lp.Allow {
+		if !slices.Contains(locationOps, op) {
+			configErrors.AddError(fmt.Sprintf("Config Error: User [%s] Location [%s] Allow '%s' is not one of %s", user, loc, op, strings.Join(locationOps, ",")))
+		}
+	}
+	for _, other := range slices.Concat(lp.Readers, lp.Writers) {
+		if !p.HasUser(other) {
+			configErrors.AddError(fmt.Sprintf("Config Error: User [%s] Location [%s] Reader or Writer '%s' is not a user", user, loc, other))
+		}
+	}
+	for i, f := range lp.FilterFiles {
+		f = strings.ToLower(f)
+		if !strings.HasPrefix(f, ".") {
+			f = "." + f
+		}
+		lp.FilterFiles[i] = f
+	}
+}
+
+/*
+Returns nil if the location has no policy.
+*/
+func (p *ConfigData) GetLocationPolicy(user string, loc string) *LocationPolicy {
+	ud, ok := p.ConfigFileData.Users[user]
+	if !ok {
+		return nil
+	}
+	return ud.Policies[loc]
+}
+
+/*
+The FilterFiles for the location. The location policy FilterFiles if defined, otherwise the top level FilterFiles.
+*/
+func (p *ConfigData) GetLocationFilesFilter(user string, loc string) []string {
+	lp := p.GetLocationPolicy(user, loc)
+	if lp != nil && len(lp.FilterFiles) > 0 {
+		return lp.FilterFiles
+	}
+	return p.GetFilesFilter()
+}
+
+/*
+CheckLocationAccess panics if 'identity' cannot do operation 'op' in location 'loc' of 'user'.
+
+The caller must be able to access the user (see CheckUserAccess) or be in the policy Readers (OpRead only) or Writers.
+Returns 401 or 403 as CheckUserAccess and 403 if the policy does not allow the operation.
+*/
+func (p *ConfigData) CheckLocationAccess(user string, loc string, identity string, op string) {
+	err := p.LocationAccessError(user, loc, identity, op)
+	if err != nil {
+		panic(err)
+	}
+}
+
+/*
+As CheckLocationAccess but the error is returned. Nil if access is allowed.
+*/
+func (p *ConfigData) LocationAccessError(user string, loc string, identity string, op string) LoggableError {
+	lp := p.GetLocationPolicy(user, loc)
+	if lp == nil {
+		return p.userAccessError(user, identity)
+	}
+	granted := identity != "" && (slices.Contains(lp.Writers, identity) || (op == OpRead && slices.Contains(lp.Readers, identity)))
+	if !granted {
+		err := p.userAccessError(user, identity)
+		if err != nil {
+			return err
+		}
+	}
+	if !lp.Allows(op) {
+		return policyError(user, loc, op, lp)
+	}
+	return nil
+}
+
+/*
+CheckPathAccess panics (403) if a location that contains 'file' does not allow operation 'op'.
+
+ReadOnly and Allow apply to the files in a location whatever url is used to reach them.
+For example a read only location within another users Home or nested within another location of the same user.
+*/
+func (p *ConfigData) CheckPathAccess(file string, op string) {
+	err := p.PathAccessError(file, op)
+	if err != nil {
+		panic(err)
+	}
+}
+
+/*
+As CheckPathAccess but the error is returned. Nil if access is allowed.
+*/
+func (p *ConfigData) PathAccessError(file string, op string) LoggableError {
+	for user, ud := range p.ConfigFileData.Users {
+		for loc, lp := range ud.Policies {
+			if !lp.Allows(op) && PathInLocation(ud.Locations[loc], file) {
+				return policyError(user, loc, op, lp)
+			}
+		}
+	}
+	return nil
+}
+
+/*
+CheckTreeAccess panics (403) as CheckPathAccess or if a location within directory 'dir' does not allow operation 'op'.
+
+Used before a directory and everything in it is deleted or moved. A read only location nested
+within the directory is not removed with it.
+*/
+func (p *ConfigData) CheckTreeAccess(dir string, op string) {
+	err := p.TreeAccessError(dir, op)
+	if err != nil {
+		panic(err)
+	}
+}
+
+/*
+As CheckTreeAccess but the error is returned. Nil if access is allowed.
+*/
+func (p *ConfigData) TreeAccessError(dir string, op string) LoggableError {
+	err := p.PathAccessError(dir, op)
+	if err != nil {
+		return err
+	}
+	for user, ud := range p.ConfigFileData.Users {
+		for loc, lp := range ud.Policies {
+			if !lp.Allows(op) && PathInLocation(dir, ud.Locations[loc]) {
+				return policyError(user, loc, op, lp)
+			}
+		}
+	}
+	return nil
+}
+
+func policyError(user string, loc string, op string, lp *LocationPolicy) LoggableError {
+	if lp.ReadOnly {
+		return NewServerError("Location is read only", http.StatusForbidden, fmt.Sprintf("User=%s Location=%s Operation=%s", user, loc, op))
+	}
+	return NewServerError("Operation not allowed", http.StatusForbidden, fmt.Sprintf("User=%s Location=%s Operation=%s", user, loc, op))
+}
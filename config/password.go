@@ -0,0 +1,126 @@
+package config
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const passwordHashPrefix = "pbkdf2-sha256"
+const passwordHashIterations = 60000
+const passwordHashSaltLen = 16
+const passwordHashKeyLen = 32
+
+/*
+HashPassword returns a hash of the password that can be stored in UserData.PasswordHash.
+
+Format is: pbkdf2-sha256$<iterations>$<base64 salt>$<base64 key>
+*/
+func HashPassword(password string) (string, error) {
+	if password == "" {
+		return "", fmt.Errorf("password is empty")
+	}
+	salt := make([]byte, passwordHashSaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordHashIterations, passwordHashKeyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s$%d$%s$%s", passwordHashPrefix, passwordHashIterations, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+/*
+CheckPasswordHash returns true if the password matches the hash created by HashPassword.
+
+An invalid hash never matches.
+*/
+func CheckPasswordHash(password string, hash string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != passwordHashPrefix {
+		return false
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil || iter < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iter, len(expected))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
+
+func validatePasswordHash(hash string) error {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != passwordHashPrefix {
+		return fmt.Errorf("PasswordHash is not a '%s' hash. Use the 'hash=' command line option to create one", passwordHashPrefix)
+	}
+	return nil
+}
+
+/*
+CheckUserAccess panics if 'identity' is not allowed to access the resources of 'user'.
+
+Users without a PasswordHash are open to all callers.
+Users with a PasswordHash can only be accessed by themselves or by a user with RoleAdmin.
+
+Returns 401 if there is no identity and 403 if the identity is a different user.
+*/
+func (p *ConfigData) CheckUserAccess(user string, identity string) {
+	err := p.userAccessError(user, identity)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (p *ConfigData) userAccessError(user string, identity string) LoggableError {
+	if identity == user || p.GetRole(identity) == RoleAdmin {
+		return nil
+	}
+	if !p.UserRequiresAuth(user) {
+		return nil
+	}
+	if identity == "" {
+		return NewServerError("Authentication required", http.StatusUnauthorized, fmt.Sprintf("User=%s. No credentials", user))
+	}
+	return NewServerError("Access denied", http.StatusForbidden, fmt.Sprintf("User=%s. Authenticated as %s", user, identity))
+}
+
+/*
+UserRequiresAuth returns true if the user has a PasswordHash.
+*/
+func (p *ConfigData) UserRequiresAuth(user string) bool {
+	ud, ok := p.ConfigFileData.Users[user]
+	if !ok {
+		return false
+	}
+	return ud.HasPassword()
+}
+
+/*
+AuthenticateUser returns true if the user exists, has a PasswordHash and the password matches it.
+*/
+func (p *ConfigData) AuthenticateUser(user string, password string) bool {
+	ud, ok := p.ConfigFileData.Users[user]
+	if !ok || !ud.HasPassword() {
+		return false
+	}
+	return CheckPasswordHash(password, ud.PasswordHash)
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+/*
+Role controls which server endpoints a caller can use.
+
+Roles are ordered. A caller with a higher role can do anything a lower role can.
+Callers without credentials are always RoleGuest.
+*/
+type Role int
+
+const (
+	RoleGuest Role = iota // Read only access to open resources
+	RoleUser              // Can change their own resources and run Exec ids they are permitted to run
+	RoleAdmin             // Can do everything including server control
+)
+
+var roleNames = []string{"guest", "user", "admin"}
+
+func (r Role) String() string {
+	if r < RoleGuest || r > RoleAdmin {
+		return fmt.Sprintf("role(%d)", int(r))
+	}
+	return roleNames[r]
+}
+
+/*
+ParseRole returns the Role for the name. Names are not case sensitive.
+*/
+func ParseRole(name string) (Role, error) {
+	i := slices.Index(roleNames, strings.ToLower(strings.TrimSpace(name)))
+	if i < 0 {
+		return RoleGuest, fmt.Errorf("role '%s' is not one of %s", name, strings.Join(roleNames, ","))
+	}
+	return Role(i), nil
+}
+
+/*
+GetRole returns the role of an authenticated user.
+
+If the user does not define a Role the admin user is RoleAdmin and all other users are RoleUser.
+An empty identity or unknown user is RoleGuest.
+*/
+func (p *ConfigData) GetRole(identity string) Role {
+	if identity == "" {
+		return RoleGuest
+	}
+	ud, ok := p.ConfigFileData.Users[identity]
+	if !ok {
+		return RoleGuest
+	}
+	if ud.Role == "" {
+		if identity == AdminUserName {
+			return RoleAdmin
+		}
+		return RoleUser
+	}
+	r, err := ParseRole(ud.Role)
+	if err != nil {
+		return RoleGuest
+	}
+	return r
+}
+
+/*
+RolesEnforced returns true if the admin user has a PasswordHash.
+
+Until the admin user is protected there is no way to authenticate as admin so the server remains open.
+*/
+func (p *ConfigData) RolesEnforced() bool {
+	return p.UserRequiresAuth(AdminUserName)
+}
+
+/*
+CheckRole panics if 'identity' does not have the 'required' role.
+
+Returns 401 if there is no identity and 403 if the identity has a lower role.
+*/
+func (p *ConfigData) CheckRole(identity string, required Role) {
+	if !p.RolesEnforced() {
+		return
+	}
+	role := p.GetRole(identity)
+	if role >= required {
+		return
+	}
+	if identity == "" {
+		panic(NewServerError("Authentication required", http.StatusUnauthorized, fmt.Sprintf("Role %s required. No credentials", required)))
+	}
+	panic(NewServerError("Access denied", http.StatusForbidden, fmt.Sprintf("Role %s required. %s has role %s", required, identity, role)))
+}
+
+/*
+CheckExecAccess panics with 403 if 'identity' cannot run the Exec id.
+
+Callers with RoleAdmin can run any Exec id. Other users must be listed in the Exec 'Users' list.
+*/
+func (p *ConfigData) CheckExecAccess(identity string, execId string) {
+	if !p.RolesEnforced() || p.GetRole(identity) >= RoleAdmin {
+		return
+	}
+	exec, ok := p.ConfigFileData.Exec[execId]
+	if ok && identity != "" && slices.Contains(exec.Users, identity) {
+		return
+	}
+	if identity == "" {
+		panic(NewServerError("Authentication required", http.StatusUnauthorized, fmt.Sprintf("exec-id=%s. No credentials", execId)))
+	}
+	panic(NewServerError("Access denied", http.StatusForbidden, fmt.Sprintf("exec-id=%s. %s is not permitted", execId, identity)))
+}
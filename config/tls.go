@@ -0,0 +1,45 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+/*
+Resolve and check TLSCertFile and TLSKeyFile.
+
+Both must be defined or neither. The files are substituted like other paths and the pair must load.
+*/
+func (p *ConfigData) resolveTLS(userConfigEnv map[string]string, configErrors *ConfigErrorData) {
+	cfd := p.ConfigFileData
+	if cfd.TLSCertFile == "" && cfd.TLSKeyFile == "" {
+		if cfd.HTTPRedirectPort > 0 {
+			configErrors.AddError("Config Error: HTTPRedirectPort requires TLSCertFile and TLSKeyFile")
+		}
+		return
+	}
+	if cfd.TLSCertFile == "" || cfd.TLSKeyFile == "" {
+		configErrors.AddError("Config Error: TLSCertFile and TLSKeyFile must both be defined")
+		return
+	}
+	cert, e := p.checkRootPathExists(cfd.TLSCertFile, userConfigEnv, false)
+	if e != nil {
+		configErrors.AddError(fmt.Sprintf("Config Error: TLSCertFile %s", e))
+		return
+	}
+	key, e := p.checkRootPathExists(cfd.TLSKeyFile, userConfigEnv, false)
+	if e != nil {
+		configErrors.AddError(fmt.Sprintf("Config Error: TLSKeyFile %s", e))
+		return
+	}
+	_, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		configErrors.AddError(fmt.Sprintf("Config Error: TLSCertFile and TLSKeyFile could not be loaded. %s", err.Error()))
+		return
+	}
+	if cfd.HTTPRedirectPort > 0 && cfd.HTTPRedirectPort == cfd.Port {
+		configErrors.AddError(fmt.Sprintf("Config Error: HTTPRedirectPort %d cannot be the same as Port", cfd.HTTPRedirectPort))
+	}
+	cfd.TLSCertFile = cert
+	cfd.TLSKeyFile = key
+}
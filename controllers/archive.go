@@ -0,0 +1,290 @@
+package controllers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+const archiveMaxSelection = 1024 * 1024 // Max size of a POSTed list of names
+
+// Files that are already compressed are stored in a zip, not deflated again.
+var archiveStoredTypes = []string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".heic", ".mp4", ".mov", ".mp3", ".zip", ".gz", ".tgz"}
+
+type archiveEntry struct {
+	path string // Full file path
+	name string // Name in the archive. Relative to the archive dir using '/'
+	info os.FileInfo
+}
+
+/*
+Streams the files in a directory as a zip or tar.gz archive.
+
+Files are selected with the same rules as a file list. Names starting with '.' or '_' are
+excluded and file names must match FilterFiles. Sub directories are included.
+
+If the request is a POST the body is a JSON list of names (X0X encoding is allowed) in the
+directory. Only those files (or directories) are included. Names that are hidden (start
+with '.' or '_') are rejected and each file is only included once.
+*/
+type ArchiveHandler struct {
+	parameters *UrlRequestParts
+	configData *config.ConfigData
+	request    *http.Request
+	verbose    func(string)
+	dir        string
+	format     string
+	entries    []*archiveEntry
+	added      map[string]bool
+}
+
+func NewArchiveHandler(urlParts *UrlRequestParts, configData *config.ConfigData, r *http.Request, verboseFunc func(string)) *ArchiveHandler {
+	return &ArchiveHandler{
+		parameters: urlParts,
+		configData: configData,
+		request:    r,
+		verbose:    verboseFunc,
+		added:      map[string]bool{},
+	}
+}
+
+/*
+Find the files to send. Panics (before anything is written) if the request is invalid
+or there are no files.
+*/
+func (p *ArchiveHandler) Prepare() *ArchiveHandler {
+	p.format = p.parameters.GetOptionalQuery("format", "zip")
+	if p.format != "zip" && p.format != "tgz" {
+		panic(config.NewControllerError("Invalid format", http.StatusBadRequest, fmt.Sprintf("Archive: format=%s. Use zip or tgz", p.format)))
+	}
+	p.dir = p.parameters.GetUserLocPath(false, false, p.parameters.GetQueryAsBool("base64", false))
+	fd := p.configData.GetPathForDisplay(p.dir)
+	stats, err := os.Stat(p.dir)
+	if err != nil {
+		panic(config.NewControllerError("Dir not found", http.StatusNotFound, fd))
+	}
+	if !stats.IsDir() {
+		panic(config.NewControllerError("Is NOT a directory", http.StatusForbidden, fmt.Sprintf("Archive: %s is NOT a Directory", fd)))
+	}
+	roots := []string{p.dir}
+	if p.request.Method == http.MethodPost {
+		roots = p.selection()
+	}
+	for _, root := range roots {
+		err = p.addEntries(root)
+		if err != nil {
+			panic(config.NewControllerError("Dir could not be read", http.StatusUnprocessableEntity, fmt.Sprintf("Archive: %s %s", fd, err.Error())))
+		}
+	}
+	if len(p.entries) == 0 {
+		panic(config.NewControllerError("No files found", http.StatusNotFound, fmt.Sprintf("Archive: %s has no files to send", fd)))
+	}
+	return p
+}
+
+func (p *ArchiveHandler) selection() []string {
+	names := []string{}
+	err := json.NewDecoder(io.LimitReader(p.request.Body, archiveMaxSelection)).Decode(&names)
+	if err != nil {
+		panic(config.NewControllerError("Invalid list of names", http.StatusBadRequest, fmt.Sprintf("Archive: expected a JSON list of names. %s", err.Error())))
+	}
+	if len(names) == 0 {
+		panic(config.NewControllerError("No names were posted", http.StatusBadRequest, "Archive: The list of names is empty"))
+	}
+	list := make([]string, 0, len(names))
+	seen := map[string]bool{}
+	for _, n := range names {
+		n = decodeValue(n)
+		f := filepath.Join(p.dir, n)
+		rel, err := filepath.Rel(p.dir, f)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+			panic(config.NewControllerError("Invalid name", http.StatusBadRequest, fmt.Sprintf("Archive: '%s' is not in the directory", n)))
+		}
+		for _, seg := range strings.Split(rel, string(os.PathSeparator)) {
+			if strings.HasPrefix(seg, ".") || strings.HasPrefix(seg, "_") {
+				panic(config.NewControllerError("Invalid name", http.StatusBadRequest, fmt.Sprintf("Archive: '%s' is hidden", n)))
+			}
+		}
+		if seen[f] {
+			continue
+		}
+		seen[f] = true
+		_, err = os.Stat(f)
+		if err != nil {
+			panic(config.NewControllerError("File not found", http.StatusNotFound, p.configData.GetPathForDisplay(f)))
+		}
+		list = append(list, f)
+	}
+	return list
+}
+
+/*
+Add the files under root. A file already added (a name and its parent directory were
+both selected) is only added once.
+*/
+func (p *ArchiveHandler) addEntries(root string) error {
+	filter := p.parameters.GetConfigFileFilter()
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && (strings.HasPrefix(d.Name(), ".") || strings.HasPrefix(d.Name(), "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !filterDirNames(d, filter) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		if p.added[path] {
+			return nil
+		}
+		rel, err := filepath.Rel(p.dir, path)
+		if err != nil {
+			return err
+		}
+		p.added[path] = true
+		p.entries = append(p.entries, &archiveEntry{path: path, name: filepath.ToSlash(rel), info: info})
+		return nil
+	})
+}
+
+/*
+The file name for the Content-Disposition header.
+*/
+func (p *ArchiveHandler) FileName() string {
+	name := filepath.Base(p.dir)
+	if p.format == "tgz" {
+		return name + ".tar.gz"
+	}
+	return name + ".zip"
+}
+
+func (p *ArchiveHandler) MimeType() string {
+	if p.format == "tgz" {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+/*
+Write the archive to 'w'. Each file is copied straight from disk so only one buffer is held.
+
+A file that can no longer be read is left out. An error writing to 'w' stops the archive.
+*/
+func (p *ArchiveHandler) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	var err error
+	if p.format == "tgz" {
+		err = p.writeTarGz(cw)
+	} else {
+		err = p.writeZip(cw)
+	}
+	if p.verbose != nil {
+		p.verbose(fmt.Sprintf("Archive:%s Files[%d] Bytes[%d]", p.configData.GetPathForDisplay(p.dir), len(p.entries), cw.n))
+	}
+	return cw.n, err
+}
+
+func (p *ArchiveHandler) writeZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	for _, e := range p.entries {
+		hdr, err := zip.FileInfoHeader(e.info)
+		if err != nil {
+			return err
+		}
+		hdr.Name = e.name
+		hdr.Method = zip.Deflate
+		ext := strings.ToLower(filepath.Ext(e.name))
+		for _, s := range archiveStoredTypes {
+			if ext == s {
+				hdr.Method = zip.Store
+				break
+			}
+		}
+		f, err := os.Open(e.path)
+		if err != nil {
+			p.skipped(e, err)
+			continue
+		}
+		fw, err := zw.CreateHeader(hdr)
+		if err == nil {
+			_, err = io.Copy(fw, f)
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (p *ArchiveHandler) writeTarGz(w io.Writer) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, e := range p.entries {
+		f, err := os.Open(e.path)
+		if err != nil {
+			p.skipped(e, err)
+			continue
+		}
+		// The size in the header must match the data so use the size now, not when listed.
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			p.skipped(e, err)
+			continue
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			f.Close()
+			return err
+		}
+		hdr.Name = e.name
+		err = tw.WriteHeader(hdr)
+		if err == nil {
+			_, err = io.CopyN(tw, f, info.Size())
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	err := tw.Close()
+	if err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func (p *ArchiveHandler) skipped(e *archiveEntry, err error) {
+	if p.verbose != nil {
+		p.verbose(fmt.Sprintf("Archive: Skipped %s. %s", p.configData.GetPathForDisplay(e.path), err.Error()))
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -83,6 +84,12 @@ func GetFastFileName(configData *config.ConfigData, urlParts []string, url strin
 			panic(config.NewControllerError("Get File Error", http.StatusNotFound, fmt.Sprintf("Invalid path or name:%s", url)))
 		}
 	}
+	if !config.PathInLocation(loc, name) {
+		panic(config.NewControllerError("Invalid path", http.StatusForbidden, fmt.Sprintf("Path:%s is not within the location", url)))
+	}
+	if config.InServerDir(loc, name) {
+		panic(config.NewControllerError("File not found", http.StatusNotFound, fmt.Sprintf("Path:%s is a server directory", url)))
+	}
 	return name
 }
 
@@ -114,6 +121,17 @@ func (p *ReadFileHandler) Submit() *ResponseData {
 		panic(config.NewControllerError("Is a directory", http.StatusForbidden, fmt.Sprintf("%s is a Directory", fd)))
 	}
 	if p.delete {
+		if !p.parameters.GetQueryAsBool("permanent", false) {
+			item, err := moveToTrash(p.parameters, p.configData, file, stats)
+			if err != nil {
+				panic(config.NewControllerError("File could not be deleted", http.StatusUnprocessableEntity, fmt.Sprintf("File %s could not be moved to trash. %s", fd, err.Error())))
+			}
+			if p.verbose != nil {
+				p.verbose(fmt.Sprintf("Delete File:%s Trash:%s", fd, item.Id))
+			}
+			dataMap := map[string]interface{}{"error": false, "status": http.StatusAccepted, "msg": http.StatusText(http.StatusAccepted), "cause": "File deleted OK", "trashId": item.Id}
+			return NewResponseData(http.StatusAccepted).WithContentMapAsJson(dataMap, p.parameters.Query)
+		}
 		err = os.Remove(file)
 		if err != nil {
 			panic(config.NewControllerError("File could not be deleted", http.StatusUnprocessableEntity, fd))
@@ -202,6 +220,9 @@ func (p *TreeHandler) Submit() *ResponseData {
 			if err != nil {
 				return err
 			}
+			if info.IsDir() && config.IsServerDirName(info.Name()) {
+				return filepath.SkipDir
+			}
 			if info.IsDir() && !strings.HasPrefix(path, ".") && !strings.HasPrefix(path, "_") {
 				root.AddPath(path)
 			}
@@ -240,22 +261,26 @@ func (p *PostFileHandler) Submit() *ResponseData {
 	if !stats.IsDir() {
 		panic(config.NewControllerError("Is NOT a directory", http.StatusForbidden, fmt.Sprintf("PostFileHandler: %s is NOT a Directory", dir)))
 	}
+	file := p.parameters.GetUserLocPath(true, false, p.parameters.GetQueryAsBool("base64", false))
+	fd := p.configData.GetPathForDisplay(file)
+	action := p.parameters.GetOptionalQuery("action", "save")
+	if p.request.Header.Get("Content-Range") != "" {
+		return p.submitChunk(file, fd, action)
+	}
 	body, err := io.ReadAll(p.request.Body)
 	if err != nil {
 		panic(config.NewControllerError("Failed to read posted data", http.StatusBadRequest, err.Error()))
 	}
-	file := p.parameters.GetUserLocPath(true, false, p.parameters.GetQueryAsBool("base64", false))
-	fd := p.configData.GetPathForDisplay(file)
 
-	action := p.parameters.GetOptionalQuery("action", "save")
 	switch action {
 	case "append":
-		err = AppendFile(file, body, 0644)
+		err = config.AppendFileDurable(file, body, 0644)
 		if err != nil {
 			panic(config.NewControllerError("Failed to append data", http.StatusInternalServerError, fmt.Sprintf("File:%s Error:%s", fd, err.Error())))
 		}
 	case "replace":
-		err = os.WriteFile(file, body, 0644)
+		p.parameters.keepVersion(file)
+		err = config.WriteFileAtomic(file, body, 0644)
 		if err != nil {
 			panic(config.NewControllerError("Failed to save data", http.StatusInternalServerError, err.Error()))
 		}
@@ -264,7 +289,10 @@ func (p *PostFileHandler) Submit() *ResponseData {
 		if err == nil {
 			panic(config.NewControllerError("File exists", http.StatusPreconditionFailed, fmt.Sprintf("File:%s already exists", fd)))
 		}
-		err = os.WriteFile(file, body, 0644)
+		_, err = config.WriteFileAtomicFrom(file, bytes.NewReader(body), 0644, false)
+		if errors.Is(err, os.ErrExist) {
+			panic(config.NewControllerError("File exists", http.StatusPreconditionFailed, fmt.Sprintf("File:%s already exists", fd)))
+		}
 		if err != nil {
 			panic(config.NewControllerError("Failed to save data", http.StatusInternalServerError, err.Error()))
 		}
@@ -275,21 +303,6 @@ func (p *PostFileHandler) Submit() *ResponseData {
 	return NewResponseData(http.StatusAccepted).WithContentWithCauseAsJson(fmt.Sprintf("File:Action:%s %s", action, fd), p.parameters.Query)
 }
 
-func AppendFile(filename string, data []byte, perm os.FileMode) error {
-	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, perm)
-	if err != nil {
-		return err
-	}
-	n, err := f.Write(data)
-	if err == nil && n < len(data) {
-		err = io.ErrShortWrite
-	}
-	if err1 := f.Close(); err == nil {
-		err = err1
-	}
-	return err
-}
-
 type ExecHandler struct {
 	parameters       *UrlRequestParts
 	makeExecResponse func(string, string, []byte, []byte, int, map[string][]string) []byte
@@ -337,7 +350,7 @@ func (p *ExecHandler) Submit() *ResponseData {
 
 	if p.execInfo.LogOutFile != "" && len(stdOut) > 0 {
 		of := p.parameters.config.SubstituteFromMap([]byte(p.execInfo.LogOutFile), p.parameters.config.GetUserEnv(userId))
-		err := os.WriteFile(filepath.Join(p.execInfo.LogDir, string(of)), stdOut, 0644)
+		err := config.WriteFileAtomic(filepath.Join(p.execInfo.LogDir, string(of)), stdOut, 0644)
 		if err != nil {
 			panic(config.NewControllerError("Failed to write stdOut to log", http.StatusInternalServerError, fmt.Sprintf("Failed to write stdOut. RC:%d Error:%s", code, err.Error())))
 		}
@@ -345,16 +358,16 @@ func (p *ExecHandler) Submit() *ResponseData {
 
 	if p.execInfo.LogErrFile != "" && len(stdErr) > 0 {
 		of := p.parameters.config.SubstituteFromMap([]byte(p.execInfo.LogErrFile), p.parameters.config.GetUserEnv(userId))
-		err := os.WriteFile(filepath.Join(p.execInfo.LogDir, string(of)), stdErr, 0644)
+		err := config.WriteFileAtomic(filepath.Join(p.execInfo.LogDir, string(of)), stdErr, 0644)
 		if err != nil {
 			panic(config.NewControllerError("Failed to write stdErr to log", http.StatusInternalServerError, fmt.Sprintf("Failed to write stdErr. RC:%d Error:%s", code, err.Error())))
 		}
 	}
 
 	if p.makeExecResponse == nil {
-		return NewResponseData(p.execInfo.NzCodeReturns).WithContentFromExecAsJson(execId, code, p.execInfo.NzCodeReturns, stdOut, stdErr, p.parameters.Query)
+		return NewResponseData(p.execInfo.NzCodeReturns).WithContentFromExecAsJson(execId, code, p.execInfo.NzCodeReturns, stdOut, stdErr, p.parameters.Query).WithExecResult(execId, code)
 	}
-	return NewResponseData(p.execInfo.NzCodeReturns).WithContentBytes(p.makeExecResponse(execId, p.execInfo.StdOutType, stdOut, stdErr, code, p.parameters.Query))
+	return NewResponseData(p.execInfo.NzCodeReturns).WithContentBytes(p.makeExecResponse(execId, p.execInfo.StdOutType, stdOut, stdErr, code, p.parameters.Query)).WithExecResult(execId, code)
 
 }
 
@@ -18,7 +18,7 @@ import (
 
 // "{\"Alloc\":\"2 MiB (2309672 B)\",\"Sys\":\"12 MiB (12672016 B)\",\"TotalAlloc\":\"2 MiB (2309672 B)\",\"configName\":\"goWebApp.json\",\"error\":false,\"reloadConfig\":3080.27,\"upSince\":\"Fri Apr  5 12:48:19 2024\",\"upTime\":\"00:08:39\"}"
 // "[{\"error\":false,}{\"Alloc\":\"1 MiB (1368424 B)\"}]"
-func GetServerStatusAsJson(configData *config.ConfigData, logFileName string, upSince time.Time, longRunningJson string) []byte {
+func GetServerStatusAsJson(configData *config.ConfigData, logFileName string, upSince time.Time, longRunningJson string, configReloadJson string) []byte {
 	var b bytes.Buffer
 	var st runtime.MemStats
 	runtime.ReadMemStats(&st)
@@ -32,6 +32,7 @@ func GetServerStatusAsJson(configData *config.ConfigData, logFileName string, up
 	writeParamAsJsonString("TotalAlloc", fmtAlloc(st.TotalAlloc), true, false, true, &b)
 	writeParamAsJsonString("Sys", fmtAlloc(st.Sys), true, false, true, &b)
 	writeParamAsJsonString("Processes", longRunningJson, false, false, true, &b)
+	writeParamAsJsonString("ConfigReload", configReloadJson, false, false, true, &b)
 	writeParamAsJsonString("OS", GetOSFreeData(configData), false, false, true, &b)
 	writeParamAsJsonString("Log_Dir", configData.GetPathForDisplay(configData.ConfigFileData.LogData.Path), true, false, true, &b)
 	writeParamAsJsonString("Log_File", logFileName, true, false, false, &b)
@@ -88,6 +89,11 @@ func treeAsJson(root *TreeDirNode, params *UrlRequestParts) []byte {
 	return buffer.Bytes()
 }
 
+/*
+List the files. Query 'index' returns the single entry at that position (the original behaviour).
+Otherwise the files are sorted and paged (see listOptions) and 'total' is the number before paging.
+Extra data is added to each file as requested (see fileMeta).
+*/
 func listFilesAsJson(ents []fs.DirEntry, params *UrlRequestParts, verbose func(string), path string, index int) []byte {
 	var buffer bytes.Buffer
 	entLen := len(ents)
@@ -97,30 +103,32 @@ func listFilesAsJson(ents []fs.DirEntry, params *UrlRequestParts, verbose func(s
 	writePathToJson(params.GetOptionalParam(PathParam, ""), PathParam, &buffer)
 	buffer.WriteString("\"files\":[")
 	count := 0
+	meta := newFileMeta(params)
 	if index != -1 {
 		if index >= entLen {
 			index = entLen - 1
 		}
 		e := ents[index]
 		if filterDirNames(e, params.GetConfigFileFilter()) {
-			writeSingleFileNameToJson(e, &buffer)
-			count++
+			inf, err := e.Info()
+			if err == nil {
+				writeSingleFileNameToJson(inf, meta, &buffer)
+				count++
+			}
 		}
+		buffer.WriteString("]}")
 	} else {
-		bufLen := buffer.Len()
-		for i := len(ents) - 1; i >= 0; i-- {
-			e := ents[i]
-			if filterDirNames(e, params.GetConfigFileFilter()) {
-				writeSingleFileNameToJson(e, &buffer)
-				bufLen = buffer.Len()
+		files := filteredFileInfo(ents, params.GetConfigFileFilter())
+		for i, inf := range newListOptions(params).page(files) {
+			if i > 0 {
 				buffer.WriteRune(',')
-				count++
 			}
+			writeSingleFileNameToJson(inf, meta, &buffer)
+			count++
 		}
-		buffer.Truncate(bufLen)
+		buffer.WriteString(fmt.Sprintf("],\"total\":%d}", len(files)))
 	}
 
-	buffer.WriteString("]}")
 	if verbose != nil {
 		verbose(fmt.Sprintf("ListFilesAsJson:%s Returned[%d]", params.config.GetPathForDisplay(path), count))
 	}
@@ -204,18 +212,16 @@ func writeJsonHeader(param *UrlRequestParts, buffer *bytes.Buffer) {
 	writeParamAsJsonString(LocationParam, param.GetOptionalParam(LocationParam, ""), true, true, false, buffer)
 }
 
-func writeSingleFileNameToJson(file fs.DirEntry, buffer *bytes.Buffer) {
-	inf, err := file.Info()
-	if err != nil {
-		return
-	}
+func writeSingleFileNameToJson(inf os.FileInfo, meta *fileMeta, buffer *bytes.Buffer) {
 	buffer.WriteString("{\"size\":")
-	buffer.WriteString(strconv.Itoa(int(inf.Size())))
+	buffer.WriteString(strconv.FormatInt(inf.Size(), 10))
 	buffer.WriteString(",\"name\":{\"name\":\"")
-	buffer.WriteString(file.Name())
+	buffer.WriteString(inf.Name())
 	buffer.WriteString("\", \"encName\":\"")
-	buffer.WriteString(encodeValue(file.Name()))
-	buffer.WriteString("\"}}")
+	buffer.WriteString(encodeValue(inf.Name()))
+	buffer.WriteString("\"}")
+	meta.write(inf, buffer)
+	buffer.WriteRune('}')
 }
 
 func writeParamAsJsonString(key string, value string, quoted bool, commaAtStart, commaAtEnd bool, buffer *bytes.Buffer) {
@@ -18,9 +18,10 @@ const LocationParam = "loc"
 const PathParam = "path"
 const NameParam = "name"
 const ExecParam = "exec"
+const IdParam = "id"
 const ScriptParam = "script"
 const ErrorParam = "error"
-const AdminName = "admin"
+const AdminName = config.AdminUserName
 const EncodedValuePrefix = "X0X"
 
 type UrlRequestParts struct {
@@ -30,23 +31,55 @@ type UrlRequestParts struct {
 	cache      *map[string]string
 	config     *config.ConfigData
 	logStr     bytes.Buffer
+	identity   string // The authenticated user. Empty if the request has no credentials
+	asAdmin    bool   // User was set by the server (not the url). Access is checked by role instead
+	operation  string // What the request does to the files in the location. See config.LocationPolicy
 }
 
-func NewUrlRequestParts(config *config.ConfigData) *UrlRequestParts {
+func NewUrlRequestParts(configData *config.ConfigData) *UrlRequestParts {
 	return &UrlRequestParts{
 		parameters: make(map[string]string),
 		Query:      make(map[string][]string),
 		Header:     make(map[string][]string),
 		cache:      nil,
-		config:     config,
+		config:     configData,
 		logStr:     *bytes.NewBuffer(make([]byte, 100)),
+		operation:  config.OpRead,
 	}
 }
 
+/*
+The FilterFiles for the location in the url. The top level FilterFiles if there is no location.
+*/
 func (p *UrlRequestParts) GetConfigFileFilter() []string {
+	if p.HasParam(UserParam) && p.HasParam(LocationParam) {
+		return p.config.GetLocationFilesFilter(p.GetParam(UserParam), p.GetParam(LocationParam))
+	}
 	return p.config.GetFilesFilter()
 }
 
+/*
+The operation (config.OpRead, OpWrite or OpDelete) checked against the location policy. The default is OpRead.
+*/
+func (p *UrlRequestParts) WithOperation(op string) *UrlRequestParts {
+	p.operation = op
+	return p
+}
+
+/*
+The location operation for the file in the url of a request. DELETE and MOVE are OpDelete. COPY only reads the url.
+Other methods that change files are OpWrite.
+*/
+func OperationForMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND", "COPY":
+		return config.OpRead
+	case http.MethodDelete, "MOVE":
+		return config.OpDelete
+	}
+	return config.OpWrite
+}
+
 func (p *UrlRequestParts) WithQuery(q map[string][]string) *UrlRequestParts {
 	p.Query = q
 	return p
@@ -57,21 +90,48 @@ func (p *UrlRequestParts) WithHeader(h map[string][]string) *UrlRequestParts {
 	return p
 }
 
+func (p *UrlRequestParts) WithIdentity(identity string) *UrlRequestParts {
+	p.identity = identity
+	return p
+}
+
+func (p *UrlRequestParts) GetIdentity() string {
+	return p.identity
+}
+
 func (p *UrlRequestParts) AsAdmin() *UrlRequestParts {
 	p.parameters[UserParam] = AdminName
+	p.asAdmin = true
 	return p
 }
 
 func (p *UrlRequestParts) WithUser(user string) *UrlRequestParts {
 	p.parameters[UserParam] = user
+	p.asAdmin = false
 	return p
 }
 
 func (p *UrlRequestParts) WithParameters(params map[string]string) *UrlRequestParts {
 	p.parameters = params
+	p.asAdmin = false
 	return p
 }
 
+/*
+A copy of the parts with a different location. Used to find related files in another location.
+*/
+func (p *UrlRequestParts) withLocation(loc string) *UrlRequestParts {
+	params := make(map[string]string, len(p.parameters))
+	for n, v := range p.parameters {
+		params[n] = v
+	}
+	params[LocationParam] = loc
+	c := NewUrlRequestParts(p.config).WithQuery(p.Query).WithHeader(p.Header).WithIdentity(p.identity).WithParameters(params)
+	c.asAdmin = p.asAdmin
+	c.operation = p.operation
+	return c
+}
+
 func (p *UrlRequestParts) RenameParameter(old, new string) *UrlRequestParts {
 	v := p.GetParam(old)
 	p.RemoveParameter(old)
@@ -208,8 +268,23 @@ func (p *UrlRequestParts) SetParam(key string, value string) {
 	p.parameters[key] = value
 }
 
+/*
+GetUser returns the user parameter from the url.
+
+Panics (401 or 403) if the user requires authentication and the authenticated identity is a different user.
+If the url has a location the location policy is also checked for the operation (see WithOperation).
+If AsAdmin was applied the server has already checked the callers role so the user is not checked.
+*/
 func (p *UrlRequestParts) GetUser() string {
-	return p.GetParam(UserParam)
+	user := p.GetParam(UserParam)
+	if !(p.asAdmin && user == AdminName) {
+		if p.HasParam(LocationParam) {
+			p.config.CheckLocationAccess(user, p.GetParam(LocationParam), p.identity, p.operation)
+		} else {
+			p.config.CheckUserAccess(user, p.identity)
+		}
+	}
+	return user
 }
 
 func (p *UrlRequestParts) GetOptionalUser(fallback string) string {
@@ -248,8 +323,15 @@ func (p *UrlRequestParts) SubstituteFromCachedMap(cmd []byte) string {
 	return p.config.SubstituteFromMap(cmd, *p.GetCachedMapFlat())
 }
 
+/*
+GetUserLocPath returns the location path joined with the path and (optionally) the name parameters.
+
+Panics (403) if the result is not within the location or a location that contains it does not allow the operation.
+Panics (404) if the result is in a directory the server keeps for itself (trash, versions and uploads).
+*/
 func (p *UrlRequestParts) GetUserLocPath(withName bool, asThumbnail bool, isBase64 bool) string {
-	ulp := p.config.GetUserLocPath(p.GetUser(), p.GetLocation())
+	root := p.config.GetUserLocPath(p.GetUser(), p.GetLocation())
+	ulp := root
 	if p.HasParam(PathParam) {
 		pat := p.GetParam(PathParam)
 		if isBase64 {
@@ -275,6 +357,13 @@ func (p *UrlRequestParts) GetUserLocPath(withName bool, asThumbnail bool, isBase
 			ulp = filepath.Join(ulp, p.config.ConvertToThumbnail(np, asThumbnail))
 		}
 	}
+	if !config.PathInLocation(root, ulp) {
+		panic(config.NewControllerError("Invalid path", http.StatusForbidden, fmt.Sprintf("Path:%s is not within the location", p.config.GetPathForDisplay(ulp))))
+	}
+	if config.InServerDir(root, ulp) {
+		panic(config.NewControllerError("File not found", http.StatusNotFound, fmt.Sprintf("Path:%s is a server directory", p.config.GetPathForDisplay(ulp))))
+	}
+	p.config.CheckPathAccess(ulp, p.operation)
 	return ulp
 }
 
@@ -286,6 +375,8 @@ type ResponseData struct {
 	MimeType   string
 	hasErrors  bool
 	logContent bool
+	execId     string // Set if the response is from a completed Exec
+	execRc     int
 }
 
 func (p *ResponseData) String() string {
@@ -371,6 +462,30 @@ func (p *ResponseData) GetHasErrors() bool {
 	return p.hasErrors
 }
 
+/*
+Add a header to the response. See ServerHandler.writeResponse.
+*/
+func (p *ResponseData) WithHeader(name string, value string) *ResponseData {
+	p.Header[name] = append(p.Header[name], value)
+	return p
+}
+
+/*
+Record the Exec id and its exit code. See ExecResult.
+*/
+func (p *ResponseData) WithExecResult(execId string, rc int) *ResponseData {
+	p.execId = execId
+	p.execRc = rc
+	return p
+}
+
+/*
+ExecResult returns the Exec id and exit code if the response is from a completed Exec.
+*/
+func (p *ResponseData) ExecResult() (string, int, bool) {
+	return p.execId, p.execRc, p.execId != ""
+}
+
 func (p *ResponseData) WithContentWithCauseAsJson(cause string, queries map[string][]string) *ResponseData {
 	p.content = statusAsJson(p.Status, cause, p.hasErrors, queries)
 	return p
@@ -1,10 +1,22 @@
 package controllers
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stuartdd/goWebApp/config"
 	"github.com/stuartdd/goWebApp/runCommand"
@@ -236,3 +248,329 @@ func loadConfigData(t *testing.T) *config.ConfigData {
 	}
 	return configData
 }
+
+func TestParseContentRange(t *testing.T) {
+	cr, err := parseContentRange("bytes 0-99/1000")
+	if err != nil || cr.start != 0 || cr.end != 99 || cr.total != 1000 || cr.query {
+		t.Fatalf("Chunk range not parsed: %v %v", cr, err)
+	}
+	cr, err = parseContentRange("bytes */1000")
+	if err != nil || !cr.query || cr.total != 1000 {
+		t.Fatalf("Query range not parsed: %v %v", cr, err)
+	}
+	for _, h := range []string{"", "items 0-1/2", "bytes 0-1", "bytes 0-1/*", "bytes 5-1/10", "bytes 0-10/10", "bytes a-b/10"} {
+		_, err = parseContentRange(h)
+		if err == nil {
+			t.Fatalf("Content-Range '%s' should be invalid", h)
+		}
+	}
+}
+
+func search(t *testing.T, conf *config.ConfigData, query string) map[string]interface{} {
+	q, _ := url.ParseQuery(query)
+	params := NewUrlRequestParts(conf).WithParameters(map[string]string{UserParam: "stuart", LocationParam: "pics"}).WithQuery(q)
+	resp := NewSearchHandler(params, conf, httptest.NewRequest("GET", "/search/user/stuart/loc/pics?"+query, nil), nil).Submit()
+	m := map[string]interface{}{}
+	err := json.Unmarshal(resp.content, &m)
+	if err != nil {
+		t.Fatalf("Search %s invalid json %s", query, string(resp.content))
+	}
+	return m
+}
+
+func searchNames(m map[string]interface{}) string {
+	names := []string{}
+	for _, f := range m["files"].([]interface{}) {
+		fm := f.(map[string]interface{})
+		names = append(names, path.Join(fm["path"].(string), fm["name"].(string)))
+	}
+	return strings.Join(names, ",")
+}
+
+func TestSearch(t *testing.T) {
+	conf := loadConfigData(t)
+	m := search(t, conf, "")
+	if searchNames(m) != "pic1.jpeg,s-testfolder/s-testdir1/testdata.json,s-testfolder/t5.json,s-testfolder/testdata2.json,t1.JSON,t2.Data" || m["more"] != false {
+		t.Fatalf("Search all: %v", m)
+	}
+	first := m["files"].([]interface{})[1].(map[string]interface{})
+	if first["path"] != "s-testfolder/s-testdir1" || first["encPath"] != encodeValue("s-testfolder/s-testdir1") || first["name"] != "testdata.json" || first["encName"] != encodeValue("testdata.json") {
+		t.Fatalf("Search encName: %v", first)
+	}
+	if n := searchNames(search(t, conf, "name=T*.json")); n != "s-testfolder/s-testdir1/testdata.json,s-testfolder/t5.json,s-testfolder/testdata2.json,t1.JSON" {
+		t.Fatalf("Search glob: %s", n)
+	}
+	if n := searchNames(search(t, conf, "regex=^t[0-9]")); n != "s-testfolder/t5.json,t1.JSON,t2.Data" {
+		t.Fatalf("Search regex: %s", n)
+	}
+	if n := searchNames(search(t, conf, "ext=jpeg,data")); n != "pic1.jpeg,t2.Data" {
+		t.Fatalf("Search ext: %s", n)
+	}
+	if n := searchNames(search(t, conf, "minSize=1000")); n != "pic1.jpeg" {
+		t.Fatalf("Search size: %s", n)
+	}
+	if n := searchNames(search(t, conf, "before=2000-01-01")); n != "" {
+		t.Fatalf("Search before: %s", n)
+	}
+	if n := searchNames(search(t, conf, "after=2000-01-01&ext=jpeg")); n != "pic1.jpeg" {
+		t.Fatalf("Search after: %s", n)
+	}
+	m = search(t, conf, "offset=1&limit=2")
+	if searchNames(m) != "s-testfolder/s-testdir1/testdata.json,s-testfolder/t5.json" || m["more"] != true {
+		t.Fatalf("Search page: %v", m)
+	}
+
+	// The walk stops at the time limit and returns what was found
+	saved := searchTimeLimit
+	searchTimeLimit = 0
+	defer func() { searchTimeLimit = saved }()
+	m = search(t, conf, "")
+	if m["timedOut"] != true || searchNames(m) != "" {
+		t.Fatalf("Search time limit: %v", m)
+	}
+}
+
+// A TIFF tag for buildTiff. Values longer than 4 bytes are stored after the IFDs
+type tiffTestEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+/*
+Build big endian TIFF data with the IFDs in order. 'links' is a tag whose value is the offset of IFD n.
+*/
+func buildTiff(ifds [][]tiffTestEntry, links map[uint16]int) []byte {
+	o := binary.BigEndian
+	offsets := []uint32{}
+	pos := uint32(8)
+	for _, ifd := range ifds {
+		offsets = append(offsets, pos)
+		pos += 2 + 12*uint32(len(ifd)) + 4
+	}
+	out := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	data := []byte{}
+	for _, ifd := range ifds {
+		out = o.AppendUint16(out, uint16(len(ifd)))
+		for _, e := range ifd {
+			out = o.AppendUint16(out, e.tag)
+			out = o.AppendUint16(out, e.typ)
+			out = o.AppendUint32(out, e.count)
+			v := e.value
+			if i, ok := links[e.tag]; ok {
+				v = o.AppendUint32(nil, offsets[i])
+			}
+			if len(v) > 4 {
+				out = o.AppendUint32(out, pos+uint32(len(data)))
+				data = append(data, v...)
+			} else {
+				out = append(out, append(v, make([]byte, 4-len(v))...)...)
+			}
+		}
+		out = o.AppendUint32(out, 0)
+	}
+	return append(out, data...)
+}
+
+func rationals(v ...uint32) []byte {
+	b := []byte{}
+	for i := 0; i < len(v); i += 2 {
+		b = binary.BigEndian.AppendUint32(b, v[i])
+		b = binary.BigEndian.AppendUint32(b, v[i+1])
+	}
+	return b
+}
+
+func TestExif(t *testing.T) {
+	ascii := func(tag uint16, s string) tiffTestEntry {
+		return tiffTestEntry{tag: tag, typ: 2, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+	}
+	tiff := buildTiff([][]tiffTestEntry{
+		{
+			ascii(0x010F, "Canon"),
+			ascii(0x0110, "Canon EOS 5D"),
+			{tag: 0x0112, typ: 3, count: 1, value: []byte{0, 6}},
+			ascii(0x0132, "2024:06:01 10:00:00"),
+			{tag: 0x8769, typ: 4, count: 1},
+			{tag: 0x8825, typ: 4, count: 1},
+		},
+		{
+			ascii(0x9003, "2023:12:25 08:30:15"),
+			{tag: 0xA002, typ: 4, count: 1, value: []byte{0, 0, 0x0F, 0xA0}},
+			{tag: 0xA003, typ: 3, count: 1, value: []byte{0x0B, 0xB8}},
+		},
+		{
+			ascii(0x0001, "S"),
+			{tag: 0x0002, typ: 5, count: 3, value: rationals(51, 1, 30, 1, 0, 1)},
+			ascii(0x0003, "E"),
+			{tag: 0x0004, typ: 5, count: 3, value: rationals(0, 1, 7, 1, 396, 10)},
+		},
+	}, map[uint16]int{0x8769: 1, 0x8825: 2})
+
+	var pic bytes.Buffer
+	jpeg.Encode(&pic, image.NewRGBA(image.Rect(0, 0, 10, 8)), nil)
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	var file bytes.Buffer
+	file.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	file.Write(binary.BigEndian.AppendUint16(nil, uint16(len(app1)+2)))
+	file.Write(app1)
+	file.Write(pic.Bytes()[2:])
+	name := filepath.Join(t.TempDir(), "exif.jpg")
+	writeToFile(t, name, file.Bytes())
+
+	data, err := readExif(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// DateTimeOriginal is used before DateTime. The frame size is used before PixelX and PixelY
+	if data.Make != "Canon" || data.Model != "Canon EOS 5D" || data.Orientation != 6 || data.DateTime != "2023-12-25T08:30:15" || data.Width != 10 || data.Height != 8 {
+		t.Fatalf("Exif:%+v", data)
+	}
+	if data.Latitude == nil || *data.Latitude != -51.5 || data.Longitude == nil || *data.Longitude != 0.1276667 {
+		t.Fatalf("Exif GPS:%v %v", data.Latitude, data.Longitude)
+	}
+
+	// Bad EXIF is ignored
+	bad := bytes.Replace(file.Bytes(), []byte("MM\x00\x2a"), []byte("XX\x00\x2a"), 1)
+	writeToFile(t, name, bad)
+	data, err = readExif(name)
+	if err != nil || data.Make != "" || data.Width != 10 {
+		t.Fatalf("Bad exif:%+v %v", data, err)
+	}
+
+	writeToFile(t, name, []byte("{\"not\":\"jpeg\"}"))
+	_, err = readExif(name)
+	if err != errNotJpeg {
+		t.Fatalf("Not jpeg:%v", err)
+	}
+}
+
+func TestOrientAndPruneImageCache(t *testing.T) {
+	// 2x1. Red then blue
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Pix = []byte{255, 0, 0, 255, 0, 0, 255, 255}
+	o := orient(img, 6) // Rotate 90 clockwise. Red at the top
+	if o.Bounds().Dx() != 1 || o.Bounds().Dy() != 2 || o.Pix[0] != 255 || o.Pix[6] != 255 {
+		t.Fatalf("Orientation 6:%v %v", o.Bounds(), o.Pix)
+	}
+	o = orient(img, 8) // Rotate 90 anti-clockwise. Blue at the top
+	if o.Bounds().Dx() != 1 || o.Bounds().Dy() != 2 || o.Pix[2] != 255 || o.Pix[4] != 255 {
+		t.Fatalf("Orientation 8:%v %v", o.Bounds(), o.Pix)
+	}
+	o = orient(img, 2)
+	if o.Bounds().Dx() != 2 || o.Pix[2] != 255 || o.Pix[4] != 255 {
+		t.Fatalf("Orientation 2:%v %v", o.Bounds(), o.Pix)
+	}
+	if orient(img, 1) != img {
+		t.Fatal("Orientation 1 should not change the image")
+	}
+
+	dir := t.TempDir()
+	now := time.Now()
+	for i, n := range []string{"old", "mid", "new"} {
+		name := filepath.Join(dir, n)
+		writeToFile(t, name, make([]byte, 100))
+		tm := now.Add(time.Duration(i) * time.Minute)
+		os.Chtimes(name, tm, tm)
+	}
+	writeToFile(t, filepath.Join(dir, ".partial"), make([]byte, 1000))
+	removed := pruneImageCache(dir, 250)
+	if len(removed) != 1 || removed[0] != "old" {
+		t.Fatalf("Pruned:%v", removed)
+	}
+	if len(pruneImageCache(dir, 250)) != 0 {
+		t.Fatal("Nothing more should be pruned")
+	}
+}
+
+func TestDecodeLimiterWaitersGetError(t *testing.T) {
+	l := newDecodeLimiter()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	first := make(chan error)
+	go func() {
+		first <- l.run("target", 1, func() error {
+			close(started)
+			<-release
+			return image.ErrFormat
+		})
+	}()
+	<-started
+	waiter := make(chan error)
+	go func() {
+		waiter <- l.run("target", 1, func() error {
+			t.Error("gen should not run for a waiter")
+			return nil
+		})
+	}()
+	// Let the waiter find the run in flight
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	if err := <-first; err != image.ErrFormat {
+		t.Fatalf("Generator error:%v", err)
+	}
+	if err := <-waiter; err != image.ErrFormat {
+		t.Fatalf("Waiter error:%v", err)
+	}
+}
+
+func TestResizeLargeReductionAndDecodeLimit(t *testing.T) {
+	// More than 2^32/255 white pixels in one box
+	img := image.NewRGBA(image.Rect(0, 0, 4200, 4100))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	r := resizeRGBA(img, 1, 1)
+	if r.Pix[0] != 255 || r.Pix[3] != 255 {
+		t.Fatalf("Large reduction:%v", r.Pix)
+	}
+
+	// A small png that says it is 100000 x 100000 pixels
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+	b := buf.Bytes()
+	ihdr := b[16:29] // Width, height and the rest of the IHDR data
+	binary.BigEndian.PutUint32(ihdr[0:4], 100000)
+	binary.BigEndian.PutUint32(ihdr[4:8], 100000)
+	binary.BigEndian.PutUint32(b[29:33], crc32.ChecksumIEEE(b[12:29]))
+	name := filepath.Join(t.TempDir(), "huge.png")
+	writeToFile(t, name, b)
+	_, err := decodeImage(name, false)
+	if err == nil || !strings.Contains(err.Error(), "100000 x 100000") {
+		t.Fatalf("Large picture should be rejected before decoding:%v", err)
+	}
+}
+
+func TestPictureTime(t *testing.T) {
+	tiff := buildTiff([][]tiffTestEntry{
+		{{tag: 0x0132, typ: 2, count: 20, value: append([]byte("2019:07:04 12:30:00"), 0)}},
+	}, nil)
+	var pic bytes.Buffer
+	jpeg.Encode(&pic, image.NewRGBA(image.Rect(0, 0, 4, 4)), nil)
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	var file bytes.Buffer
+	file.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	file.Write(binary.BigEndian.AppendUint16(nil, uint16(len(app1)+2)))
+	file.Write(app1)
+	file.Write(pic.Bytes()[2:])
+	dir := t.TempDir()
+	name := filepath.Join(dir, "taken.jpg")
+	writeToFile(t, name, file.Bytes())
+	modified := time.Date(2025, 1, 2, 3, 4, 5, 0, time.Local)
+	os.Chtimes(name, modified, modified)
+
+	// The EXIF time is used, not the time the file was copied or touched
+	info, _ := os.Stat(name)
+	if n := thumbnailName("taken.jpg", pictureTime(name, info)); n != "2019_07_04_12_30_00_taken.jpg.jpg" {
+		t.Fatalf("EXIF time:%s", n)
+	}
+	// Without EXIF the modified time is used
+	plain := filepath.Join(dir, "plain.jpg")
+	writeToFile(t, plain, pic.Bytes())
+	os.Chtimes(plain, modified, modified)
+	info, _ = os.Stat(plain)
+	if n := thumbnailName("plain.jpg", pictureTime(plain, info)); n != "2025_01_02_03_04_05_plain.jpg.jpg" {
+		t.Fatalf("Modified time:%s", n)
+	}
+}
@@ -0,0 +1,660 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+// The first part of a WebDAV url. /dav/<user>/<loc>/<path>
+const DavRoot = "dav"
+
+const davMaxXmlBody = 64 * 1024
+
+const davSupportedLock = "<D:lockentry><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>" +
+	"<D:lockentry><D:lockscope><D:shared/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>"
+
+/*
+A resolved /dav/<user>/<loc>/<path> url. For /dav/<user> (the users locations) 'loc' and 'file' are empty.
+*/
+type davTarget struct {
+	user string
+	loc  string
+	rel  string // Slash separated path in the location. Empty for the location
+	file string // File system path
+}
+
+func (t *davTarget) href(isDir bool) string {
+	h := "/" + DavRoot + "/" + url.PathEscape(t.user) + "/"
+	if t.loc != "" {
+		h = h + url.PathEscape(t.loc) + "/"
+	}
+	if t.rel != "" {
+		for _, s := range strings.Split(t.rel, "/") {
+			h = h + url.PathEscape(s) + "/"
+		}
+		if !isDir {
+			h = strings.TrimSuffix(h, "/")
+		}
+	}
+	return h
+}
+
+func (t *davTarget) child(name string) *davTarget {
+	c := *t
+	c.rel = strings.TrimPrefix(t.rel+"/"+name, "/")
+	c.file = filepath.Join(t.file, name)
+	return &c
+}
+
+type DavHandler struct {
+	configData *config.ConfigData
+	identity   string
+	verbose    func(string)
+}
+
+/*
+WebDAV (class 1 and 2) access to the users locations so they can be mounted as a network drive.
+
+	/dav/<user>                   The users locations. Read only
+	/dav/<user>/<loc>/<path>      Files and directories in a location
+
+The same rules as the rest of the server apply. Names starting with '.' or '_' are hidden and files
+must match FilterFiles. Deleted files go to the trash and replaced files keep versions.
+The caller must be able to access the user or location (see CheckLocationAccess).
+*/
+func NewDavHandler(configData *config.ConfigData, identity string, verboseFunc func(string)) *DavHandler {
+	return &DavHandler{
+		configData: configData,
+		identity:   identity,
+		verbose:    verboseFunc,
+	}
+}
+
+/*
+The role needed for a WebDAV method. Methods that change files need RoleUser.
+*/
+func DavMethodRole(method string) config.Role {
+	switch method {
+	case http.MethodOptions, http.MethodGet, http.MethodHead, "PROPFIND":
+		return config.RoleGuest
+	}
+	return config.RoleUser
+}
+
+/*
+Handle the request. Errors panic with a LoggableError before anything is written.
+*/
+func (p *DavHandler) Serve(w http.ResponseWriter, r *http.Request) {
+	t := p.resolve(r.URL.Path, OperationForMethod(r.Method))
+	if p.verbose != nil {
+		p.verbose(fmt.Sprintf("DAV:%s %s", r.Method, t.href(false)))
+	}
+	if r.Method == http.MethodOptions {
+		w.Header().Set("DAV", "1, 2")
+		w.Header().Set("MS-Author-Via", "DAV")
+		w.Header().Set("Allow", "OPTIONS, PROPFIND, PROPPATCH, GET, HEAD, PUT, DELETE, MKCOL, COPY, MOVE, LOCK, UNLOCK")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method == "PROPFIND" {
+		p.propfind(w, r, t)
+		return
+	}
+	if t.loc == "" {
+		panic(config.NewControllerError("Method not allowed", http.StatusMethodNotAllowed, fmt.Sprintf("DAV: %s %s is read only", r.Method, t.href(true))))
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		p.get(w, r, t)
+	case http.MethodPut:
+		p.put(w, r, t)
+	case http.MethodDelete:
+		p.delete(w, r, t)
+	case "MKCOL":
+		p.mkcol(w, r, t)
+	case "COPY", "MOVE":
+		p.copyMove(w, r, t, r.Method == "MOVE")
+	case "LOCK":
+		p.lock(w, r, t)
+	case "UNLOCK":
+		p.unlock(w, r, t)
+	case "PROPPATCH":
+		p.proppatch(w, r, t)
+	default:
+		panic(config.NewControllerError("Method not allowed", http.StatusMethodNotAllowed, fmt.Sprintf("DAV: %s %s", r.Method, t.href(false))))
+	}
+}
+
+/*
+Resolve the url path. Panics 404 for hidden names and 401 or 403 if the caller cannot do 'op' in the location
+or in a location that contains the file.
+*/
+func (p *DavHandler) resolve(urlPath string, op string) *davTarget {
+	segments := []string{}
+	for _, s := range strings.Split(urlPath, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	if len(segments) < 2 || segments[0] != DavRoot {
+		panic(config.NewControllerError("Resource not found", http.StatusNotFound, fmt.Sprintf("DAV: %s has no user", urlPath)))
+	}
+	t := &davTarget{user: segments[1]}
+	if p.configData.GetUserData(t.user) == nil {
+		panic(config.NewControllerError("User not found", http.StatusNotFound, fmt.Sprintf("DAV: User=%s", t.user)))
+	}
+	if len(segments) == 2 {
+		if len(p.readableLocations(t.user)) == 0 {
+			p.configData.CheckUserAccess(t.user, p.identity)
+		}
+		return t
+	}
+	t.loc = segments[2]
+	p.configData.CheckLocationAccess(t.user, t.loc, p.identity, op)
+	root := p.configData.GetUserLocPath(t.user, t.loc)
+	for _, s := range segments[3:] {
+		if s == ".." || strings.HasPrefix(s, ".") || strings.HasPrefix(s, "_") {
+			panic(config.NewControllerError("Resource not found", http.StatusNotFound, fmt.Sprintf("DAV: %s is hidden", urlPath)))
+		}
+	}
+	t.rel = strings.Join(segments[3:], "/")
+	t.file = filepath.Join(root, filepath.FromSlash(t.rel))
+	p.configData.CheckPathAccess(t.file, op)
+	return t
+}
+
+/*
+The users locations the caller can read. A location policy can allow other users to read it.
+*/
+func (p *DavHandler) readableLocations(user string) []string {
+	locs := []string{}
+	for loc := range p.configData.GetUserData(user).Locations {
+		if p.configData.LocationAccessError(user, loc, p.identity, config.OpRead) == nil {
+			locs = append(locs, loc)
+		}
+	}
+	slices.Sort(locs)
+	return locs
+}
+
+/*
+Stat the target. Panics 404 if it is not there or would not be in a file list.
+*/
+func (p *DavHandler) stat(t *davTarget) os.FileInfo {
+	info, err := os.Stat(t.file)
+	if err != nil || !p.visible(info, p.filter(t)) {
+		panic(config.NewControllerError("Resource not found", http.StatusNotFound, fmt.Sprintf("DAV: %s", p.configData.GetPathForDisplay(t.file))))
+	}
+	return info
+}
+
+func (p *DavHandler) visible(info os.FileInfo, filter []string) bool {
+	if info.IsDir() {
+		return !strings.HasPrefix(info.Name(), ".") && !strings.HasPrefix(info.Name(), "_")
+	}
+	return filterFileNames(info.Name(), filter)
+}
+
+func (p *DavHandler) filter(t *davTarget) []string {
+	return p.configData.GetLocationFilesFilter(t.user, t.loc)
+}
+
+/*
+Panics 403 if the target file would not be in a file list (FilterFiles) or is a location.
+*/
+func (p *DavHandler) checkFileName(t *davTarget) {
+	if t.rel == "" {
+		panic(config.NewControllerError("Location cannot be replaced", http.StatusForbidden, fmt.Sprintf("DAV: %s is a location", t.href(true))))
+	}
+	if !filterFileNames(path.Base(t.rel), p.filter(t)) {
+		panic(config.NewControllerError("File type not allowed", http.StatusForbidden, fmt.Sprintf("DAV: %s does not match FilterFiles", t.rel)))
+	}
+}
+
+/*
+Panics 409 if the parent directory does not exist.
+*/
+func (p *DavHandler) checkParent(t *davTarget) {
+	info, err := os.Stat(filepath.Dir(t.file))
+	if err != nil || !info.IsDir() {
+		panic(config.NewControllerError("Parent directory not found", http.StatusConflict, fmt.Sprintf("DAV: %s has no parent", p.configData.GetPathForDisplay(t.file))))
+	}
+}
+
+/*
+Panics 423 if 'file' is locked and the 'If' header does not have the token. If 'deep' locks below it apply.
+*/
+func (p *DavHandler) checkLocks(r *http.Request, file string, deep bool) {
+	err := davLocks.confirm(file, deep, davIfTokens(r.Header.Get("If")))
+	if err != nil {
+		panic(config.NewControllerError("Locked", http.StatusLocked, fmt.Sprintf("DAV: %s %s", p.configData.GetPathForDisplay(file), err.Error())))
+	}
+}
+
+func (p *DavHandler) urlParts(t *davTarget) *UrlRequestParts {
+	return NewUrlRequestParts(p.configData).WithParameters(map[string]string{UserParam: t.user, LocationParam: t.loc}).WithIdentity(p.identity)
+}
+
+func (p *DavHandler) propfind(w http.ResponseWriter, r *http.Request, t *davTarget) {
+	depth := r.Header.Get("Depth")
+	if depth != "0" && depth != "1" {
+		panic(config.NewControllerError("Depth must be 0 or 1", http.StatusForbidden, fmt.Sprintf("DAV: PROPFIND %s Depth:'%s'", t.href(true), depth)))
+	}
+	// All properties are returned so the request body is not used
+	io.Copy(io.Discard, io.LimitReader(r.Body, davMaxXmlBody))
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString("<D:multistatus xmlns:D=\"DAV:\">")
+	if t.loc == "" {
+		p.writeProps(&buf, t.href(true), t.user, nil, "")
+		if depth == "1" {
+			for _, loc := range p.readableLocations(t.user) {
+				lt := &davTarget{user: t.user, loc: loc, file: p.configData.GetUserLocPath(t.user, loc)}
+				info, err := os.Stat(lt.file)
+				if err == nil && info.IsDir() {
+					p.writeProps(&buf, lt.href(true), loc, info, lt.file)
+				}
+			}
+		}
+	} else {
+		info := p.stat(t)
+		name := path.Base(t.rel)
+		if t.rel == "" {
+			name = t.loc
+		}
+		p.writeProps(&buf, t.href(info.IsDir()), name, info, t.file)
+		if depth == "1" && info.IsDir() {
+			entries, err := os.ReadDir(t.file)
+			if err != nil {
+				panic(config.NewControllerError("Dir could not be read", http.StatusUnprocessableEntity, fmt.Sprintf("DAV: %s %s", p.configData.GetPathForDisplay(t.file), err.Error())))
+			}
+			for _, e := range entries {
+				ci, err := e.Info()
+				if err == nil && p.visible(ci, p.filter(t)) {
+					c := t.child(e.Name())
+					p.writeProps(&buf, c.href(ci.IsDir()), e.Name(), ci, c.file)
+				}
+			}
+		}
+	}
+	buf.WriteString("</D:multistatus>")
+	writeDavXml(w, http.StatusMultiStatus, buf.Bytes())
+}
+
+/*
+A <response> with all the properties. 'info' is nil for the users list of locations.
+*/
+func (p *DavHandler) writeProps(buf *bytes.Buffer, href string, name string, info os.FileInfo, file string) {
+	buf.WriteString("<D:response><D:href>")
+	xml.EscapeText(buf, []byte(href))
+	buf.WriteString("</D:href><D:propstat><D:prop><D:displayname>")
+	xml.EscapeText(buf, []byte(name))
+	buf.WriteString("</D:displayname>")
+	if info == nil || info.IsDir() {
+		buf.WriteString("<D:resourcetype><D:collection/></D:resourcetype>")
+	} else {
+		buf.WriteString(fmt.Sprintf("<D:resourcetype/><D:getcontentlength>%d</D:getcontentlength><D:getcontenttype>", info.Size()))
+		xml.EscapeText(buf, []byte(config.LookupContentType(info.Name())))
+		buf.WriteString("</D:getcontenttype>")
+	}
+	if info != nil {
+		buf.WriteString(fmt.Sprintf("<D:getlastmodified>%s</D:getlastmodified><D:getetag>%s</D:getetag>", info.ModTime().UTC().Format(http.TimeFormat), davEtag(info)))
+	}
+	if file != "" {
+		buf.WriteString("<D:supportedlock>" + davSupportedLock + "</D:supportedlock><D:lockdiscovery>")
+		for _, l := range davLocks.discover(file) {
+			buf.WriteString(davActiveLock(&l))
+		}
+		buf.WriteString("</D:lockdiscovery>")
+	}
+	buf.WriteString("</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>")
+}
+
+func davEtag(info os.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size())
+}
+
+func davActiveLock(l *davLock) string {
+	scope, depth := "exclusive", "infinity"
+	if l.Shared {
+		scope = "shared"
+	}
+	if !l.Deep {
+		depth = "0"
+	}
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("<D:activelock><D:locktype><D:write/></D:locktype><D:lockscope><D:%s/></D:lockscope><D:depth>%s</D:depth>", scope, depth))
+	if l.Owner != "" {
+		buf.WriteString("<D:owner>" + l.Owner + "</D:owner>")
+	}
+	buf.WriteString(fmt.Sprintf("<D:timeout>Second-%d</D:timeout><D:locktoken><D:href>%s</D:href></D:locktoken><D:lockroot><D:href>", int(l.Timeout.Seconds()), l.Token))
+	xml.EscapeText(&buf, []byte(l.Href))
+	buf.WriteString("</D:href></D:lockroot></D:activelock>")
+	return buf.String()
+}
+
+func writeDavXml(w http.ResponseWriter, status int, content []byte) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(content)
+}
+
+func (p *DavHandler) get(w http.ResponseWriter, r *http.Request, t *davTarget) {
+	info := p.stat(t)
+	if info.IsDir() {
+		w.Header().Set("Allow", "OPTIONS, PROPFIND, DELETE, MKCOL, COPY, MOVE, LOCK, UNLOCK")
+		panic(config.NewControllerError("Is a directory. Use PROPFIND", http.StatusMethodNotAllowed, fmt.Sprintf("DAV: GET %s is a Directory", t.href(true))))
+	}
+	f, err := os.Open(t.file)
+	if err != nil {
+		panic(config.NewControllerError("File could not be read", http.StatusUnprocessableEntity, fmt.Sprintf("DAV: %s %s", p.configData.GetPathForDisplay(t.file), err.Error())))
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", config.LookupContentType(info.Name()))
+	w.Header().Set("ETag", davEtag(info))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+func (p *DavHandler) put(w http.ResponseWriter, r *http.Request, t *davTarget) {
+	p.checkFileName(t)
+	p.checkParent(t)
+	info, err := os.Stat(t.file)
+	exists := err == nil
+	if exists && info.IsDir() {
+		panic(config.NewControllerError("Is a directory", http.StatusMethodNotAllowed, fmt.Sprintf("DAV: PUT %s is a Directory", t.href(true))))
+	}
+	p.checkLocks(r, t.file, false)
+	if exists {
+		p.urlParts(t).keepVersion(t.file)
+	}
+	_, err = config.WriteFileAtomicFrom(t.file, r.Body, 0644, true)
+	if err != nil {
+		panic(config.NewControllerError("File could not be written", http.StatusUnprocessableEntity, fmt.Sprintf("DAV: %s %s", p.configData.GetPathForDisplay(t.file), err.Error())))
+	}
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (p *DavHandler) delete(w http.ResponseWriter, r *http.Request, t *davTarget) {
+	if t.rel == "" {
+		panic(config.NewControllerError("Location cannot be deleted", http.StatusForbidden, fmt.Sprintf("DAV: %s is a location", t.href(true))))
+	}
+	info := p.stat(t)
+	p.checkLocks(r, t.file, true)
+	fd := p.configData.GetPathForDisplay(t.file)
+	if info.IsDir() {
+		// As for /paths. Directories do not go to the trash
+		p.configData.CheckTreeAccess(t.file, config.OpDelete)
+		err := os.RemoveAll(t.file)
+		if err != nil {
+			panic(config.NewControllerError("Directory could not be deleted", http.StatusUnprocessableEntity, fmt.Sprintf("DAV: %s %s", fd, err.Error())))
+		}
+	} else {
+		_, err := moveToTrash(p.urlParts(t), p.configData, t.file, info)
+		if err != nil {
+			panic(config.NewControllerError("File could not be deleted", http.StatusUnprocessableEntity, fmt.Sprintf("DAV: %s could not be moved to trash. %s", fd, err.Error())))
+		}
+	}
+	davLocks.removeAll(t.file)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *DavHandler) mkcol(w http.ResponseWriter, r *http.Request, t *davTarget) {
+	n, _ := io.Copy(io.Discard, io.LimitReader(r.Body, 1))
+	if n > 0 {
+		panic(config.NewControllerError("MKCOL with a body is not supported", http.StatusUnsupportedMediaType, fmt.Sprintf("DAV: MKCOL %s", t.href(true))))
+	}
+	if _, err := os.Stat(t.file); err == nil {
+		panic(config.NewControllerError("Already exists", http.StatusMethodNotAllowed, fmt.Sprintf("DAV: MKCOL %s", t.href(true))))
+	}
+	p.checkParent(t)
+	p.checkLocks(r, t.file, false)
+	err := os.Mkdir(t.file, 0755)
+	if err != nil {
+		panic(config.NewControllerError("Directory could not be created", http.StatusUnprocessableEntity, fmt.Sprintf("DAV: %s %s", p.configData.GetPathForDisplay(t.file), err.Error())))
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+/*
+The target of the 'Destination' header. A path or an absolute url.
+*/
+func (p *DavHandler) destination(r *http.Request) *davTarget {
+	dest := strings.TrimSpace(r.Header.Get("Destination"))
+	u, err := url.Parse(dest)
+	if dest == "" || err != nil {
+		panic(config.NewControllerError("Invalid Destination header", http.StatusBadRequest, fmt.Sprintf("DAV: %s Destination:'%s'", r.Method, dest)))
+	}
+	return p.resolve(u.Path, config.OpWrite)
+}
+
+func (p *DavHandler) copyMove(w http.ResponseWriter, r *http.Request, t *davTarget, move bool) {
+	dest := p.destination(r)
+	if t.rel == "" || dest.loc == "" || dest.rel == "" {
+		panic(config.NewControllerError("Location cannot be moved or replaced", http.StatusForbidden, fmt.Sprintf("DAV: %s %s to %s", r.Method, t.href(true), dest.href(true))))
+	}
+	info := p.stat(t)
+	if !info.IsDir() {
+		p.checkFileName(dest)
+	}
+	if davPathIn(dest.file, t.file) || davPathIn(t.file, dest.file) {
+		panic(config.NewControllerError("Destination is the source, inside it or contains it", http.StatusForbidden, fmt.Sprintf("DAV: %s %s to %s", r.Method, t.href(true), dest.href(true))))
+	}
+	p.checkParent(dest)
+	dInfo, err := os.Stat(dest.file)
+	exists := err == nil
+	if exists && strings.EqualFold(strings.TrimSpace(r.Header.Get("Overwrite")), "F") {
+		panic(config.NewControllerError("Destination exists", http.StatusPreconditionFailed, fmt.Sprintf("DAV: %s %s exists", r.Method, dest.href(true))))
+	}
+	if move {
+		if info.IsDir() {
+			p.configData.CheckTreeAccess(t.file, config.OpDelete)
+		}
+		p.checkLocks(r, t.file, true)
+	}
+	p.checkLocks(r, dest.file, true)
+
+	fd := p.configData.GetPathForDisplay(t.file)
+	if exists && (dInfo.IsDir() || info.IsDir()) {
+		// Nothing is deleted to make way for the source. os.Rename cannot replace a directory either.
+		panic(config.NewControllerError("Destination exists", http.StatusPreconditionFailed, fmt.Sprintf("DAV: %s %s to %s. A directory cannot be replaced", r.Method, fd, p.configData.GetPathForDisplay(dest.file))))
+	}
+	if exists {
+		p.urlParts(dest).keepVersion(dest.file)
+	}
+	switch {
+	case move && info.IsDir():
+		err = config.RenameDurable(t.file, dest.file, true)
+	case move:
+		err = moveFile(t.file, dest.file, info, true)
+	case info.IsDir():
+		err = p.copyDir(t.file, dest.file, p.filter(t), p.filter(dest))
+	default:
+		err = copyFile(t.file, dest.file, info, true)
+	}
+	if err != nil {
+		panic(config.NewControllerError(fmt.Sprintf("%s failed", r.Method), http.StatusUnprocessableEntity, fmt.Sprintf("DAV: %s %s to %s %s", r.Method, fd, p.configData.GetPathForDisplay(dest.file), err.Error())))
+	}
+	if move {
+		davLocks.removeAll(t.file)
+	}
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+/*
+Copy the files and directories that would be in a file list of both locations. Hidden ones (trash, versions etc) are not copied.
+*/
+func (p *DavHandler) copyDir(src string, dst string, srcFilter []string, dstFilter []string) error {
+	return filepath.WalkDir(src, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if file != src && !(p.visible(info, srcFilter) && p.visible(info, dstFilter)) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(src, file)
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.Mkdir(target, 0755)
+		}
+		return copyFile(file, target, info, false)
+	})
+}
+
+/*
+The body of a LOCK request. Namespaces are not checked.
+*/
+type davLockInfo struct {
+	Exclusive *struct{} `xml:"lockscope>exclusive"`
+	Shared    *struct{} `xml:"lockscope>shared"`
+	Write     *struct{} `xml:"locktype>write"`
+	Owner     struct {
+		Href string `xml:"href"`
+		Text string `xml:",chardata"`
+	} `xml:"owner"`
+}
+
+/*
+The owner as XML that is safe to return to other clients. Only an href or the text is kept.
+*/
+func (i *davLockInfo) ownerXml() string {
+	var buf bytes.Buffer
+	if href := strings.TrimSpace(i.Owner.Href); href != "" {
+		buf.WriteString("<D:href>")
+		xml.EscapeText(&buf, []byte(href))
+		buf.WriteString("</D:href>")
+	} else {
+		xml.EscapeText(&buf, []byte(strings.TrimSpace(i.Owner.Text)))
+	}
+	return buf.String()
+}
+
+func (p *DavHandler) lock(w http.ResponseWriter, r *http.Request, t *davTarget) {
+	timeout := davTimeout(r.Header.Get("Timeout"))
+	body, err := io.ReadAll(io.LimitReader(r.Body, davMaxXmlBody))
+	if err != nil {
+		panic(config.NewControllerError("Failed to read lock request", http.StatusBadRequest, err.Error()))
+	}
+	status := http.StatusOK
+	var l *davLock
+	if len(bytes.TrimSpace(body)) == 0 {
+		// Refresh. The token is in the 'If' header
+		l, err = davLocks.refresh(t.file, davIfTokens(r.Header.Get("If")), timeout)
+		if err != nil {
+			panic(config.NewControllerError("Lock not found", http.StatusPreconditionFailed, fmt.Sprintf("DAV: LOCK refresh %s %s", t.href(false), err.Error())))
+		}
+	} else {
+		info := davLockInfo{}
+		err = xml.Unmarshal(body, &info)
+		if err != nil || info.Write == nil || (info.Exclusive == nil && info.Shared == nil) {
+			panic(config.NewControllerError("Invalid lock request", http.StatusBadRequest, fmt.Sprintf("DAV: LOCK %s Write and exclusive or shared are required", t.href(false))))
+		}
+		depth := r.Header.Get("Depth")
+		if depth != "" && depth != "0" && depth != "infinity" {
+			panic(config.NewControllerError("Depth must be 0 or infinity", http.StatusBadRequest, fmt.Sprintf("DAV: LOCK %s Depth:'%s'", t.href(false), depth)))
+		}
+		fi, err := os.Stat(t.file)
+		if err != nil {
+			// Locking an unmapped url creates an empty file (RFC 4918 section 7.3)
+			p.checkFileName(t)
+			p.checkParent(t)
+			status = http.StatusCreated
+		} else if !p.visible(fi, p.filter(t)) {
+			p.stat(t)
+		}
+		l, err = davLocks.create(t.file, t.href(fi != nil && fi.IsDir()), depth != "0", info.Shared != nil, info.ownerXml(), timeout)
+		if err != nil {
+			panic(config.NewControllerError("Locked", http.StatusLocked, fmt.Sprintf("DAV: LOCK %s %s", t.href(false), err.Error())))
+		}
+		if status == http.StatusCreated {
+			f, err := os.OpenFile(t.file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+			if err == nil {
+				f.Close()
+			}
+		}
+		w.Header().Set("Lock-Token", "<"+l.Token+">")
+	}
+	writeDavXml(w, status, []byte(xml.Header+"<D:prop xmlns:D=\"DAV:\"><D:lockdiscovery>"+davActiveLock(l)+"</D:lockdiscovery></D:prop>"))
+}
+
+func (p *DavHandler) unlock(w http.ResponseWriter, r *http.Request, t *davTarget) {
+	token := strings.Trim(strings.TrimSpace(r.Header.Get("Lock-Token")), "<>")
+	err := davLocks.unlock(t.file, token)
+	if err != nil {
+		panic(config.NewControllerError("Lock token does not match", http.StatusConflict, fmt.Sprintf("DAV: UNLOCK %s %s", t.href(false), err.Error())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+Properties are not stored. Each one is accepted so clients (Windows) that set times when copying do not fail.
+*/
+func (p *DavHandler) proppatch(w http.ResponseWriter, r *http.Request, t *davTarget) {
+	info := p.stat(t)
+	p.checkLocks(r, t.file, false)
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString("<D:multistatus xmlns:D=\"DAV:\"><D:response><D:href>")
+	xml.EscapeText(&buf, []byte(t.href(info.IsDir())))
+	buf.WriteString("</D:href><D:propstat><D:prop>")
+	d := xml.NewDecoder(io.LimitReader(r.Body, davMaxXmlBody))
+	inProp := 0 // Depth inside a <prop> element. 1 is a property
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				panic(config.NewControllerError("Invalid proppatch request", http.StatusBadRequest, fmt.Sprintf("DAV: PROPPATCH %s %s", t.href(false), err.Error())))
+			}
+			break
+		}
+		switch e := tok.(type) {
+		case xml.StartElement:
+			if inProp > 0 {
+				if inProp == 1 {
+					buf.WriteString("<x:")
+					buf.WriteString(e.Name.Local)
+					buf.WriteString(" xmlns:x=\"")
+					xml.EscapeText(&buf, []byte(e.Name.Space))
+					buf.WriteString("\"/>")
+				}
+				inProp++
+			} else if e.Name.Local == "prop" {
+				inProp = 1
+			}
+		case xml.EndElement:
+			if inProp > 0 {
+				inProp--
+			}
+		}
+	}
+	buf.WriteString("</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response></D:multistatus>")
+	writeDavXml(w, http.StatusMultiStatus, buf.Bytes())
+}
@@ -0,0 +1,215 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	davDefaultLockTimeout = 10 * time.Minute
+	davMaxLockTimeout     = time.Hour
+)
+
+var errDavLocked = errors.New("resource is locked")
+var errDavNoLock = errors.New("lock token does not match a lock")
+
+/*
+A WebDAV write lock on a file or directory (Root is the file system path).
+A Deep lock also locks everything below a directory.
+*/
+type davLock struct {
+	Token   string
+	Root    string
+	Href    string // The url of Root. Returned as the lockroot
+	Deep    bool
+	Shared  bool
+	Owner   string // The <owner> from the LOCK request as escaped XML (see davLockInfo.ownerXml). Returned as is
+	Timeout time.Duration
+	expires time.Time
+}
+
+/*
+The WebDAV locks for all users. One instance is shared by all requests.
+Expired locks are removed when the locks are next used.
+*/
+type davLockSystem struct {
+	mu    sync.Mutex
+	locks map[string]*davLock
+}
+
+var davLocks = &davLockSystem{locks: map[string]*davLock{}}
+
+func newDavLockToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	h := hex.EncodeToString(b)
+	return "opaquelocktoken:" + h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+/*
+True if 'path' is 'root' or is below it.
+*/
+func davPathIn(path string, root string) bool {
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+/*
+True if the lock applies to 'path'. If 'deep' a lock on anything below 'path' also applies.
+*/
+func (l *davLock) covers(path string, deep bool) bool {
+	if l.Root == path || (l.Deep && davPathIn(path, l.Root)) {
+		return true
+	}
+	return deep && davPathIn(l.Root, path)
+}
+
+func (s *davLockSystem) expire(now time.Time) {
+	for t, l := range s.locks {
+		if now.After(l.expires) {
+			delete(s.locks, t)
+		}
+	}
+}
+
+/*
+Returns errDavLocked if a lock applies to 'path' (see covers) and its token is not in 'tokens'.
+*/
+func (s *davLockSystem) confirm(path string, deep bool, tokens []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(time.Now())
+	for _, l := range s.locks {
+		if l.covers(path, deep) && !slices.Contains(tokens, l.Token) {
+			return errDavLocked
+		}
+	}
+	return nil
+}
+
+/*
+Lock 'path'. An exclusive lock conflicts with any other lock. A shared lock only conflicts with exclusive locks.
+*/
+func (s *davLockSystem) create(path string, href string, deep bool, shared bool, owner string, timeout time.Duration) (*davLock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.expire(now)
+	for _, l := range s.locks {
+		if l.covers(path, deep) && (!shared || !l.Shared) {
+			return nil, errDavLocked
+		}
+	}
+	l := &davLock{Token: newDavLockToken(), Root: path, Href: href, Deep: deep, Shared: shared, Owner: owner, Timeout: timeout, expires: now.Add(timeout)}
+	s.locks[l.Token] = l
+	return l, nil
+}
+
+/*
+Extend the timeout of the first lock in 'tokens' that applies to 'path'.
+*/
+func (s *davLockSystem) refresh(path string, tokens []string, timeout time.Duration) (*davLock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.expire(now)
+	for _, t := range tokens {
+		l, ok := s.locks[t]
+		if ok && l.covers(path, false) {
+			l.Timeout = timeout
+			l.expires = now.Add(timeout)
+			return l, nil
+		}
+	}
+	return nil, errDavNoLock
+}
+
+/*
+Remove lock 'token'. It must apply to 'path'.
+*/
+func (s *davLockSystem) unlock(path string, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(time.Now())
+	l, ok := s.locks[token]
+	if !ok || !l.covers(path, false) {
+		return errDavNoLock
+	}
+	delete(s.locks, token)
+	return nil
+}
+
+/*
+Remove the locks on 'path' and below. Used when a resource is deleted or moved.
+*/
+func (s *davLockSystem) removeAll(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for t, l := range s.locks {
+		if davPathIn(l.Root, path) {
+			delete(s.locks, t)
+		}
+	}
+}
+
+/*
+The locks that apply to 'path'. Copies so they can be used without the mutex.
+*/
+func (s *davLockSystem) discover(path string) []davLock {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(time.Now())
+	list := []davLock{}
+	for _, l := range s.locks {
+		if l.covers(path, false) {
+			list = append(list, *l)
+		}
+	}
+	return list
+}
+
+/*
+The lock tokens in the 'If' header. Other conditions (etags, Not) are ignored.
+*/
+func davIfTokens(header string) []string {
+	tokens := []string{}
+	for {
+		start := strings.IndexRune(header, '<')
+		if start < 0 {
+			return tokens
+		}
+		end := strings.IndexRune(header[start:], '>')
+		if end < 0 {
+			return tokens
+		}
+		t := header[start+1 : start+end]
+		if strings.HasPrefix(t, "opaquelocktoken:") {
+			tokens = append(tokens, t)
+		}
+		header = header[start+end+1:]
+	}
+}
+
+/*
+The 'Timeout' header. 'Second-n' or 'Infinite'. Limited to davMaxLockTimeout.
+*/
+func davTimeout(header string) time.Duration {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if strings.EqualFold(v, "Infinite") {
+			return davMaxLockTimeout
+		}
+		if n, ok := strings.CutPrefix(v, "Second-"); ok {
+			d, err := time.ParseDuration(n + "s")
+			if err == nil && d > 0 {
+				return min(d, davMaxLockTimeout)
+			}
+		}
+	}
+	return davDefaultLockTimeout
+}
@@ -0,0 +1,357 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+const (
+	exifTimeFormat   = "2006:01:02 15:04:05"
+	exifTakenFormat  = "2006-01-02T15:04:05" // ExifData.DateTime
+	exifMaxIfdCount  = 1000                  // More entries than this and the data is not trusted
+	tagMake          = 0x010F
+	tagModel         = 0x0110
+	tagOrientation   = 0x0112
+	tagDateTime      = 0x0132
+	tagExifIfd       = 0x8769
+	tagGpsIfd        = 0x8825
+	tagDateTimeOrig  = 0x9003
+	tagPixelX        = 0xA002
+	tagPixelY        = 0xA003
+	tagGpsLatRef     = 0x0001
+	tagGpsLat        = 0x0002
+	tagGpsLonRef     = 0x0003
+	tagGpsLon        = 0x0004
+	jpegMarkerSOS    = 0xDA
+	jpegMarkerEOI    = 0xD9
+	jpegMarkerAPP1   = 0xE1
+	exifHeaderPrefix = "Exif\x00\x00"
+)
+
+var errNotJpeg = errors.New("not a JPEG file")
+var errBadExif = errors.New("invalid EXIF data")
+
+/*
+Picture metadata from the JPEG EXIF data. Values that are not in the file are left out.
+
+Orientation is the EXIF value 1..8. 1 is upright, 3 is upside down, 6 needs rotating 90
+clockwise and 8 needs rotating 90 anti-clockwise. Width and Height are as stored (before rotating).
+*/
+type ExifData struct {
+	DateTime    string   `json:"dateTime,omitempty"` // When taken. 2006-01-02T15:04:05 in the camera time zone
+	Make        string   `json:"make,omitempty"`
+	Model       string   `json:"model,omitempty"`
+	Orientation int      `json:"orientation,omitempty"`
+	Width       int      `json:"width,omitempty"`
+	Height      int      `json:"height,omitempty"`
+	Latitude    *float64 `json:"latitude,omitempty"`
+	Longitude   *float64 `json:"longitude,omitempty"`
+}
+
+/*
+Read the metadata from a JPEG file. Only the headers are read, not the picture.
+A JPEG without EXIF data returns the width and height only.
+*/
+func readExif(file string) (*ExifData, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseJpegMeta(bufio.NewReader(f))
+}
+
+func parseJpegMeta(r *bufio.Reader) (*ExifData, error) {
+	var soi [2]byte
+	_, err := io.ReadFull(r, soi[:])
+	if err != nil || soi[0] != 0xFF || soi[1] != 0xD8 {
+		return nil, errNotJpeg
+	}
+	data := &ExifData{}
+	width, height := 0, 0
+	hasExif := false
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			break
+		}
+		if b != 0xFF {
+			return nil, errNotJpeg
+		}
+		marker := byte(0xFF)
+		for marker == 0xFF { // Markers can be padded with 0xFF
+			marker, err = r.ReadByte()
+			if err != nil {
+				return nil, errNotJpeg
+			}
+		}
+		if marker == jpegMarkerSOS || marker == jpegMarkerEOI {
+			break
+		}
+		if (marker >= 0xD0 && marker <= 0xD7) || marker == 0x01 {
+			continue // No length
+		}
+		var l [2]byte
+		_, err = io.ReadFull(r, l[:])
+		n := int(binary.BigEndian.Uint16(l[:])) - 2
+		if err != nil || n < 0 {
+			return nil, errNotJpeg
+		}
+		switch {
+		case marker == jpegMarkerAPP1 && !hasExif, isJpegSOF(marker):
+			seg := make([]byte, n)
+			_, err = io.ReadFull(r, seg)
+			if err != nil {
+				return nil, errNotJpeg
+			}
+			if marker != jpegMarkerAPP1 {
+				if len(seg) >= 5 {
+					height = int(binary.BigEndian.Uint16(seg[1:]))
+					width = int(binary.BigEndian.Uint16(seg[3:]))
+				}
+			} else if bytes.HasPrefix(seg, []byte(exifHeaderPrefix)) {
+				// Bad EXIF data is ignored. The rest of the file is still a picture
+				hasExif = parseExif(seg[len(exifHeaderPrefix):], data) == nil
+			}
+		default:
+			_, err = r.Discard(n)
+			if err != nil {
+				return nil, errNotJpeg
+			}
+		}
+	}
+	// The frame size is the real size. EXIF values can be wrong after editing
+	if width > 0 && height > 0 {
+		data.Width, data.Height = width, height
+	}
+	return data, nil
+}
+
+// Start Of Frame markers. C4, C8 and CC are not frames
+func isJpegSOF(marker byte) bool {
+	return marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC
+}
+
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+type tiffReader struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+// Bytes per value for each TIFF type. 0 is unknown
+var tiffTypeSize = []uint32{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8}
+
+func parseExif(b []byte, data *ExifData) error {
+	if len(b) < 8 {
+		return errBadExif
+	}
+	t := &tiffReader{b: b}
+	switch string(b[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return errBadExif
+	}
+	if t.order.Uint16(b[2:]) != 42 {
+		return errBadExif
+	}
+	ifd0, err := t.ifd(t.order.Uint32(b[4:]))
+	if err != nil {
+		return err
+	}
+	data.Make = t.ascii(ifd0[tagMake])
+	data.Model = t.ascii(ifd0[tagModel])
+	data.Orientation = t.uint(ifd0[tagOrientation], 0)
+	taken := t.ascii(ifd0[tagDateTime])
+	if e, ok := ifd0[tagExifIfd]; ok {
+		exif, err := t.ifd(uint32(t.uint(e, 0)))
+		if err == nil {
+			if orig := t.ascii(exif[tagDateTimeOrig]); orig != "" {
+				taken = orig
+			}
+			data.Width = t.uint(exif[tagPixelX], 0)
+			data.Height = t.uint(exif[tagPixelY], 0)
+		}
+	}
+	if tm, err := time.Parse(exifTimeFormat, taken); err == nil {
+		data.DateTime = tm.Format(exifTakenFormat)
+	}
+	if e, ok := ifd0[tagGpsIfd]; ok {
+		gps, err := t.ifd(uint32(t.uint(e, 0)))
+		if err == nil {
+			data.Latitude = t.gpsCoord(gps[tagGpsLat], t.ascii(gps[tagGpsLatRef]), "S")
+			data.Longitude = t.gpsCoord(gps[tagGpsLon], t.ascii(gps[tagGpsLonRef]), "W")
+		}
+	}
+	return nil
+}
+
+/*
+The entries of the IFD (Image File Directory) at 'offset'. Entries outside the data are left out.
+*/
+func (t *tiffReader) ifd(offset uint32) (map[uint16]*tiffEntry, error) {
+	if uint64(offset)+2 > uint64(len(t.b)) {
+		return nil, errBadExif
+	}
+	count := int(t.order.Uint16(t.b[offset:]))
+	if count > exifMaxIfdCount {
+		return nil, errBadExif
+	}
+	entries := map[uint16]*tiffEntry{}
+	for i := 0; i < count; i++ {
+		pos := uint64(offset) + 2 + uint64(i)*12
+		if pos+12 > uint64(len(t.b)) {
+			break
+		}
+		e := t.b[pos : pos+12]
+		typ := t.order.Uint16(e[2:])
+		if int(typ) >= len(tiffTypeSize) || tiffTypeSize[typ] == 0 {
+			continue
+		}
+		count := t.order.Uint32(e[4:])
+		size := uint64(tiffTypeSize[typ]) * uint64(count)
+		var value []byte
+		if size <= 4 {
+			value = e[8 : 8+size] // Held in the entry
+		} else {
+			start := uint64(t.order.Uint32(e[8:]))
+			if start+size > uint64(len(t.b)) {
+				continue
+			}
+			value = t.b[start : start+size]
+		}
+		entries[t.order.Uint16(e)] = &tiffEntry{typ: typ, count: count, value: value}
+	}
+	return entries, nil
+}
+
+func (t *tiffReader) ascii(e *tiffEntry) string {
+	if e == nil || e.typ != 2 {
+		return ""
+	}
+	s, _, _ := strings.Cut(string(e.value), "\x00")
+	return strings.TrimSpace(s)
+}
+
+/*
+Value 'i' of a SHORT or LONG entry. 0 if it is not there.
+*/
+func (t *tiffReader) uint(e *tiffEntry, i uint32) int {
+	if e == nil || i >= e.count {
+		return 0
+	}
+	switch e.typ {
+	case 3:
+		return int(t.order.Uint16(e.value[i*2:]))
+	case 4:
+		return int(t.order.Uint32(e.value[i*4:]))
+	}
+	return 0
+}
+
+/*
+Value 'i' of a RATIONAL entry. NaN if it is not there or is divide by zero.
+*/
+func (t *tiffReader) rational(e *tiffEntry, i uint32) float64 {
+	if e == nil || e.typ != 5 || i >= e.count {
+		return math.NaN()
+	}
+	num := t.order.Uint32(e.value[i*8:])
+	den := t.order.Uint32(e.value[i*8+4:])
+	if den == 0 {
+		return math.NaN()
+	}
+	return float64(num) / float64(den)
+}
+
+/*
+Degrees from a GPS entry of 3 rationals (degrees, minutes, seconds). 'neg' is the ref (S or W) for a negative value.
+*/
+func (t *tiffReader) gpsCoord(e *tiffEntry, ref string, neg string) *float64 {
+	if e == nil || e.count < 3 {
+		return nil
+	}
+	v := t.rational(e, 0) + t.rational(e, 1)/60 + t.rational(e, 2)/3600
+	if math.IsNaN(v) {
+		return nil
+	}
+	if strings.EqualFold(ref, neg) {
+		v = -v
+	}
+	// 7 decimal places is about 1cm
+	v = math.Round(v*1e7) / 1e7
+	return &v
+}
+
+type ExifHandler struct {
+	parameters *UrlRequestParts
+	configData *config.ConfigData
+	verbose    func(string)
+}
+
+/*
+The metadata (see ExifData) of a JPEG picture. Not JPEG returns 415 Unsupported Media Type.
+*/
+func NewExifHandler(urlParts *UrlRequestParts, configData *config.ConfigData, verboseFunc func(string)) Handler {
+	return &ExifHandler{
+		parameters: urlParts,
+		configData: configData,
+		verbose:    verboseFunc,
+	}
+}
+
+func (p *ExifHandler) Submit() *ResponseData {
+	file := p.parameters.GetUserLocPath(true, p.parameters.GetQueryAsBool("thumbnail", false), p.parameters.GetQueryAsBool("base64", false))
+	fd := p.configData.GetPathForDisplay(file)
+	info, err := os.Stat(file)
+	if err != nil {
+		panic(config.NewControllerError("File not found", http.StatusNotFound, fd))
+	}
+	if info.IsDir() {
+		panic(config.NewControllerError("Is a directory", http.StatusForbidden, fmt.Sprintf("%s is a Directory", fd)))
+	}
+	data, err := readExif(file)
+	if err != nil {
+		panic(config.NewControllerError("Not a JPEG picture", http.StatusUnsupportedMediaType, fmt.Sprintf("Metadata: %s %s", fd, err.Error())))
+	}
+	var buffer bytes.Buffer
+	buffer.WriteRune('{')
+	writeJsonHeader(p.parameters, &buffer)
+	buffer.WriteRune(',')
+	writePathToJson(p.parameters.GetOptionalParam(PathParam, ""), PathParam, &buffer)
+	writePathToJson(info.Name(), NameParam, &buffer)
+	writeExifToJson(data, &buffer)
+	buffer.WriteRune('}')
+	if p.verbose != nil {
+		p.verbose(fmt.Sprintf("Metadata:%s", fd))
+	}
+	return NewResponseData(http.StatusOK).WithContentBytes(buffer.Bytes()).WithMimeType("json")
+}
+
+func writeExifToJson(data *ExifData, buffer *bytes.Buffer) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		panic(config.NewControllerError("controllers:writeExifToJson:Marshal", http.StatusInternalServerError, fmt.Sprintf("JSON Marshal:Error:%s", err.Error())))
+	}
+	buffer.WriteString("\"exif\":")
+	buffer.Write(b)
+}
@@ -0,0 +1,233 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+const listMaxLimit = 10000
+
+/*
+Sort and paging for a file list.
+
+	sort=name|mtime|size  order=asc|desc  offset=0  limit=n
+
+The default is name, descending (newest thumbnail first as they start with the date).
+*/
+type listOptions struct {
+	sort   string
+	desc   bool
+	offset int
+	limit  int
+}
+
+func newListOptions(params *UrlRequestParts) *listOptions {
+	opts := &listOptions{
+		sort:   params.GetOptionalQuery("sort", "name"),
+		offset: params.GetQueryAsInt("offset", 0),
+		limit:  params.GetQueryAsInt("limit", listMaxLimit),
+	}
+	if opts.sort != "name" && opts.sort != "mtime" && opts.sort != "size" {
+		panic(config.NewControllerError("Invalid sort", http.StatusBadRequest, fmt.Sprintf("List: sort=%s. Use name, mtime or size", opts.sort)))
+	}
+	order := params.GetOptionalQuery("order", "desc")
+	if order != "asc" && order != "desc" {
+		panic(config.NewControllerError("Invalid order", http.StatusBadRequest, fmt.Sprintf("List: order=%s. Use asc or desc", order)))
+	}
+	opts.desc = order == "desc"
+	if opts.offset < 0 || opts.limit < 1 || opts.limit > listMaxLimit {
+		panic(config.NewControllerError("Invalid offset or limit", http.StatusBadRequest, fmt.Sprintf("List: offset=%d limit=%d. Max limit is %d", opts.offset, opts.limit, listMaxLimit)))
+	}
+	return opts
+}
+
+/*
+Sort the files and return the requested page.
+*/
+func (o *listOptions) page(files []os.FileInfo) []os.FileInfo {
+	slices.SortStableFunc(files, func(a, b os.FileInfo) int {
+		c := 0
+		switch o.sort {
+		case "mtime":
+			c = a.ModTime().Compare(b.ModTime())
+		case "size":
+			c = int(min(max(a.Size()-b.Size(), -1), 1))
+		}
+		if c == 0 {
+			c = strings.Compare(a.Name(), b.Name())
+		}
+		if o.desc {
+			return -c
+		}
+		return c
+	})
+	if o.offset >= len(files) {
+		return []os.FileInfo{}
+	}
+	return files[o.offset:min(o.offset+o.limit, len(files))]
+}
+
+/*
+Optional extra data for each file in a list or stat response.
+
+	meta=true          Add modified time and MIME type
+	originals=<loc>    Add 'hasOriginal'. The file is a thumbnail. Is the picture (ThumbNailTrim applied) in the same path of <loc>
+	thumbnails=<loc>   Add 'hasThumbnail'. The file is a picture. Is there a thumbnail for it in the same path of <loc>
+	exif=true          Add 'exif' (see ExifData) for JPEG pictures
+*/
+type fileMeta struct {
+	meta         bool
+	originalsDir string
+	thumbnails   map[string]bool // Thumbnail names with ThumbNailTrim applied
+	exifDir      string
+	configData   *config.ConfigData
+}
+
+func newFileMeta(params *UrlRequestParts) *fileMeta {
+	m := &fileMeta{
+		meta:       params.GetQueryAsBool("meta", false),
+		configData: params.config,
+	}
+	isBase64 := params.GetQueryAsBool("base64", false)
+	if loc := params.GetOptionalQuery("originals", ""); loc != "" {
+		m.originalsDir = params.withLocation(loc).GetUserLocPath(false, false, isBase64)
+	}
+	if loc := params.GetOptionalQuery("thumbnails", ""); loc != "" {
+		m.thumbnails = map[string]bool{}
+		entries, _ := os.ReadDir(params.withLocation(loc).GetUserLocPath(false, false, isBase64))
+		for _, e := range entries {
+			if !e.IsDir() {
+				m.thumbnails[params.config.ConvertToThumbnail(e.Name(), true)] = true
+			}
+		}
+	}
+	if params.GetQueryAsBool("exif", false) {
+		m.exifDir = params.GetUserLocPath(false, false, isBase64)
+	}
+	return m
+}
+
+func (m *fileMeta) write(info os.FileInfo, buffer *bytes.Buffer) {
+	if m == nil {
+		return
+	}
+	if m.meta {
+		buffer.WriteString(",\"modified\":\"")
+		buffer.WriteString(info.ModTime().Format(time.RFC3339))
+		buffer.WriteString("\",\"mime\":\"")
+		buffer.WriteString(config.LookupContentType(info.Name()))
+		buffer.WriteString("\"")
+	}
+	if m.originalsDir != "" {
+		_, err := os.Stat(filepath.Join(m.originalsDir, m.configData.ConvertToThumbnail(info.Name(), true)))
+		buffer.WriteString(",\"hasOriginal\":")
+		buffer.WriteString(strconv.FormatBool(err == nil))
+	}
+	if m.thumbnails != nil {
+		buffer.WriteString(",\"hasThumbnail\":")
+		buffer.WriteString(strconv.FormatBool(m.thumbnails[info.Name()]))
+	}
+	if m.exifDir != "" && !info.IsDir() {
+		data, err := readExif(filepath.Join(m.exifDir, info.Name()))
+		if err == nil {
+			buffer.WriteRune(',')
+			writeExifToJson(data, buffer)
+		}
+	}
+}
+
+type StatHandler struct {
+	parameters *UrlRequestParts
+	configData *config.ConfigData
+	verbose    func(string)
+}
+
+/*
+Full metadata for one file or directory.
+
+Query 'sha256=true' adds the SHA-256 of a file. The meta, originals and thumbnails queries are the
+same as for a file list (including exif). Query 'thumbnail=true' applies ThumbNailTrim to the name.
+*/
+func NewStatHandler(urlParts *UrlRequestParts, configData *config.ConfigData, verboseFunc func(string)) Handler {
+	return &StatHandler{
+		parameters: urlParts,
+		configData: configData,
+		verbose:    verboseFunc,
+	}
+}
+
+func (p *StatHandler) Submit() *ResponseData {
+	file := p.parameters.GetUserLocPath(true, p.parameters.GetQueryAsBool("thumbnail", false), p.parameters.GetQueryAsBool("base64", false))
+	fd := p.configData.GetPathForDisplay(file)
+	info, err := os.Stat(file)
+	if err != nil {
+		panic(config.NewControllerError("File not found", http.StatusNotFound, fd))
+	}
+	var buffer bytes.Buffer
+	buffer.WriteRune('{')
+	writeJsonHeader(p.parameters, &buffer)
+	buffer.WriteRune(',')
+	writePathToJson(p.parameters.GetOptionalParam(PathParam, ""), PathParam, &buffer)
+	writePathToJson(info.Name(), NameParam, &buffer)
+	buffer.WriteString(fmt.Sprintf("\"size\":%d,\"isDir\":%t,\"mode\":\"%s\"", info.Size(), info.IsDir(), info.Mode().String()))
+	meta := newFileMeta(p.parameters)
+	meta.meta = true
+	meta.write(info, &buffer)
+	if !info.IsDir() && p.parameters.GetQueryAsBool("sha256", false) {
+		sum, err := fileSha256(file)
+		if err != nil {
+			panic(config.NewControllerError("File could not be read", http.StatusUnprocessableEntity, fmt.Sprintf("Stat: %s %s", fd, err.Error())))
+		}
+		buffer.WriteString(",\"sha256\":\"")
+		buffer.WriteString(sum)
+		buffer.WriteString("\"")
+	}
+	buffer.WriteRune('}')
+	if p.verbose != nil {
+		p.verbose(fmt.Sprintf("Stat:%s", fd))
+	}
+	return NewResponseData(http.StatusOK).WithContentBytes(buffer.Bytes()).WithMimeType("json")
+}
+
+func fileSha256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+/*
+Filter the directory entries as for a file list and return their info.
+*/
+func filteredFileInfo(ents []fs.DirEntry, filter []string) []os.FileInfo {
+	files := make([]os.FileInfo, 0, len(ents))
+	for _, e := range ents {
+		if filterDirNames(e, filter) {
+			info, err := e.Info()
+			if err == nil {
+				files = append(files, info)
+			}
+		}
+	}
+	return files
+}
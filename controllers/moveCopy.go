@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+type MoveCopyHandler struct {
+	from       *UrlRequestParts
+	to         *UrlRequestParts
+	move       bool
+	overwrite  bool
+	configData *config.ConfigData
+	verbose    func(string)
+}
+
+/*
+Move or copy the file in 'from' to the file in 'to'. Both are user/loc/path/name parameters.
+
+Access to both users and locations is checked. A move deletes from 'from'. If 'overwrite' is false an existing destination file is not replaced.
+*/
+func NewMoveCopyHandler(from *UrlRequestParts, to *UrlRequestParts, configData *config.ConfigData, move bool, overwrite bool, verboseFunc func(string)) Handler {
+	if move {
+		from.WithOperation(config.OpDelete)
+	} else {
+		from.WithOperation(config.OpRead)
+	}
+	to.WithOperation(config.OpWrite)
+	return &MoveCopyHandler{
+		from:       from,
+		to:         to,
+		move:       move,
+		overwrite:  overwrite,
+		configData: configData,
+		verbose:    verboseFunc,
+	}
+}
+
+func (p *MoveCopyHandler) Submit() *ResponseData {
+	action := "copy"
+	if p.move {
+		action = "move"
+	}
+	src := p.from.GetUserLocPath(true, false, p.from.GetQueryAsBool("base64", false))
+	dst := p.to.GetUserLocPath(true, false, p.from.GetQueryAsBool("base64", false))
+	srcFd := p.configData.GetPathForDisplay(src)
+	dstFd := p.configData.GetPathForDisplay(dst)
+
+	srcStat, err := os.Stat(src)
+	if err != nil {
+		panic(config.NewControllerError("File not found", http.StatusNotFound, srcFd))
+	}
+	if srcStat.IsDir() {
+		panic(config.NewControllerError("Is a directory", http.StatusForbidden, fmt.Sprintf("%s is a Directory", srcFd)))
+	}
+	dstStat, err := os.Stat(dst)
+	exists := err == nil
+	if exists {
+		if os.SameFile(srcStat, dstStat) {
+			panic(config.NewControllerError("Source and destination are the same file", http.StatusForbidden, fmt.Sprintf("File:%s", srcFd)))
+		}
+		if dstStat.IsDir() {
+			panic(config.NewControllerError("Is a directory", http.StatusForbidden, fmt.Sprintf("%s is a Directory", dstFd)))
+		}
+		if !p.overwrite {
+			panic(config.NewControllerError("File exists", http.StatusPreconditionFailed, fmt.Sprintf("File:%s already exists", dstFd)))
+		}
+	}
+	dirStat, err := os.Stat(filepath.Dir(dst))
+	if err != nil || !dirStat.IsDir() {
+		panic(config.NewControllerError("Dir not found", http.StatusNotFound, fmt.Sprintf("Destination dir for %s not found", dstFd)))
+	}
+
+	if exists {
+		// As for replace. The file being overwritten is kept as a version of the destination
+		p.to.keepVersion(dst)
+	}
+	if p.move {
+		err = moveFile(src, dst, srcStat, p.overwrite)
+	} else {
+		err = copyFile(src, dst, srcStat, p.overwrite)
+	}
+	if errors.Is(err, os.ErrExist) {
+		panic(config.NewControllerError("File exists", http.StatusPreconditionFailed, fmt.Sprintf("File:%s already exists", dstFd)))
+	}
+	if err != nil {
+		panic(config.NewControllerError(fmt.Sprintf("File %s failed", action), http.StatusInternalServerError, fmt.Sprintf("File:%s --> %s Error:%s", srcFd, dstFd, err.Error())))
+	}
+	if p.verbose != nil {
+		p.verbose(fmt.Sprintf("File action[%s]:%s --> %s", action, srcFd, dstFd))
+	}
+	return NewResponseData(http.StatusAccepted).WithContentWithCauseAsJson(fmt.Sprintf("File:Action:%s %s --> %s", action, srcFd, dstFd), p.from.Query)
+}
+
+/*
+Rename the file. If it is on a different file system copy it then remove the original.
+*/
+func moveFile(src string, dst string, srcStat os.FileInfo, overwrite bool) error {
+	err := config.RenameDurable(src, dst, overwrite)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	err = copyFile(src, dst, srcStat, overwrite)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(src)
+	if err != nil {
+		return err
+	}
+	return config.SyncDir(filepath.Dir(src))
+}
+
+/*
+Copy the file contents and modified time. The destination is written atomically.
+*/
+func copyFile(src string, dst string, srcStat os.FileInfo, overwrite bool) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = config.WriteFileAtomicFrom(dst, f, srcStat.Mode().Perm(), overwrite)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(dst, srcStat.ModTime(), srcStat.ModTime())
+}
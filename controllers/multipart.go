@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+/*
+The outcome for one file in a multipart upload.
+*/
+type UploadResult struct {
+	Name   string `json:"name"`
+	Status int    `json:"status"`
+	Error  bool   `json:"error"`
+	Cause  string `json:"cause"`
+	Size   int64  `json:"size"`
+}
+
+type MultipartUploadHandler struct {
+	parameters *UrlRequestParts
+	request    *http.Request
+	verbose    func(string)
+	configData *config.ConfigData
+}
+
+func NewMultipartUploadHandler(urlParts *UrlRequestParts, configData *config.ConfigData, r *http.Request, verboseFunc func(string)) Handler {
+	return &MultipartUploadHandler{
+		parameters: urlParts,
+		request:    r,
+		verbose:    verboseFunc,
+		configData: configData,
+	}
+}
+
+/*
+Save each file in a multipart/form-data body to the directory in the url.
+
+Parts are streamed to disk one at a time. Form fields that are not files are ignored.
+Query 'action' (save, replace or append) applies to every file, as for PostFileHandler.
+
+The response is a JSON array with a result for each file. The status is 202 if every file
+was saved, otherwise 207.
+*/
+func (p *MultipartUploadHandler) Submit() *ResponseData {
+	dir := p.parameters.GetUserLocPath(false, false, p.parameters.GetQueryAsBool("base64", false))
+	stats, err := os.Stat(dir)
+	if err != nil {
+		panic(config.NewControllerError("Dir not found", http.StatusNotFound, err.Error()))
+	}
+	if !stats.IsDir() {
+		panic(config.NewControllerError("Is NOT a directory", http.StatusForbidden, fmt.Sprintf("MultipartUploadHandler: %s is NOT a Directory", dir)))
+	}
+	action := p.parameters.GetOptionalQuery("action", "save")
+	if action != "save" && action != "replace" && action != "append" {
+		panic(config.NewControllerError("Invalid action", http.StatusBadRequest, fmt.Sprintf("MultipartUploadHandler: action=%s", action)))
+	}
+	reader, err := p.request.MultipartReader()
+	if err != nil {
+		panic(config.NewControllerError("Expected multipart/form-data", http.StatusBadRequest, fmt.Sprintf("MultipartUploadHandler: %s", err.Error())))
+	}
+
+	results := []*UploadResult{}
+	failed := 0
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			results = append(results, &UploadResult{Status: http.StatusBadRequest, Error: true, Cause: "Failed to read posted data"})
+			failed++
+			break
+		}
+		if part.FormName() == "" || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+		res := p.savePart(dir, action, part.FileName(), part)
+		part.Close()
+		if res.Error {
+			failed++
+		}
+		if p.verbose != nil {
+			p.verbose(fmt.Sprintf("File action[%s]:%s [%d] bytes Status:%d", action, res.Name, res.Size, res.Status))
+		}
+		results = append(results, res)
+	}
+	if len(results) == 0 {
+		panic(config.NewControllerError("No files were posted", http.StatusBadRequest, fmt.Sprintf("MultipartUploadHandler: No file parts. Dir:%s", p.configData.GetPathForDisplay(dir))))
+	}
+	content, err := json.Marshal(results)
+	if err != nil {
+		panic(config.NewControllerError("Data Map to Json failed", http.StatusInternalServerError, err.Error()))
+	}
+	status := http.StatusAccepted
+	if failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	return NewResponseData(status).WithContentBytes(content).WithMimeType("json")
+}
+
+func (p *MultipartUploadHandler) savePart(dir string, action string, name string, src io.Reader) *UploadResult {
+	name = filepath.Base(name)
+	res := &UploadResult{Name: name, Status: http.StatusAccepted}
+	fail := func(status int, cause string) *UploadResult {
+		res.Status = status
+		res.Error = true
+		res.Cause = cause
+		return res
+	}
+	if name == "." || name == ".." || name == string(os.PathSeparator) {
+		return fail(http.StatusBadRequest, "Invalid file name")
+	}
+	file := filepath.Join(dir, name)
+	var err error
+	switch action {
+	case "append":
+		res.Size, err = config.AppendFileDurableFrom(file, src, 0644)
+	case "replace":
+		err = keepVersion(file, p.configData.GetKeepVersions(p.parameters.GetUser(), p.parameters.GetLocation()))
+		if err != nil {
+			return fail(http.StatusInternalServerError, "Failed to keep previous version")
+		}
+		res.Size, err = config.WriteFileAtomicFrom(file, src, 0644, true)
+	default:
+		_, err = os.Stat(file)
+		if err == nil {
+			return fail(http.StatusPreconditionFailed, "File exists")
+		}
+		res.Size, err = config.WriteFileAtomicFrom(file, src, 0644, false)
+	}
+	if errors.Is(err, os.ErrExist) {
+		return fail(http.StatusPreconditionFailed, "File exists")
+	}
+	if err != nil {
+		return fail(http.StatusInternalServerError, "Failed to save data")
+	}
+	res.Cause = fmt.Sprintf("File:Action:%s %s", action, p.configData.GetPathForDisplay(file))
+	return res
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+type PathHandler struct {
+	parameters *UrlRequestParts
+	configData *config.ConfigData
+	verbose    func(string)
+	delete     bool
+}
+
+/*
+Create the directory in the path parameter, including any parent directories, under the location.
+*/
+func NewCreatePathHandler(urlParts *UrlRequestParts, configData *config.ConfigData, verboseFunc func(string)) Handler {
+	return &PathHandler{
+		parameters: urlParts,
+		configData: configData,
+		verbose:    verboseFunc,
+		delete:     false,
+	}
+}
+
+/*
+Delete the directory in the path parameter. A directory that is not empty is only
+deleted if query 'recursive=true'.
+*/
+func NewDeletePathHandler(urlParts *UrlRequestParts, configData *config.ConfigData, verboseFunc func(string)) Handler {
+	return &PathHandler{
+		parameters: urlParts,
+		configData: configData,
+		verbose:    verboseFunc,
+		delete:     true,
+	}
+}
+
+func (p *PathHandler) Submit() *ResponseData {
+	root := p.configData.GetUserLocPath(p.parameters.GetUser(), p.parameters.GetLocation())
+	dir := p.parameters.GetUserLocPath(false, false, p.parameters.GetQueryAsBool("base64", false))
+	fd := p.configData.GetPathForDisplay(dir)
+	// The location itself cannot be created or deleted and the path cannot leave it.
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		panic(config.NewControllerError("Invalid path", http.StatusForbidden, fmt.Sprintf("Path:%s is not within the location", fd)))
+	}
+	stats, err := os.Stat(dir)
+	if p.delete {
+		if err != nil {
+			panic(config.NewControllerError("Dir not found", http.StatusNotFound, fd))
+		}
+		if !stats.IsDir() {
+			panic(config.NewControllerError("Is NOT a directory", http.StatusForbidden, fmt.Sprintf("%s is NOT a Directory", fd)))
+		}
+		if p.parameters.GetQueryAsBool("recursive", false) {
+			p.configData.CheckTreeAccess(dir, config.OpDelete)
+			err = os.RemoveAll(dir)
+		} else {
+			entries, _ := os.ReadDir(dir)
+			if len(entries) > 0 {
+				panic(config.NewControllerError("Directory is not empty", http.StatusConflict, fmt.Sprintf("Path:%s has %d entries. Use recursive=true", fd, len(entries))))
+			}
+			err = os.Remove(dir)
+		}
+		if err != nil {
+			panic(config.NewControllerError("Directory could not be deleted", http.StatusUnprocessableEntity, fmt.Sprintf("Path:%s %s", fd, err.Error())))
+		}
+		config.SyncDir(filepath.Dir(dir))
+		if p.verbose != nil {
+			p.verbose(fmt.Sprintf("Delete Path:%s", fd))
+		}
+		return NewResponseData(http.StatusAccepted).WithContentWithCauseAsJson("Path deleted OK", p.parameters.Query)
+	}
+
+	if err == nil {
+		if stats.IsDir() {
+			panic(config.NewControllerError("Path exists", http.StatusPreconditionFailed, fmt.Sprintf("Path:%s already exists", fd)))
+		}
+		panic(config.NewControllerError("Is NOT a directory", http.StatusForbidden, fmt.Sprintf("%s is NOT a Directory", fd)))
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		panic(config.NewControllerError("Directory could not be created", http.StatusUnprocessableEntity, fmt.Sprintf("Path:%s %s", fd, err.Error())))
+	}
+	config.SyncDir(filepath.Dir(dir))
+	if p.verbose != nil {
+		p.verbose(fmt.Sprintf("Create Path:%s", fd))
+	}
+	return NewResponseData(http.StatusCreated).WithContentWithCauseAsJson("Path created OK", p.parameters.Query)
+}
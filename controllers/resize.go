@@ -0,0 +1,372 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+const resizeMaxSize = 10000
+
+// The largest picture (width x height) that will be decoded. Each pixel needs 4 bytes.
+const decodeMaxPixels = 50_000_000
+
+// The cache is checked and pruned as one operation
+var imageCacheLock sync.Mutex
+
+/*
+A resize and/or format conversion of a picture from the file url query.
+
+	w=800 h=600      Max width and height. Either can be left out
+	fit=contain      Fit inside w x h keeping the shape (the default)
+	fit=cover        Fill w x h keeping the shape. The picture is cropped (centre)
+	fit=fill         Stretch to exactly w x h
+	format=jpeg|png  The default is png for a png, otherwise jpeg
+
+Pictures are not enlarged except with fit=fill. EXIF orientation is applied first so w and h are as displayed.
+*/
+type resizeOptions struct {
+	w      int
+	h      int
+	fit    string
+	format string
+}
+
+/*
+True if the query asks for a resized or converted picture. Otherwise the file is sent as is.
+*/
+func IsResizeRequest(query url.Values) bool {
+	return query.Has("w") || query.Has("h") || query.Has("fit") || query.Has("format")
+}
+
+func newResizeOptions(query url.Values, src string) *resizeOptions {
+	o := &resizeOptions{
+		w:      resizeSize(query, "w"),
+		h:      resizeSize(query, "h"),
+		fit:    query.Get("fit"),
+		format: strings.ToLower(query.Get("format")),
+	}
+	switch o.fit {
+	case "":
+		o.fit = "contain"
+	case "contain", "cover", "fill":
+	default:
+		panic(config.NewControllerError("Invalid fit", http.StatusBadRequest, fmt.Sprintf("Resize: fit=%s. Use contain, cover or fill", o.fit)))
+	}
+	switch o.format {
+	case "":
+		o.format = "jpeg"
+		if strings.HasSuffix(strings.ToLower(src), ".png") {
+			o.format = "png"
+		}
+	case "jpg", "jpeg":
+		o.format = "jpeg"
+	case "png":
+	default:
+		panic(config.NewControllerError("Invalid format", http.StatusBadRequest, fmt.Sprintf("Resize: format=%s. Use jpeg or png", o.format)))
+	}
+	return o
+}
+
+func resizeSize(query url.Values, key string) int {
+	v := query.Get(key)
+	if v == "" {
+		return 0
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 1 || i > resizeMaxSize {
+		panic(config.NewControllerError(fmt.Sprintf("Query '%s' must be 1..%d pixels", key, resizeMaxSize), http.StatusBadRequest, fmt.Sprintf("Resize: %s=%s", key, v)))
+	}
+	return i
+}
+
+/*
+Resize the picture as requested.
+*/
+func (o *resizeOptions) apply(img *image.RGBA) *image.RGBA {
+	sw, sh := img.Bounds().Dx(), img.Bounds().Dy()
+	w, h := o.w, o.h
+	if w == 0 && h == 0 {
+		return img
+	}
+	if o.fit == "fill" {
+		if w == 0 {
+			w = sw * h / sh
+		}
+		if h == 0 {
+			h = sh * w / sw
+		}
+		return resizeRGBA(img, max(1, w), max(1, h))
+	}
+	sx, sy := float64(w)/float64(sw), float64(h)/float64(sh)
+	var s float64
+	switch {
+	case w == 0:
+		s = sy
+	case h == 0:
+		s = sx
+	case o.fit == "cover":
+		s = max(sx, sy)
+	default:
+		s = min(sx, sy)
+	}
+	s = min(s, 1)
+	if o.fit == "cover" && w > 0 && h > 0 {
+		cw := min(sw, int(math.Round(float64(w)/s)))
+		ch := min(sh, int(math.Round(float64(h)/s)))
+		x0, y0 := (sw-cw)/2, (sh-ch)/2
+		img = img.SubImage(image.Rect(x0, y0, x0+cw, y0+ch)).(*image.RGBA)
+		sw, sh = cw, ch
+	}
+	return resizeRGBA(img, max(1, int(math.Round(float64(sw)*s))), max(1, int(math.Round(float64(sh)*s))))
+}
+
+/*
+Returns the resized picture for file 'src'. It is made and added to the cache (see ImageCacheData)
+if it is not there. The cache key includes the modified time and size of 'src' so a changed
+picture is resized again.
+*/
+func GetResizedFileName(configData *config.ConfigData, src string, query url.Values, verbose func(string)) string {
+	opts := newResizeOptions(query, src)
+	fd := configData.GetPathForDisplay(src)
+	info, err := os.Stat(src)
+	if err != nil {
+		panic(config.NewControllerError("File not found", http.StatusNotFound, fd))
+	}
+	if info.IsDir() {
+		panic(config.NewControllerError("Is a directory", http.StatusForbidden, fmt.Sprintf("%s is a Directory", fd)))
+	}
+	ic := configData.GetImageCacheData()
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d|%d|%d|%s|%s", src, info.ModTime().UnixNano(), info.Size(), opts.w, opts.h, opts.fit, opts.format)))
+	target := filepath.Join(ic.Path, hex.EncodeToString(key[:16])+"."+opts.format)
+	now := time.Now()
+	imageCacheLock.Lock()
+	err = os.Chtimes(target, now, now) // Mark as used so it is not pruned
+	imageCacheLock.Unlock()
+	if err == nil {
+		return target
+	}
+
+	err = imageLimiter.run(target, configData.GetThumbnailData().MaxConcurrent, func() error {
+		if _, err := os.Stat(target); err == nil {
+			return nil // Made while waiting
+		}
+		start := time.Now()
+		err := resizeImage(src, target, opts, ic.Quality)
+		if err == nil && verbose != nil {
+			verbose(fmt.Sprintf("Resize:%s w[%d] h[%d] fit[%s] format[%s] in %s", fd, opts.w, opts.h, opts.fit, opts.format, time.Since(start)))
+		}
+		return err
+	})
+	if errors.Is(err, image.ErrFormat) {
+		panic(config.NewControllerError("Not a supported image", http.StatusUnsupportedMediaType, fmt.Sprintf("Resize: %s %s", fd, err.Error())))
+	}
+	if err != nil {
+		panic(config.NewControllerError("Picture could not be resized", http.StatusUnprocessableEntity, fmt.Sprintf("Resize: %s %s", fd, err.Error())))
+	}
+	pruneImageCache(ic.Path, int64(ic.MaxMB)<<20)
+	return target
+}
+
+func resizeImage(src string, target string, opts *resizeOptions, quality int) error {
+	img, err := decodeImage(src, opts.format == "jpeg")
+	if err != nil {
+		return err
+	}
+	img = opts.apply(img)
+	var buf bytes.Buffer
+	if opts.format == "png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+	_, err = config.WriteFileAtomicFrom(target, &buf, 0644, true)
+	return err
+}
+
+/*
+Remove the least recently used pictures until the cache is no larger than 'maxBytes'.
+Returns the names removed.
+*/
+func pruneImageCache(dir string, maxBytes int64) []string {
+	imageCacheLock.Lock()
+	defer imageCacheLock.Unlock()
+	removed := []string{}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return removed
+	}
+	files := []os.FileInfo{}
+	total := int64(0)
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue // Includes partly written files
+		}
+		info, err := e.Info()
+		if err == nil {
+			files = append(files, info)
+			total += info.Size()
+		}
+	}
+	slices.SortFunc(files, func(a, b os.FileInfo) int {
+		return a.ModTime().Compare(b.ModTime())
+	})
+	for _, f := range files {
+		if total <= maxBytes {
+			break
+		}
+		if os.Remove(filepath.Join(dir, f.Name())) == nil {
+			total -= f.Size()
+			removed = append(removed, f.Name())
+		}
+	}
+	return removed
+}
+
+/*
+Decode the picture in file 'src' with the EXIF orientation (JPEG only) applied.
+If 'white' transparent areas are white (for JPEG output).
+
+Pictures larger than decodeMaxPixels are rejected before they are decoded.
+*/
+func decodeImage(src string, white bool) (*image.RGBA, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// The size is in the header. Check it before the whole picture is in memory.
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > decodeMaxPixels {
+		return nil, fmt.Errorf("picture is %d x %d pixels. The limit is %d pixels", cfg.Width, cfg.Height, decodeMaxPixels)
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	img, format, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	if white {
+		draw.Draw(rgba, rgba.Bounds(), image.White, image.Point{}, draw.Src)
+		draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Over)
+	} else {
+		draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	}
+	if format == "jpeg" {
+		data, err := readExif(src)
+		if err == nil {
+			rgba = orient(rgba, data.Orientation)
+		}
+	}
+	return rgba, nil
+}
+
+/*
+Rotate and/or flip the picture so it is upright. 'o' is the EXIF orientation 1..8.
+*/
+func orient(img *image.RGBA, o int) *image.RGBA {
+	if o < 2 || o > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2: // Flip left to right
+				dx, dy = w-1-x, y
+			case 3: // Rotate 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // Flip top to bottom
+				dx, dy = x, h-1-y
+			case 5: // Transpose
+				dx, dy = y, x
+			case 6: // Rotate 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // Transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // Rotate 90 anti-clockwise
+				dx, dy = y, w-1-x
+			}
+			s := y*img.Stride + x*4
+			d := dy*dst.Stride + dx*4
+			copy(dst.Pix[d:d+4], img.Pix[s:s+4])
+		}
+	}
+	return dst
+}
+
+/*
+Resize the picture to dw x dh. Each pixel is the average of the pixels it covers (a box filter).
+*/
+func resizeRGBA(src *image.RGBA, dw int, dh int) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	if dw == w && dh == h {
+		return src
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0 := y * h / dh
+		y1 := max((y+1)*h/dh, y0+1)
+		for x := 0; x < dw; x++ {
+			x0 := x * w / dw
+			x1 := max((x+1)*w/dw, x0+1)
+			var r, g, bl, a, n uint64 // A large reduction can overflow uint32
+			for sy := y0; sy < y1; sy++ {
+				off := sy*src.Stride + x0*4
+				for sx := x0; sx < x1; sx++ {
+					r += uint64(src.Pix[off])
+					g += uint64(src.Pix[off+1])
+					bl += uint64(src.Pix[off+2])
+					a += uint64(src.Pix[off+3])
+					off += 4
+					n++
+				}
+			}
+			d := y*dst.Stride + x*4
+			dst.Pix[d] = uint8(r / n)
+			dst.Pix[d+1] = uint8(g / n)
+			dst.Pix[d+2] = uint8(bl / n)
+			dst.Pix[d+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
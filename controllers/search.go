@@ -0,0 +1,273 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+const (
+	searchDefaultLimit = 100
+	searchMaxLimit     = 1000
+)
+
+// The longest a search will walk the directory tree. What has been found by then is returned.
+var searchTimeLimit = 10 * time.Second
+
+var errSearchDone = errors.New("search done")
+
+type searchResult struct {
+	Path     string `json:"path"` // Relative to the location. Empty for the root
+	EncPath  string `json:"encPath"`
+	Name     string `json:"name"`
+	EncName  string `json:"encName"`
+	Size     int64  `json:"size"`
+	Modified string `json:"modified"`
+}
+
+/*
+The criteria from the query. Empty values match everything.
+*/
+type searchCriteria struct {
+	glob    string
+	regex   *regexp.Regexp
+	ext     []string
+	minSize int64
+	maxSize int64 // -1 is no limit
+	after   time.Time
+	before  time.Time
+}
+
+type SearchHandler struct {
+	parameters *UrlRequestParts
+	configData *config.ConfigData
+	request    *http.Request
+	verbose    func(string)
+}
+
+/*
+Search a location (or a path in it) and all sub directories for files.
+
+Files are selected with the same rules as a file list (FilterFiles and no '.' or '_' names).
+Query values narrow the search:
+
+	name=IMG_20*.jpg        Glob. Case is ignored
+	regex=^IMG_\d+\.jpg$    Go regular expression. Name only
+	ext=jpg,png             Extensions
+	minSize=1000 maxSize=.. Bytes
+	after=2024-01-31        Modified on or after. Date or RFC3339
+	before=2024-03-01       Modified before. Date or RFC3339
+	offset=0 limit=100      Paging. Max limit is 1000
+*/
+func NewSearchHandler(urlParts *UrlRequestParts, configData *config.ConfigData, r *http.Request, verboseFunc func(string)) Handler {
+	return &SearchHandler{
+		parameters: urlParts,
+		configData: configData,
+		request:    r,
+		verbose:    verboseFunc,
+	}
+}
+
+func (p *SearchHandler) Submit() *ResponseData {
+	dir := p.parameters.GetUserLocPath(false, false, p.parameters.GetQueryAsBool("base64", false))
+	stats, err := os.Stat(dir)
+	if err != nil {
+		panic(config.NewControllerError("Dir not found", http.StatusNotFound, p.configData.GetPathForDisplay(dir)))
+	}
+	if !stats.IsDir() {
+		panic(config.NewControllerError("Is NOT a directory", http.StatusForbidden, fmt.Sprintf("Search: %s is NOT a Directory", p.configData.GetPathForDisplay(dir))))
+	}
+	criteria := p.criteria()
+	offset := p.parameters.GetQueryAsInt("offset", 0)
+	limit := p.parameters.GetQueryAsInt("limit", searchDefaultLimit)
+	if offset < 0 || limit < 1 || limit > searchMaxLimit {
+		panic(config.NewControllerError("Invalid offset or limit", http.StatusBadRequest, fmt.Sprintf("Search: offset=%d limit=%d. Max limit is %d", offset, limit, searchMaxLimit)))
+	}
+
+	ctx, cancel := context.WithTimeout(p.request.Context(), searchTimeLimit)
+	defer cancel()
+	filter := p.parameters.GetConfigFileFilter()
+	locRoot := p.configData.GetUserLocPath(p.parameters.GetUser(), p.parameters.GetLocation())
+	results := []*searchResult{}
+	skipped := 0
+	more := false
+	err = walkFiles(ctx, dir, filter, func(path string, d fs.DirEntry) error {
+		info, err := d.Info()
+		if err != nil || !criteria.matches(d.Name(), info) {
+			return nil
+		}
+		if skipped < offset {
+			skipped++
+			return nil
+		}
+		if len(results) == limit {
+			more = true
+			return errSearchDone
+		}
+		rel, err := filepath.Rel(locRoot, filepath.Dir(path))
+		if err != nil || rel == "." {
+			rel = ""
+		}
+		rel = filepath.ToSlash(rel)
+		results = append(results, &searchResult{Path: rel, EncPath: encodeValue(rel), Name: d.Name(), EncName: encodeValue(d.Name()), Size: info.Size(), Modified: info.ModTime().Format(time.RFC3339)})
+		return nil
+	})
+	timedOut := errors.Is(err, context.DeadlineExceeded)
+	if err != nil && err != errSearchDone && !timedOut {
+		panic(config.NewControllerError("Dir could not be read", http.StatusUnprocessableEntity, fmt.Sprintf("Search: %s %s", p.configData.GetPathForDisplay(dir), err.Error())))
+	}
+	if p.verbose != nil {
+		p.verbose(fmt.Sprintf("Search:%s Returned[%d] More[%t] TimedOut[%t]", p.configData.GetPathForDisplay(dir), len(results), more, timedOut))
+	}
+	return NewResponseData(http.StatusOK).WithContentBytes(searchResultsAsJson(results, p.parameters, offset, limit, more, timedOut)).WithMimeType("json")
+}
+
+/*
+Call 'fn' for each file in 'dir' and its sub directories that would be in a file list (FilterFiles
+and no '.' or '_' names). Directories starting with '.' or '_' are not walked. Stops with ctx.Err()
+when 'ctx' is done.
+*/
+func walkFiles(ctx context.Context, dir string, filter []string, fn func(path string, d fs.DirEntry) error) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			// Unreadable directories are left out
+			if d != nil && d.IsDir() && path != dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && (strings.HasPrefix(d.Name(), ".") || strings.HasPrefix(d.Name(), "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !filterFileNames(d.Name(), filter) {
+			return nil
+		}
+		return fn(path, d)
+	})
+}
+
+func (p *SearchHandler) criteria() *searchCriteria {
+	c := &searchCriteria{maxSize: -1}
+	c.glob = strings.ToLower(p.parameters.GetOptionalQuery("name", ""))
+	if c.glob != "" {
+		_, err := filepath.Match(c.glob, "")
+		if err != nil {
+			panic(config.NewControllerError("Invalid name pattern", http.StatusBadRequest, fmt.Sprintf("Search: name=%s %s", c.glob, err.Error())))
+		}
+	}
+	rx := p.parameters.GetOptionalQuery("regex", "")
+	if rx != "" {
+		var err error
+		c.regex, err = regexp.Compile(rx)
+		if err != nil {
+			panic(config.NewControllerError("Invalid regex", http.StatusBadRequest, fmt.Sprintf("Search: regex=%s %s", rx, err.Error())))
+		}
+	}
+	for _, e := range strings.Split(p.parameters.GetOptionalQuery("ext", ""), ",") {
+		e = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(e), "."))
+		if e != "" {
+			c.ext = append(c.ext, "."+e)
+		}
+	}
+	c.minSize = p.searchSize("minSize", 0)
+	c.maxSize = p.searchSize("maxSize", -1)
+	c.after = p.searchTime("after")
+	c.before = p.searchTime("before")
+	return c
+}
+
+func (p *SearchHandler) searchSize(key string, fallback int64) int64 {
+	v := p.parameters.GetOptionalQuery(key, "")
+	if v == "" {
+		return fallback
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || i < 0 {
+		panic(config.NewControllerError(fmt.Sprintf("Query '%s' must be a number of bytes", key), http.StatusBadRequest, fmt.Sprintf("Search: %s=%s", key, v)))
+	}
+	return i
+}
+
+func (p *SearchHandler) searchTime(key string) time.Time {
+	v := p.parameters.GetOptionalQuery(key, "")
+	if v == "" {
+		return time.Time{}
+	}
+	t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, v)
+	}
+	if err != nil {
+		panic(config.NewControllerError(fmt.Sprintf("Query '%s' must be a date (2006-01-02) or RFC3339", key), http.StatusBadRequest, fmt.Sprintf("Search: %s=%s", key, v)))
+	}
+	return t
+}
+
+func (c *searchCriteria) matches(name string, info os.FileInfo) bool {
+	lower := strings.ToLower(name)
+	if c.glob != "" {
+		ok, _ := filepath.Match(c.glob, lower)
+		if !ok {
+			return false
+		}
+	}
+	if c.regex != nil && !c.regex.MatchString(name) {
+		return false
+	}
+	if len(c.ext) > 0 {
+		found := false
+		for _, e := range c.ext {
+			if strings.HasSuffix(lower, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if info.Size() < c.minSize || (c.maxSize >= 0 && info.Size() > c.maxSize) {
+		return false
+	}
+	if !c.after.IsZero() && info.ModTime().Before(c.after) {
+		return false
+	}
+	if !c.before.IsZero() && !info.ModTime().Before(c.before) {
+		return false
+	}
+	return true
+}
+
+func searchResultsAsJson(results []*searchResult, params *UrlRequestParts, offset int, limit int, more bool, timedOut bool) []byte {
+	files, err := json.Marshal(results)
+	if err != nil {
+		panic(config.NewControllerError("controllers:searchResultsAsJson:Marshal", http.StatusInternalServerError, fmt.Sprintf("JSON Marshal:Error:%s", err.Error())))
+	}
+	var buffer bytes.Buffer
+	buffer.WriteRune('{')
+	writeJsonHeader(params, &buffer)
+	buffer.WriteRune(',')
+	writePathToJson(params.GetOptionalParam(PathParam, ""), PathParam, &buffer)
+	buffer.WriteString(fmt.Sprintf("\"offset\":%d,\"limit\":%d,\"count\":%d,\"more\":%t,\"timedOut\":%t,\"files\":", offset, limit, len(results), more, timedOut))
+	buffer.Write(files)
+	buffer.WriteRune('}')
+	return buffer.Bytes()
+}
@@ -0,0 +1,368 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+// The url root of a share link. /share/<token>
+const ShareRoot = "share"
+
+// The share files are read, updated and written as one operation
+var shareLock sync.Mutex
+
+/*
+Used when config Shares.Key is undefined.
+
+Created once so that a config reload does not end all of the current links.
+*/
+var fallbackShareKey = newShareKey()
+
+func newShareKey() []byte {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to create a random share key: %s", err.Error()))
+	}
+	return b
+}
+
+/*
+A link that allows anyone to download one file until it expires.
+The token is not stored. It is signed so it can only be used for this file, expiry and download limit.
+*/
+type ShareLink struct {
+	Id           string    `json:"id"`
+	User         string    `json:"user"`
+	Loc          string    `json:"loc"`
+	Path         string    `json:"path"` // Relative to the location. Empty for the root
+	Name         string    `json:"name"`
+	Created      time.Time `json:"created"`
+	Expires      time.Time `json:"expires"`
+	MaxDownloads int       `json:"maxDownloads"` // 0 is no limit
+	Downloads    int       `json:"downloads"`
+}
+
+func (l *ShareLink) used() bool {
+	return l.MaxDownloads > 0 && l.Downloads >= l.MaxDownloads
+}
+
+/*
+The share links file of the user. A file left in the users Home by an earlier version is moved
+so it is no longer in a location. Must be called with shareLock held.
+*/
+func sharesFile(configData *config.ConfigData, user string) string {
+	sf := configData.GetUserSharesFile(user)
+	legacy := filepath.Join(configData.GetUserRoot(user), config.LegacySharesFileName)
+	info, err := os.Stat(legacy)
+	if err == nil && !info.IsDir() {
+		if _, err = os.Stat(sf); errors.Is(err, os.ErrNotExist) && os.MkdirAll(filepath.Dir(sf), 0755) == nil {
+			moveFile(legacy, sf, info, false)
+		}
+	}
+	return sf
+}
+
+func readShares(file string) ([]*ShareLink, error) {
+	links := []*ShareLink{}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return links, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(b, &links)
+	if err != nil {
+		return nil, fmt.Errorf("share file %s is invalid. %s", filepath.Base(file), err.Error())
+	}
+	return links, nil
+}
+
+/*
+Remove the expired links. Returns true if any were removed.
+*/
+func pruneShares(links []*ShareLink, now time.Time) ([]*ShareLink, bool) {
+	n := len(links)
+	links = slices.DeleteFunc(links, func(l *ShareLink) bool { return now.After(l.Expires) })
+	return links, len(links) != n
+}
+
+func writeShares(file string, links []*ShareLink) error {
+	b, err := json.MarshalIndent(links, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	return config.WriteFileAtomic(file, b, 0644)
+}
+
+func shareKey(configData *config.ConfigData) []byte {
+	key := configData.GetShareData().Key
+	if key == "" {
+		return fallbackShareKey
+	}
+	return []byte(key)
+}
+
+/*
+Token format: base64(user|id) + "." + base64(HMAC-SHA256 of the payload and the link details)
+*/
+func shareToken(key []byte, l *ShareLink) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(l.User + "|" + l.Id))
+	return payload + "." + shareSignature(key, payload, l)
+}
+
+func shareSignature(key []byte, payload string, l *ShareLink) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(fmt.Sprintf("%s|%s|%s|%s|%d|%d", payload, l.Loc, l.Path, l.Name, l.Expires.Unix(), l.MaxDownloads)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func shareJson(key []byte, l *ShareLink) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           l.Id,
+		"user":         l.User,
+		"loc":          l.Loc,
+		"path":         l.Path,
+		"name":         l.Name,
+		"url":          "/" + ShareRoot + "/" + shareToken(key, l),
+		"created":      l.Created.Format(time.RFC3339),
+		"expires":      l.Expires.Format(time.RFC3339),
+		"maxDownloads": l.MaxDownloads,
+		"downloads":    l.Downloads,
+	}
+}
+
+type ShareHandler struct {
+	parameters *UrlRequestParts
+	configData *config.ConfigData
+	verbose    func(string)
+}
+
+/*
+Create a share link for the file in the url.
+
+	hours=48      How long the link is valid. Default Shares.DefaultHours. Max Shares.MaxDays
+	downloads=3   How many times the file can be downloaded. Default 0, no limit
+*/
+func NewShareHandler(urlParts *UrlRequestParts, configData *config.ConfigData, verboseFunc func(string)) Handler {
+	return &ShareHandler{
+		parameters: urlParts,
+		configData: configData,
+		verbose:    verboseFunc,
+	}
+}
+
+func (p *ShareHandler) Submit() *ResponseData {
+	sd := p.configData.GetShareData()
+	hours := p.parameters.GetQueryAsInt("hours", sd.DefaultHours)
+	if hours < 1 || hours > sd.MaxDays*24 {
+		panic(config.NewControllerError(fmt.Sprintf("Query 'hours' must be 1..%d", sd.MaxDays*24), http.StatusBadRequest, fmt.Sprintf("Share: hours=%d", hours)))
+	}
+	downloads := p.parameters.GetQueryAsInt("downloads", 0)
+	if downloads < 0 {
+		panic(config.NewControllerError("Query 'downloads' must be 0 or more", http.StatusBadRequest, fmt.Sprintf("Share: downloads=%d", downloads)))
+	}
+	user := p.parameters.GetUser()
+	loc := p.parameters.GetLocation()
+	file := p.parameters.GetUserLocPath(true, false, p.parameters.GetQueryAsBool("base64", false))
+	fd := p.configData.GetPathForDisplay(file)
+	info, err := os.Stat(file)
+	if err != nil {
+		panic(config.NewControllerError("File not found", http.StatusNotFound, fmt.Sprintf("Share: %s", fd)))
+	}
+	if info.IsDir() {
+		panic(config.NewControllerError("Is a directory", http.StatusForbidden, fmt.Sprintf("Share: %s is a Directory", fd)))
+	}
+	rel, err := filepath.Rel(p.configData.GetUserLocPath(user, loc), filepath.Dir(file))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		panic(config.NewControllerError("File is not in the location", http.StatusForbidden, fmt.Sprintf("Share: %s", fd)))
+	}
+	if rel == "." {
+		rel = ""
+	}
+	now := time.Now()
+	r := make([]byte, 8)
+	rand.Read(r)
+	link := &ShareLink{
+		Id:           hex.EncodeToString(r),
+		User:         user,
+		Loc:          loc,
+		Path:         filepath.ToSlash(rel),
+		Name:         info.Name(),
+		Created:      now.UTC(),
+		Expires:      now.UTC().Add(time.Duration(hours) * time.Hour).Truncate(time.Second),
+		MaxDownloads: downloads,
+	}
+
+	shareLock.Lock()
+	defer shareLock.Unlock()
+	sf := sharesFile(p.configData, user)
+	links, err := readShares(sf)
+	if err == nil {
+		links, _ = pruneShares(links, now)
+		err = writeShares(sf, append(links, link))
+	}
+	if err != nil {
+		panic(config.NewControllerError("Share link could not be saved", http.StatusInternalServerError, fmt.Sprintf("Share: User:%s %s", user, err.Error())))
+	}
+	if p.verbose != nil {
+		p.verbose(fmt.Sprintf("Share: Created:%s for %s until %s", link.Id, fd, link.Expires.Format(time.RFC3339)))
+	}
+	m := shareJson(shareKey(p.configData), link)
+	m["error"] = false
+	content, err := json.Marshal(m)
+	if err != nil {
+		panic(config.NewControllerError("Data Map to Json failed", http.StatusInternalServerError, err.Error()))
+	}
+	return NewResponseData(http.StatusCreated).WithContentBytes(content).WithMimeType("json")
+}
+
+/*
+Returns the file for share link 'token'. If 'count' the download is added to the link.
+
+Panics 404 if the token is invalid or the link was revoked and 410 if it has expired or has no downloads left.
+*/
+func GetSharedFileName(configData *config.ConfigData, token string, count bool, verbose func(string)) string {
+	notFound := func(reason string) {
+		panic(config.NewControllerError("Link not found", http.StatusNotFound, fmt.Sprintf("Share: %s", reason)))
+	}
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		notFound("token is malformed")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		notFound("token payload is invalid")
+	}
+	user, id, ok := strings.Cut(string(data), "|")
+	if !ok || configData.GetUserData(user) == nil {
+		notFound("token payload is malformed")
+	}
+
+	shareLock.Lock()
+	defer shareLock.Unlock()
+	now := time.Now()
+	sf := sharesFile(configData, user)
+	links, err := readShares(sf)
+	if err != nil {
+		panic(config.NewControllerError("Share links could not be read", http.StatusInternalServerError, fmt.Sprintf("Share: User:%s %s", user, err.Error())))
+	}
+	pos := slices.IndexFunc(links, func(l *ShareLink) bool { return l.Id == id })
+	if pos < 0 {
+		notFound(fmt.Sprintf("User:%s Id:%s is not a link", user, id))
+	}
+	link := links[pos]
+	if !hmac.Equal([]byte(sig), []byte(shareSignature(shareKey(configData), payload, link))) {
+		notFound(fmt.Sprintf("User:%s Id:%s signature is invalid", user, id))
+	}
+	if now.After(link.Expires) || link.used() {
+		panic(config.NewControllerError("Link has expired", http.StatusGone, fmt.Sprintf("Share: User:%s Id:%s expired or used. Downloads:%d", user, id, link.Downloads)))
+	}
+	// The location policy may have changed since the link was created
+	configData.CheckLocationAccess(user, link.Loc, user, config.OpRead)
+	root := configData.GetUserLocPath(user, link.Loc)
+	file := filepath.Join(root, filepath.FromSlash(link.Path), link.Name)
+	if !config.PathInLocation(root, file) || config.InServerDir(root, file) {
+		notFound(fmt.Sprintf("User:%s Id:%s is not in location %s", user, id, link.Loc))
+	}
+	// A download is only counted if the file can be sent
+	info, err := os.Stat(file)
+	if err != nil || info.IsDir() {
+		panic(config.NewControllerError("File not found", http.StatusNotFound, fmt.Sprintf("Share: User:%s Id:%s %s", user, id, configData.GetPathForDisplay(file))))
+	}
+	configData.CheckPathAccess(file, config.OpRead)
+	if count {
+		link.Downloads++
+		err = writeShares(sf, links)
+		if err != nil {
+			panic(config.NewControllerError("Share link could not be updated", http.StatusInternalServerError, fmt.Sprintf("Share: User:%s %s", user, err.Error())))
+		}
+	}
+	if verbose != nil {
+		verbose(fmt.Sprintf("Share: Id:%s Downloads:%d File:%s", id, link.Downloads, configData.GetPathForDisplay(file)))
+	}
+	return file
+}
+
+/*
+The share links of all users. Newest first. Expired links are removed.
+*/
+func ListShares(configData *config.ConfigData) *ResponseData {
+	shareLock.Lock()
+	defer shareLock.Unlock()
+	now := time.Now()
+	key := shareKey(configData)
+	list := []*ShareLink{}
+	for user := range *configData.GetUsers() {
+		sf := sharesFile(configData, user)
+		links, err := readShares(sf)
+		if err != nil {
+			panic(config.NewControllerError("Share links could not be read", http.StatusInternalServerError, fmt.Sprintf("Share: User:%s %s", user, err.Error())))
+		}
+		links, expired := pruneShares(links, now)
+		if expired {
+			writeShares(sf, links)
+		}
+		list = append(list, links...)
+	}
+	slices.SortFunc(list, func(a, b *ShareLink) int {
+		return b.Created.Compare(a.Created)
+	})
+	items := []map[string]interface{}{}
+	for _, l := range list {
+		items = append(items, shareJson(key, l))
+	}
+	content, err := json.Marshal(map[string]interface{}{"error": false, "links": items})
+	if err != nil {
+		panic(config.NewControllerError("Data Map to Json failed", http.StatusInternalServerError, err.Error()))
+	}
+	return NewResponseData(http.StatusOK).WithContentBytes(content).WithMimeType("json")
+}
+
+/*
+Remove share link 'id'. The link stops working immediately.
+*/
+func RevokeShare(configData *config.ConfigData, id string, query map[string][]string, verbose func(string)) *ResponseData {
+	shareLock.Lock()
+	defer shareLock.Unlock()
+	for user := range *configData.GetUsers() {
+		sf := sharesFile(configData, user)
+		links, err := readShares(sf)
+		if err != nil {
+			continue
+		}
+		pos := slices.IndexFunc(links, func(l *ShareLink) bool { return l.Id == id })
+		if pos < 0 {
+			continue
+		}
+		err = writeShares(sf, slices.Delete(links, pos, pos+1))
+		if err != nil {
+			panic(config.NewControllerError("Share link could not be revoked", http.StatusInternalServerError, fmt.Sprintf("Share: User:%s Id:%s %s", user, id, err.Error())))
+		}
+		if verbose != nil {
+			verbose(fmt.Sprintf("Share: User:%s Revoked:%s", user, id))
+		}
+		return NewResponseData(http.StatusAccepted).WithContentWithCauseAsJson("Link revoked", query)
+	}
+	panic(config.NewControllerError("Link not found", http.StatusNotFound, fmt.Sprintf("Share: Id:%s", id)))
+}
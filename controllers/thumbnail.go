@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Register decoder
+	"image/jpeg"
+	_ "image/png" // Register decoder
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+// The thumbnail name prefix. Trimmed by ConvertToThumbnail with ThumbNailTrim [20,4]
+const thumbnailTimeFormat = "2006_01_02_15_04_05_"
+
+/*
+Limits the number of pictures decoded (thumbnails and resizes) at the same time. Decoding a photo
+needs a lot of memory. The limit is passed on each call so a config reload takes effect.
+*/
+type decodeLimiter struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	running  int
+	inFlight map[string]*decodeRun // Files being generated
+}
+
+/*
+A file being generated. 'done' is closed when 'err' is set.
+*/
+type decodeRun struct {
+	done chan struct{}
+	err  error
+}
+
+var imageLimiter = newDecodeLimiter()
+
+func newDecodeLimiter() *decodeLimiter {
+	l := &decodeLimiter{inFlight: map[string]*decodeRun{}}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+/*
+Run 'gen' to create 'target' unless another request is already creating it, in which case
+wait for that one. Every waiter gets the error returned by 'gen'.
+*/
+func (l *decodeLimiter) run(target string, max int, gen func() error) error {
+	l.mu.Lock()
+	if r, ok := l.inFlight[target]; ok {
+		l.mu.Unlock()
+		<-r.done
+		return r.err
+	}
+	r := &decodeRun{done: make(chan struct{})}
+	l.inFlight[target] = r
+	for l.running >= max {
+		l.cond.Wait()
+	}
+	l.running++
+	l.mu.Unlock()
+
+	r.err = gen()
+
+	l.mu.Lock()
+	l.running--
+	delete(l.inFlight, target)
+	close(r.done)
+	l.cond.Signal()
+	l.mu.Unlock()
+	return r.err
+}
+
+/*
+The thumbnail name for picture 'name'. YYYY_MM_DD_HH_MM_SS_name.jpg so thumbnails sort by time.
+*/
+func thumbnailName(name string, t time.Time) string {
+	return t.Format(thumbnailTimeFormat) + name + ".jpg"
+}
+
+/*
+Returns the thumbnail for the picture in the url. If there is not one it is generated.
+
+Thumbnails are held in the same path of the users thumbnail location (Thumbnails.Location).
+A thumbnail made by another tool is used if its name, with ThumbNailTrim applied, is the picture name.
+If the policy of the thumbnail location does not allow writes the thumbnail is made in the image cache.
+*/
+func GetThumbnailFileName(urlParts *UrlRequestParts, configData *config.ConfigData, verbose func(string)) string {
+	isBase64 := urlParts.GetQueryAsBool("base64", false)
+	src := urlParts.GetUserLocPath(true, false, isBase64)
+	fd := configData.GetPathForDisplay(src)
+	info, err := os.Stat(src)
+	if err != nil {
+		panic(config.NewControllerError("File not found", http.StatusNotFound, fd))
+	}
+	if info.IsDir() {
+		panic(config.NewControllerError("Is a directory", http.StatusForbidden, fmt.Sprintf("%s is a Directory", fd)))
+	}
+	td := configData.GetThumbnailData()
+	thumbDir := urlParts.withLocation(td.Location).GetUserLocPath(false, false, isBase64)
+	target := filepath.Join(thumbDir, thumbnailName(info.Name(), pictureTime(src, info)))
+	if _, err := os.Stat(target); err == nil {
+		return target
+	}
+	if found := findThumbnail(thumbDir, info.Name(), configData); found != "" {
+		return found
+	}
+	if configData.PathAccessError(target, config.OpWrite) != nil {
+		// The thumbnail location cannot be written. Make it in the image cache instead.
+		size := strconv.Itoa(td.Size)
+		return GetResizedFileName(configData, src, url.Values{"w": {size}, "h": {size}, "format": {"jpeg"}}, verbose)
+	}
+
+	err = imageLimiter.run(target, td.MaxConcurrent, func() error {
+		if _, err := os.Stat(target); err == nil {
+			return nil // Made while waiting
+		}
+		start := time.Now()
+		err := generateThumbnail(src, target, td.Size, td.Quality)
+		if err == nil && verbose != nil {
+			verbose(fmt.Sprintf("Thumbnail:%s generated in %s", configData.GetPathForDisplay(target), time.Since(start)))
+		}
+		return err
+	})
+	if errors.Is(err, image.ErrFormat) {
+		panic(config.NewControllerError("Not a supported image", http.StatusUnsupportedMediaType, fmt.Sprintf("Thumbnail: %s %s", fd, err.Error())))
+	}
+	if err != nil {
+		panic(config.NewControllerError("Thumbnail could not be created", http.StatusUnprocessableEntity, fmt.Sprintf("Thumbnail: %s %s", fd, err.Error())))
+	}
+	return target
+}
+
+/*
+The time the picture was taken. The EXIF DateTime if the picture has one, otherwise the time it was modified.
+*/
+func pictureTime(src string, info os.FileInfo) time.Time {
+	data, err := readExif(src)
+	if err == nil && data.DateTime != "" {
+		t, err := time.ParseInLocation(exifTakenFormat, data.DateTime, time.Local)
+		if err == nil {
+			return t
+		}
+	}
+	return info.ModTime()
+}
+
+func findThumbnail(thumbDir string, name string, configData *config.ConfigData) string {
+	entries, err := os.ReadDir(thumbDir)
+	if err != nil {
+		return ""
+	}
+	for _, e := range entries {
+		if !e.IsDir() && e.Name() != name && configData.ConvertToThumbnail(e.Name(), true) == name {
+			return filepath.Join(thumbDir, e.Name())
+		}
+	}
+	return ""
+}
+
+func generateThumbnail(src string, target string, size int, quality int) error {
+	img, err := decodeImage(src, true)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, (&resizeOptions{w: size, h: size}).apply(img), &jpeg.Options{Quality: quality})
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+	_, err = config.WriteFileAtomicFrom(target, &buf, 0644, false)
+	if errors.Is(err, os.ErrExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+/*
+A picture in a timeline. Path (relative to the location) and Name are for the /files endpoints.
+Source is where the date came from. "name" (the YYYY_MM_DD_HH_MM_SS_ prefix) or "exif".
+*/
+type timelineFile struct {
+	Path    string `json:"path"`
+	EncPath string `json:"encPath"`
+	Name    string `json:"name"`
+	EncName string `json:"encName"`
+	Taken   string `json:"taken"`
+	Source  string `json:"source"`
+	taken   time.Time
+}
+
+type timelineDay struct {
+	Day   int             `json:"day"`
+	Count int             `json:"count"`
+	Files []*timelineFile `json:"files"`
+}
+
+type timelineMonth struct {
+	Month int            `json:"month"`
+	Count int            `json:"count"`
+	Days  []*timelineDay `json:"days"`
+}
+
+type timelineYear struct {
+	Year   int              `json:"year"`
+	Count  int              `json:"count"`
+	Months []*timelineMonth `json:"months"`
+}
+
+/*
+When the picture was taken. From the thumbnail name prefix or, for a JPEG, the EXIF data.
+*/
+func captureTime(file string, name string) (time.Time, string, bool) {
+	if len(name) > len(thumbnailTimeFormat) {
+		t, err := time.ParseInLocation(thumbnailTimeFormat, name[:len(thumbnailTimeFormat)], time.Local)
+		if err == nil {
+			return t, "name", true
+		}
+	}
+	lower := strings.ToLower(name)
+	if strings.HasSuffix(lower, ".jpg") || strings.HasSuffix(lower, ".jpeg") {
+		data, err := readExif(file)
+		if err == nil && data.DateTime != "" {
+			t, err := time.ParseInLocation(exifTakenFormat, data.DateTime, time.Local)
+			if err == nil {
+				return t, "exif", true
+			}
+		}
+	}
+	return time.Time{}, "", false
+}
+
+/*
+Group the files by year, month and day. Newest first.
+*/
+func groupTimeline(files []*timelineFile) []*timelineYear {
+	slices.SortFunc(files, func(a, b *timelineFile) int {
+		c := b.taken.Compare(a.taken)
+		if c == 0 {
+			c = strings.Compare(a.Path+"/"+a.Name, b.Path+"/"+b.Name)
+		}
+		return c
+	})
+	years := []*timelineYear{}
+	var year *timelineYear
+	var month *timelineMonth
+	var day *timelineDay
+	for _, f := range files {
+		if year == nil || year.Year != f.taken.Year() {
+			year = &timelineYear{Year: f.taken.Year(), Months: []*timelineMonth{}}
+			years = append(years, year)
+			month = nil
+		}
+		if month == nil || month.Month != int(f.taken.Month()) {
+			month = &timelineMonth{Month: int(f.taken.Month()), Days: []*timelineDay{}}
+			year.Months = append(year.Months, month)
+			day = nil
+		}
+		if day == nil || day.Day != f.taken.Day() {
+			day = &timelineDay{Day: f.taken.Day(), Files: []*timelineFile{}}
+			month.Days = append(month.Days, day)
+		}
+		day.Files = append(day.Files, f)
+		day.Count++
+		month.Count++
+		year.Count++
+	}
+	return years
+}
+
+type TimelineHandler struct {
+	parameters *UrlRequestParts
+	configData *config.ConfigData
+	request    *http.Request
+	verbose    func(string)
+	onThisDay  bool
+}
+
+/*
+The pictures in a location (or a path in it) and all sub directories grouped by the year, month and
+day they were taken. Pictures without a date (see captureTime) are left out.
+
+	year=2024 month=2    Only pictures taken in that year (and month)
+
+If 'onThisDay' only pictures taken on the month and day of today (or query 'date=2024-06-01'), in
+earlier years, are returned.
+*/
+func NewTimelineHandler(urlParts *UrlRequestParts, configData *config.ConfigData, r *http.Request, onThisDay bool, verboseFunc func(string)) Handler {
+	return &TimelineHandler{
+		parameters: urlParts,
+		configData: configData,
+		request:    r,
+		verbose:    verboseFunc,
+		onThisDay:  onThisDay,
+	}
+}
+
+func (p *TimelineHandler) Submit() *ResponseData {
+	dir := p.parameters.GetUserLocPath(false, false, p.parameters.GetQueryAsBool("base64", false))
+	fd := p.configData.GetPathForDisplay(dir)
+	stats, err := os.Stat(dir)
+	if err != nil {
+		panic(config.NewControllerError("Dir not found", http.StatusNotFound, fd))
+	}
+	if !stats.IsDir() {
+		panic(config.NewControllerError("Is NOT a directory", http.StatusForbidden, fmt.Sprintf("Timeline: %s is NOT a Directory", fd)))
+	}
+	locRoot := p.configData.GetUserLocPath(p.parameters.GetUser(), p.parameters.GetLocation())
+	day := p.day()
+	year := p.parameters.GetQueryAsInt("year", 0)
+	month := p.parameters.GetQueryAsInt("month", 0)
+
+	ctx, cancel := context.WithTimeout(p.request.Context(), searchTimeLimit)
+	defer cancel()
+	files := []*timelineFile{}
+	err = walkFiles(ctx, dir, p.parameters.GetConfigFileFilter(), func(path string, d fs.DirEntry) error {
+		taken, source, ok := captureTime(path, d.Name())
+		if !ok {
+			return nil
+		}
+		if p.onThisDay {
+			if taken.Month() != day.Month() || taken.Day() != day.Day() || taken.Year() >= day.Year() {
+				return nil
+			}
+		} else if (year != 0 && taken.Year() != year) || (month != 0 && int(taken.Month()) != month) {
+			return nil
+		}
+		rel, err := filepath.Rel(locRoot, filepath.Dir(path))
+		if err != nil || rel == "." {
+			rel = ""
+		}
+		rel = filepath.ToSlash(rel)
+		files = append(files, &timelineFile{Path: rel, EncPath: encodeValue(rel), Name: d.Name(), EncName: encodeValue(d.Name()), Taken: taken.Format(exifTakenFormat), Source: source, taken: taken})
+		return nil
+	})
+	timedOut := errors.Is(err, context.DeadlineExceeded)
+	if err != nil && !timedOut {
+		panic(config.NewControllerError("Dir could not be read", http.StatusUnprocessableEntity, fmt.Sprintf("Timeline: %s %s", fd, err.Error())))
+	}
+	years, err := json.Marshal(groupTimeline(files))
+	if err != nil {
+		panic(config.NewControllerError("controllers:TimelineHandler:Marshal", http.StatusInternalServerError, fmt.Sprintf("JSON Marshal:Error:%s", err.Error())))
+	}
+	var buffer bytes.Buffer
+	buffer.WriteRune('{')
+	writeJsonHeader(p.parameters, &buffer)
+	buffer.WriteRune(',')
+	writePathToJson(p.parameters.GetOptionalParam(PathParam, ""), PathParam, &buffer)
+	if p.onThisDay {
+		buffer.WriteString(fmt.Sprintf("\"date\":\"%s\",", day.Format("2006-01-02")))
+	}
+	buffer.WriteString(fmt.Sprintf("\"count\":%d,\"timedOut\":%t,\"years\":", len(files), timedOut))
+	buffer.Write(years)
+	buffer.WriteRune('}')
+	if p.verbose != nil {
+		p.verbose(fmt.Sprintf("Timeline:%s OnThisDay[%t] Returned[%d] TimedOut[%t]", fd, p.onThisDay, len(files), timedOut))
+	}
+	return NewResponseData(http.StatusOK).WithContentBytes(buffer.Bytes()).WithMimeType("json")
+}
+
+/*
+The day for 'on this day'. Today or query 'date'.
+*/
+func (p *TimelineHandler) day() time.Time {
+	v := p.parameters.GetOptionalQuery("date", "")
+	if v == "" {
+		return time.Now()
+	}
+	t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	if err != nil {
+		panic(config.NewControllerError("Query 'date' must be a date (2006-01-02)", http.StatusBadRequest, fmt.Sprintf("Timeline: date=%s", v)))
+	}
+	return t
+}
@@ -0,0 +1,267 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+const trashManifestName = "manifest.json"
+
+// The manifest is read, updated and written as one operation
+var trashLock sync.Mutex
+
+/*
+A deleted file. The file is held in the trash directory with the Id as its name.
+*/
+type TrashItem struct {
+	Id      string    `json:"id"`
+	Loc     string    `json:"loc"`
+	Path    string    `json:"path"`
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Deleted time.Time `json:"deleted"`
+}
+
+func trashDir(configData *config.ConfigData, user string) string {
+	return filepath.Join(configData.GetUserRoot(user), config.TrashDirName)
+}
+
+func readTrashManifest(dir string) ([]*TrashItem, error) {
+	items := []*TrashItem{}
+	b, err := os.ReadFile(filepath.Join(dir, trashManifestName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return items, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(b, &items)
+	if err != nil {
+		return nil, fmt.Errorf("trash manifest %s is invalid. %s", trashManifestName, err.Error())
+	}
+	return items, nil
+}
+
+func writeTrashManifest(dir string, items []*TrashItem) error {
+	b, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return err
+	}
+	return config.WriteFileAtomic(filepath.Join(dir, trashManifestName), b, 0644)
+}
+
+func newTrashId(now time.Time) string {
+	r := make([]byte, 4)
+	rand.Read(r)
+	return fmt.Sprintf("%s-%s", now.UTC().Format("20060102T150405"), hex.EncodeToString(r))
+}
+
+/*
+Move the file in the url to the users trash and add it to the manifest. Returns the new item.
+*/
+func moveToTrash(parts *UrlRequestParts, configData *config.ConfigData, file string, info os.FileInfo) (*TrashItem, error) {
+	trashLock.Lock()
+	defer trashLock.Unlock()
+	user := parts.GetUser()
+	dir := trashDir(configData, user)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	items, err := readTrashManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	path, err := filepath.Rel(configData.GetUserLocPath(user, parts.GetLocation()), filepath.Dir(file))
+	if err != nil || path == "." {
+		path = ""
+	}
+	now := time.Now()
+	item := &TrashItem{
+		Id:      newTrashId(now),
+		Loc:     parts.GetLocation(),
+		Path:    path,
+		Name:    filepath.Base(file),
+		Size:    info.Size(),
+		Deleted: now,
+	}
+	err = moveFile(file, filepath.Join(dir, item.Id), info, false)
+	if err != nil {
+		return nil, err
+	}
+	err = writeTrashManifest(dir, append(items, item))
+	if err != nil {
+		// Put it back so it is not lost
+		moveFile(filepath.Join(dir, item.Id), file, info, false)
+		return nil, err
+	}
+	return item, nil
+}
+
+type TrashHandler struct {
+	parameters *UrlRequestParts
+	configData *config.ConfigData
+	verbose    func(string)
+	action     string
+}
+
+/*
+The trash for the user in the url. 'action' is:
+
+	list     The items in the trash. Newest first.
+	restore  Move item 'id' back to where it was deleted from. Query 'replace=true' to replace a file that is now there.
+	purge    Permanently delete item 'id'. If there is no 'id' the trash is emptied.
+*/
+func NewTrashHandler(urlParts *UrlRequestParts, configData *config.ConfigData, action string, verboseFunc func(string)) Handler {
+	return &TrashHandler{
+		parameters: urlParts,
+		configData: configData,
+		verbose:    verboseFunc,
+		action:     action,
+	}
+}
+
+func (p *TrashHandler) Submit() *ResponseData {
+	user := p.parameters.GetUser()
+	dir := trashDir(p.configData, user)
+	trashLock.Lock()
+	defer trashLock.Unlock()
+	items, err := readTrashManifest(dir)
+	if err != nil {
+		panic(config.NewControllerError("Trash could not be read", http.StatusUnprocessableEntity, fmt.Sprintf("Trash: User:%s %s", user, err.Error())))
+	}
+
+	if p.action == "list" {
+		slices.SortFunc(items, func(a, b *TrashItem) int {
+			return b.Deleted.Compare(a.Deleted)
+		})
+		content, err := json.Marshal(map[string]interface{}{"error": false, "user": user, "items": items})
+		if err != nil {
+			panic(config.NewControllerError("Data Map to Json failed", http.StatusInternalServerError, err.Error()))
+		}
+		return NewResponseData(http.StatusOK).WithContentBytes(content).WithMimeType("json")
+	}
+
+	if p.action == "purge" && !p.parameters.HasParam(IdParam) {
+		for _, item := range items {
+			os.Remove(filepath.Join(dir, item.Id))
+		}
+		p.writeManifest(dir, []*TrashItem{})
+		if p.verbose != nil {
+			p.verbose(fmt.Sprintf("Trash: User:%s Purged[%d]", user, len(items)))
+		}
+		return NewResponseData(http.StatusAccepted).WithContentWithCauseAsJson(fmt.Sprintf("Trash emptied. %d items", len(items)), p.parameters.Query)
+	}
+
+	id := p.parameters.GetParam(IdParam)
+	pos := slices.IndexFunc(items, func(i *TrashItem) bool { return i.Id == id })
+	if pos < 0 || id != filepath.Base(id) {
+		panic(config.NewControllerError("Item not found in trash", http.StatusNotFound, fmt.Sprintf("Trash: User:%s Id:%s", user, id)))
+	}
+	item := items[pos]
+	trashFile := filepath.Join(dir, item.Id)
+
+	if p.action == "purge" {
+		err = os.Remove(trashFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			panic(config.NewControllerError("Item could not be deleted", http.StatusUnprocessableEntity, fmt.Sprintf("Trash: User:%s Id:%s %s", user, id, err.Error())))
+		}
+		p.writeManifest(dir, slices.Delete(items, pos, pos+1))
+		if p.verbose != nil {
+			p.verbose(fmt.Sprintf("Trash: User:%s Purged:%s", user, id))
+		}
+		return NewResponseData(http.StatusAccepted).WithContentWithCauseAsJson("Item deleted OK", p.parameters.Query)
+	}
+
+	// Restore
+	info, err := os.Stat(trashFile)
+	if err != nil {
+		// The file has gone so the item is of no use
+		p.writeManifest(dir, slices.Delete(items, pos, pos+1))
+		panic(config.NewControllerError("Item not found in trash", http.StatusNotFound, fmt.Sprintf("Trash: User:%s Id:%s file is missing", user, id)))
+	}
+	p.configData.CheckLocationAccess(user, item.Loc, p.parameters.GetIdentity(), config.OpWrite)
+	root := p.configData.GetUserLocPath(user, item.Loc)
+	target := filepath.Join(root, item.Path, item.Name)
+	fd := p.configData.GetPathForDisplay(target)
+	// The manifest is data. The target must be a file within the location as for any other request
+	if item.Name != filepath.Base(item.Name) || target == root || !config.PathInLocation(root, target) || config.InServerDir(root, target) {
+		panic(config.NewControllerError("Item cannot be restored", http.StatusForbidden, fmt.Sprintf("Trash: User:%s Id:%s File:%s is not within the location", user, id, fd)))
+	}
+	p.configData.CheckPathAccess(target, config.OpWrite)
+	err = os.MkdirAll(filepath.Dir(target), 0755)
+	if err == nil {
+		err = moveFile(trashFile, target, info, p.parameters.GetQueryAsBool("replace", false))
+	}
+	if errors.Is(err, os.ErrExist) {
+		panic(config.NewControllerError("File exists", http.StatusPreconditionFailed, fmt.Sprintf("Trash: File:%s already exists", fd)))
+	}
+	if err != nil {
+		panic(config.NewControllerError("Item could not be restored", http.StatusUnprocessableEntity, fmt.Sprintf("Trash: File:%s %s", fd, err.Error())))
+	}
+	p.writeManifest(dir, slices.Delete(items, pos, pos+1))
+	if p.verbose != nil {
+		p.verbose(fmt.Sprintf("Trash: User:%s Restored:%s to %s", user, id, fd))
+	}
+	return NewResponseData(http.StatusAccepted).WithContentWithCauseAsJson(fmt.Sprintf("File:Action:restore %s", fd), p.parameters.Query)
+}
+
+func (p *TrashHandler) writeManifest(dir string, items []*TrashItem) {
+	err := writeTrashManifest(dir, items)
+	if err != nil {
+		panic(config.NewControllerError("Trash could not be updated", http.StatusInternalServerError, fmt.Sprintf("Trash: %s %s", p.configData.GetPathForDisplay(dir), err.Error())))
+	}
+}
+
+/*
+Permanently delete items deleted more than 'retention' before 'now' from the trash of every user.
+Files in the trash that are not in the manifest are also removed.
+
+Returns the items (user:id) that were removed.
+*/
+func PurgeTrash(configData *config.ConfigData, now time.Time, retention time.Duration) []string {
+	trashLock.Lock()
+	defer trashLock.Unlock()
+	removed := []string{}
+	for user := range *configData.GetUsers() {
+		dir := trashDir(configData, user)
+		items, err := readTrashManifest(dir)
+		if err != nil {
+			continue
+		}
+		keep := []*TrashItem{}
+		known := map[string]bool{trashManifestName: true}
+		for _, item := range items {
+			if now.Sub(item.Deleted) < retention {
+				keep = append(keep, item)
+				known[item.Id] = true
+				continue
+			}
+			os.Remove(filepath.Join(dir, item.Id))
+			removed = append(removed, user+":"+item.Id)
+		}
+		if len(keep) != len(items) {
+			writeTrashManifest(dir, keep)
+		}
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if !e.IsDir() && !known[e.Name()] {
+				if os.Remove(filepath.Join(dir, e.Name())) == nil {
+					removed = append(removed, user+":"+e.Name())
+				}
+			}
+		}
+	}
+	return removed
+}
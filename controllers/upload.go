@@ -0,0 +1,242 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+/*
+A parsed Content-Range request header.
+
+'bytes 0-999/5000' is a chunk. A range of '*' with the total ('bytes *' + '/5000')
+asks how much has been received.
+*/
+type contentRange struct {
+	start int64
+	end   int64 // Inclusive
+	total int64
+	query bool
+}
+
+/*
+A lock for each partial file. Chunks for the same upload are written one at a time so the
+offset check, the write and the final rename see a consistent file.
+*/
+type keyedLock struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLockEntry
+}
+
+type keyedLockEntry struct {
+	mu   sync.Mutex
+	refs int
+}
+
+var uploadLocks = &keyedLock{locks: map[string]*keyedLockEntry{}}
+
+/*
+Lock 'key'. Call the returned func to unlock it.
+*/
+func (k *keyedLock) lock(key string) func() {
+	k.mu.Lock()
+	e, ok := k.locks[key]
+	if !ok {
+		e = &keyedLockEntry{}
+		k.locks[key] = e
+	}
+	e.refs++
+	k.mu.Unlock()
+
+	e.mu.Lock()
+	return func() {
+		e.mu.Unlock()
+		k.mu.Lock()
+		e.refs--
+		if e.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+func parseContentRange(h string) (*contentRange, error) {
+	h = strings.TrimSpace(h)
+	if !strings.HasPrefix(h, "bytes ") {
+		return nil, fmt.Errorf("unit must be 'bytes'")
+	}
+	rng, tot, ok := strings.Cut(strings.TrimSpace(h[6:]), "/")
+	if !ok {
+		return nil, fmt.Errorf("total is missing")
+	}
+	total, err := strconv.ParseInt(tot, 10, 64)
+	if err != nil || total < 1 {
+		return nil, fmt.Errorf("total '%s' must be a positive integer", tot)
+	}
+	if rng == "*" {
+		return &contentRange{total: total, query: true}, nil
+	}
+	s, e, ok := strings.Cut(rng, "-")
+	if !ok {
+		return nil, fmt.Errorf("range '%s' must be start-end", rng)
+	}
+	start, err1 := strconv.ParseInt(s, 10, 64)
+	end, err2 := strconv.ParseInt(e, 10, 64)
+	if err1 != nil || err2 != nil || start < 0 || end < start || end >= total {
+		return nil, fmt.Errorf("range '%s' is invalid for total %d", rng, total)
+	}
+	return &contentRange{start: start, end: end, total: total}, nil
+}
+
+/*
+The partial file for an upload to 'file'. It is held in the UploadDirName directory of the
+location so the final rename does not cross a file system.
+
+A different total size is a different upload.
+*/
+func uploadPartName(locRoot string, file string, total int64) string {
+	sum := sha256.Sum256([]byte(file))
+	return filepath.Join(locRoot, config.UploadDirName, fmt.Sprintf(".%s-%d.part", hex.EncodeToString(sum[:12]), total))
+}
+
+/*
+Resumable upload. Used by PostFileHandler when the request has a Content-Range header.
+
+Each chunk is written to the partial file at its start offset. A chunk may repeat data already
+received but may not leave a gap. Chunks for the same partial file are written one at a time. When the last byte is received the partial file is renamed
+to the target file.
+
+Until then the response is 308 with 'Upload-Offset' (the number of bytes received) and,
+if any have been received, 'Range: bytes=0-<offset-1>'.
+*/
+func (p *PostFileHandler) submitChunk(file string, fd string, action string) *ResponseData {
+	if action != "save" && action != "replace" {
+		panic(config.NewControllerError("Action not supported for a chunked upload", http.StatusBadRequest, fmt.Sprintf("Chunked upload: action=%s File:%s", action, fd)))
+	}
+	cr, err := parseContentRange(p.request.Header.Get("Content-Range"))
+	if err != nil {
+		panic(config.NewControllerError("Invalid Content-Range", http.StatusBadRequest, fmt.Sprintf("Chunked upload: File:%s %s", fd, err.Error())))
+	}
+	if action == "save" {
+		_, err := os.Stat(file)
+		if err == nil {
+			panic(config.NewControllerError("File exists", http.StatusPreconditionFailed, fmt.Sprintf("File:%s already exists", fd)))
+		}
+	}
+	part := uploadPartName(p.configData.GetUserLocPath(p.parameters.GetUser(), p.parameters.GetLocation()), file, cr.total)
+	err = os.MkdirAll(filepath.Dir(part), 0755)
+	if err != nil {
+		panic(config.NewControllerError("Failed to save data", http.StatusInternalServerError, fmt.Sprintf("Chunked upload: Could not create %s. %s", filepath.Dir(part), err.Error())))
+	}
+	unlock := uploadLocks.lock(part)
+	defer unlock()
+	var offset int64
+	st, err := os.Stat(part)
+	if err == nil {
+		offset = st.Size()
+	}
+	if cr.query {
+		return uploadProgress(http.StatusPermanentRedirect, offset, "Upload incomplete", p.parameters.Query)
+	}
+	if cr.start > offset {
+		return uploadProgress(http.StatusRequestedRangeNotSatisfiable, offset, fmt.Sprintf("Chunk starts at %d. Expected %d", cr.start, offset), p.parameters.Query)
+	}
+
+	f, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		panic(config.NewControllerError("Failed to save data", http.StatusInternalServerError, fmt.Sprintf("Chunked upload: File:%s %s", fd, err.Error())))
+	}
+	defer f.Close()
+	err = f.Truncate(cr.start)
+	if err == nil {
+		_, err = f.Seek(cr.start, io.SeekStart)
+	}
+	if err != nil {
+		panic(config.NewControllerError("Failed to save data", http.StatusInternalServerError, fmt.Sprintf("Chunked upload: File:%s %s", fd, err.Error())))
+	}
+	n, err := io.CopyN(f, p.request.Body, cr.end-cr.start+1)
+	if err != nil {
+		// What was written is kept. The client can ask for the offset and resume.
+		panic(config.NewControllerError("Chunk incomplete", http.StatusBadRequest, fmt.Sprintf("Chunked upload: File:%s received %d of %d bytes. %s", fd, n, cr.end-cr.start+1, err.Error())))
+	}
+	offset = cr.end + 1
+	if p.verbose != nil {
+		p.verbose(fmt.Sprintf("File chunk:%s [%d-%d/%d]", fd, cr.start, cr.end, cr.total))
+	}
+	if offset < cr.total {
+		return uploadProgress(http.StatusPermanentRedirect, offset, "Upload incomplete", p.parameters.Query)
+	}
+
+	err = f.Sync()
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		panic(config.NewControllerError("Failed to save data", http.StatusInternalServerError, fmt.Sprintf("Chunked upload: File:%s %s", fd, err.Error())))
+	}
+	if action == "replace" {
+		p.parameters.keepVersion(file)
+	}
+	err = config.RenameDurable(part, file, action == "replace")
+	if errors.Is(err, os.ErrExist) {
+		panic(config.NewControllerError("File exists", http.StatusPreconditionFailed, fmt.Sprintf("File:%s already exists", fd)))
+	}
+	if err != nil {
+		panic(config.NewControllerError("Failed to save data", http.StatusInternalServerError, fmt.Sprintf("Chunked upload: Rename to File:%s %s", fd, err.Error())))
+	}
+	if p.verbose != nil {
+		p.verbose(fmt.Sprintf("File action[%s]:%s [%d] bytes (chunked)", action, fd, cr.total))
+	}
+	return NewResponseData(http.StatusAccepted).WithContentWithCauseAsJson(fmt.Sprintf("File:Action:%s %s", action, fd), p.parameters.Query)
+}
+
+func uploadProgress(status int, offset int64, cause string, queries map[string][]string) *ResponseData {
+	rd := NewResponseData(status).WithHeader("Upload-Offset", strconv.FormatInt(offset, 10))
+	if offset > 0 {
+		rd.WithHeader("Range", fmt.Sprintf("bytes=0-%d", offset-1))
+	}
+	if status == http.StatusPermanentRedirect {
+		rd.SetHasErrors(false)
+	}
+	return rd.WithContentWithCauseAsJson(cause, queries)
+}
+
+/*
+Remove partial uploads that have not been written to since 'expiry' before 'now'.
+
+Returns the files that were removed.
+*/
+func RemoveStaleUploads(configData *config.ConfigData, now time.Time, expiry time.Duration) []string {
+	removed := []string{}
+	for _, loc := range configData.GetLocationPaths() {
+		dir := filepath.Join(loc, config.UploadDirName)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if e.IsDir() || !strings.HasSuffix(e.Name(), ".part") {
+				continue
+			}
+			f := filepath.Join(dir, e.Name())
+			unlock := uploadLocks.lock(f) // Not while a chunk is being written
+			info, err := os.Stat(f)
+			if err == nil && now.Sub(info.ModTime()) >= expiry && os.Remove(f) == nil {
+				removed = append(removed, f)
+			}
+			unlock()
+		}
+	}
+	return removed
+}
@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+// Version ids are the UTC time the version was replaced so they sort by age
+const versionIdFormat = "20060102T150405.000000000Z"
+
+type FileVersion struct {
+	Id       string `json:"id"`
+	Size     int64  `json:"size"`
+	Modified string `json:"modified"`
+}
+
+/*
+The directory holding the previous versions of 'file'.
+*/
+func versionsDir(file string) string {
+	return filepath.Join(filepath.Dir(file), config.VersionsDirName, filepath.Base(file))
+}
+
+/*
+Keep the current content of 'file' as a version before it is replaced. Only the newest
+'keep' versions are kept.
+
+A hard link is used so no data is copied. The replace writes a new file so the link keeps the
+old content. If links are not supported the file is copied.
+*/
+func keepVersion(file string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	info, err := os.Stat(file)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil // Nothing to keep
+	}
+	dir := versionsDir(file)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	version := filepath.Join(dir, time.Now().UTC().Format(versionIdFormat))
+	err = os.Link(file, version)
+	if err != nil {
+		err = copyFile(file, version, info, false)
+		if err != nil {
+			return err
+		}
+	}
+	versions := listVersions(file)
+	for _, v := range versions[min(keep, len(versions)):] {
+		os.Remove(filepath.Join(dir, v.Id))
+	}
+	return config.SyncDir(dir)
+}
+
+/*
+The versions of 'file'. Newest first.
+*/
+func listVersions(file string) []*FileVersion {
+	list := []*FileVersion{}
+	entries, err := os.ReadDir(versionsDir(file))
+	if err != nil {
+		return list
+	}
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		list = append(list, &FileVersion{Id: e.Name(), Size: info.Size(), Modified: info.ModTime().Format(time.RFC3339)})
+	}
+	slices.SortFunc(list, func(a, b *FileVersion) int {
+		return strings.Compare(b.Id, a.Id)
+	})
+	return list
+}
+
+/*
+Keep a version of the file in the url (if the location keeps versions) before it is replaced.
+*/
+func (p *UrlRequestParts) keepVersion(file string) {
+	err := keepVersion(file, p.config.GetKeepVersions(p.GetUser(), p.GetLocation()))
+	if err != nil {
+		panic(config.NewControllerError("Failed to keep previous version", http.StatusInternalServerError, fmt.Sprintf("File:%s Error:%s", p.config.GetPathForDisplay(file), err.Error())))
+	}
+}
+
+/*
+The file for version 'id' of the file in the url. Used to download a version.
+*/
+func GetVersionFileName(urlParts *UrlRequestParts) string {
+	file := urlParts.GetUserLocPath(true, false, urlParts.GetQueryAsBool("base64", false))
+	id := urlParts.GetParam(IdParam)
+	if id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		panic(config.NewControllerError("Version not found", http.StatusNotFound, fmt.Sprintf("Version:%s is invalid", id)))
+	}
+	return filepath.Join(versionsDir(file), id)
+}
+
+type VersionsHandler struct {
+	parameters *UrlRequestParts
+	configData *config.ConfigData
+	verbose    func(string)
+	rollback   bool
+}
+
+/*
+List the versions of the file in the url, newest first. If 'rollback' the file is replaced by
+version 'id'. The current content is kept as a version first so a roll back can be undone.
+*/
+func NewVersionsHandler(urlParts *UrlRequestParts, configData *config.ConfigData, rollback bool, verboseFunc func(string)) Handler {
+	return &VersionsHandler{
+		parameters: urlParts,
+		configData: configData,
+		verbose:    verboseFunc,
+		rollback:   rollback,
+	}
+}
+
+func (p *VersionsHandler) Submit() *ResponseData {
+	file := p.parameters.GetUserLocPath(true, false, p.parameters.GetQueryAsBool("base64", false))
+	fd := p.configData.GetPathForDisplay(file)
+	if !p.rollback {
+		versions := listVersions(file)
+		content, err := json.Marshal(map[string]interface{}{"error": false, "user": p.parameters.GetUser(), "loc": p.parameters.GetLocation(), "name": filepath.Base(file), "versions": versions})
+		if err != nil {
+			panic(config.NewControllerError("Data Map to Json failed", http.StatusInternalServerError, err.Error()))
+		}
+		return NewResponseData(http.StatusOK).WithContentBytes(content).WithMimeType("json")
+	}
+
+	version := GetVersionFileName(p.parameters)
+	// Opened first. If keeping the current content removes the oldest version it can still be read
+	f, err := os.Open(version)
+	if err != nil {
+		panic(config.NewControllerError("Version not found", http.StatusNotFound, fmt.Sprintf("File:%s Version:%s", fd, filepath.Base(version))))
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err == nil {
+		// Keep at least one so the current content is not lost
+		err = keepVersion(file, max(p.configData.GetKeepVersions(p.parameters.GetUser(), p.parameters.GetLocation()), 1))
+	}
+	if err == nil {
+		_, err = config.WriteFileAtomicFrom(file, f, info.Mode().Perm(), true)
+	}
+	if err != nil {
+		panic(config.NewControllerError("Roll back failed", http.StatusInternalServerError, fmt.Sprintf("File:%s Version:%s Error:%s", fd, filepath.Base(version), err.Error())))
+	}
+	if p.verbose != nil {
+		p.verbose(fmt.Sprintf("File action[rollback]:%s Version:%s", fd, filepath.Base(version)))
+	}
+	return NewResponseData(http.StatusAccepted).WithContentWithCauseAsJson(fmt.Sprintf("File:Action:rollback %s to %s", fd, filepath.Base(version)), p.parameters.Query)
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"os/exec"
 	"strings"
 	"time"
 
@@ -15,6 +16,9 @@ import (
 
 const fallbackModuleName = "goWebApp"
 
+// The admin password used by -k when the admin user has a PasswordHash
+const adminPasswordEnvName = "WebServerAdminPassword"
+
 func main() {
 	createLocationsFlag := getArgFlag("c")
 	doNotRun := getArgFlag("t")
@@ -31,6 +35,41 @@ func main() {
 		osExitWithMessage(0, string(h))
 	}
 
+	// Create a PasswordHash for a user in the config file. The password is read from the console
+	// so it is not shown in the process list or kept in the shell history.
+	if _, found := getArgValue("hash"); found {
+		osExitWithMessage(1, "hash=<password> is not supported. Use 'hash' and enter the password when asked")
+	}
+	if getArgWord("hash") {
+		password := osReadPassword("Password to hash")
+		if password == "" {
+			osExitWithMessage(1, "Password is empty")
+		}
+		h, err := config.HashPassword(password)
+		if err != nil {
+			osExitWithMessage(1, fmt.Sprintf("Failed to hash password: %s", err.Error()))
+		}
+		osExitWithMessage(0, h)
+	}
+
+	// Create a self signed certificate for this machine. Must be checked before the single letter flags.
+	if getArgFlag("gencert") {
+		certFile, ok := getArgValue("cert")
+		if !ok {
+			certFile = fallbackModuleName + "-cert.pem"
+		}
+		keyFile, ok := getArgValue("key")
+		if !ok {
+			keyFile = fallbackModuleName + "-key.pem"
+		}
+		hosts := server.LocalHostNames()
+		err := server.GenerateSelfSignedCert(certFile, keyFile, hosts)
+		if err != nil {
+			osExitWithMessage(1, fmt.Sprintf("Failed to create certificate: %s", err.Error()))
+		}
+		osExitWithMessage(0, fmt.Sprintf("Created TLSCertFile:%s TLSKeyFile:%s for %s", certFile, keyFile, strings.Join(hosts, ",")))
+	}
+
 	moduleName, debugging := getApplicationModuleName(fallbackModuleName)
 	configFileName, ok := getArgValue("config=")
 	if !ok {
@@ -59,7 +98,16 @@ func main() {
 	}
 
 	if killServer {
-		server.SendToHost(cfg.GetPortString(), server.ServerExitUrl)
+		// Once roles are enforced /server/exit needs the admin user. Password from the environment or the console.
+		user, password := "", ""
+		if cfg.RolesEnforced() {
+			user = config.AdminUserName
+			password = os.Getenv(adminPasswordEnvName)
+			if password == "" {
+				password = osReadLine(fmt.Sprintf("Password for user '%s' to stop the server", user))
+			}
+		}
+		server.SendToHost(cfg.GetPortString(), server.ServerExitUrl, cfg.IsTLS(), user, password)
 		time.Sleep(999 * time.Millisecond)
 		osExitWithMessage(0, "Server exit requested")
 	}
@@ -105,7 +153,7 @@ func main() {
 	if lrm.IsEnabled() {
 		for n, v := range cfg.GetExecData() {
 			if v.Detached {
-				err := lrm.AddLongRunningProcessData(n, v.Description, v.Cmd, v.CanStop)
+				err := lrm.AddLongRunningProcessData(n, v.Description, v.Cmd, v.CanStop, v.StopOnExit())
 				if err != nil {
 					osExitWithMessage(1, fmt.Sprintf("LongRunningManager: failed to add long running (Detached) process. '%s'. ABORTED", err.Error()))
 				}
@@ -132,19 +180,25 @@ func main() {
 		os.Exit(1)
 	}
 
+	stopSignals := server.NotifySignals(actionQueue)
+	defer stopSignals()
+
 	go func() {
 		for {
 			a := <-actionQueue
 			if a != nil {
 				switch a.Id {
 				case server.Exit:
+					// Close drains in-flight requests then flushes and closes the logger
 					logger.Log(fmt.Sprintf("Server Terminated : %s", a.String()))
-					time.Sleep(200 * time.Millisecond)
-					logger.Close()
-					time.Sleep(200 * time.Millisecond)
 					webAppServer.Close(a.Rc)
+				case server.Reload:
+					err := webAppServer.Handler.ReloadConfig("by SIGHUP")
+					if err != nil {
+						logger.Log(fmt.Sprintf("Config: Failed to re-load. %s", err.Error()))
+					}
 				case server.Ignore:
-					logger.Log("Server: Action Ignore")
+					logger.Log(fmt.Sprintf("Server: Action Ignore. %s", a.Msg))
 				}
 			}
 		}
@@ -177,6 +231,18 @@ func getArgValue(name string) (string, bool) {
 	return "", false
 }
 
+/*
+True if 'name' is an argument on its own (not a flag or a name=value).
+*/
+func getArgWord(name string) bool {
+	for i := 1; i < len(os.Args); i++ {
+		if strings.EqualFold(os.Args[i], name) {
+			return true
+		}
+	}
+	return false
+}
+
 func osExitWithMessage(rc int, message string) {
 	if rc > 0 {
 		os.Stderr.WriteString(message)
@@ -231,3 +297,40 @@ func getApplicationModuleName(fallbackModuleName string) (string, bool) {
 	}
 	return exec, false
 }
+
+func osReadLine(message string) string {
+	os.Stdout.WriteString(message)
+	os.Stdout.WriteString(" :")
+	reader := bufio.NewReader(os.Stdin)
+	line, err := reader.ReadString('\n')
+	if err != nil && line == "" {
+		osExitWithMessage(1, fmt.Sprintf("Input was not understood: Error:%s", err.Error()))
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+/*
+As osReadLine but the input is not echoed. Uses 'stty' so the input is echoed if it is not available
+(for example on Windows or when the input is not a terminal).
+*/
+func osReadPassword(message string) string {
+	os.Stdout.WriteString(message)
+	os.Stdout.WriteString(" :")
+	if stty("-echo") == nil {
+		defer func() {
+			stty("echo")
+			os.Stdout.WriteString("\n")
+		}()
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return ""
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
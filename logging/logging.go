@@ -17,6 +17,7 @@ type Logger interface {
 	IsOpen() bool
 	Close()
 	LogFileName() string
+	QueueDepth() int
 }
 
 type logFileData struct {
@@ -89,8 +90,10 @@ type logger struct {
 	nextFileCheck  time.Time
 	consoleOut     bool
 	queue          chan string
+	done           chan struct{} // Closed when deQueue has written every queued message
 	mu1            sync.Mutex
 	mu2            sync.Mutex
+	fileMu         sync.Mutex // Guards logFileData. deQueue replaces it when the log file rolls over
 	noMoreCalls    bool
 	verboseLog     bool
 }
@@ -121,6 +124,7 @@ func NewLogger(pPath string, pFileNameMask string, pMonitorSeconds int, consoleO
 		nextFileCheck:  getNextMonitorTime(pMonitorSeconds),
 		consoleOut:     consoleOut,
 		queue:          make(chan string, 20),
+		done:           make(chan struct{}),
 		noMoreCalls:    false,
 		verboseLog:     isVerbose,
 	}
@@ -141,23 +145,31 @@ func (l *logger) verbose(s string) {
 	}
 }
 
+/*
+Close stops the logger. Every message logged before Close is written and the file is synced before it returns.
+
+Messages logged after Close are written to the console.
+*/
 func (l *logger) Close() {
 	l.mu2.Lock()
 	defer l.mu2.Unlock()
+	l.mu1.Lock()
 	if l.noMoreCalls {
+		l.mu1.Unlock()
 		return
 	}
 	l.noMoreCalls = true
-	count := 2000
-	for len(l.queue) > 0 && count > 0 {
-		time.Sleep(time.Millisecond)
-		count--
-	}
-	l.logFileData.close()
 	close(l.queue)
+	l.mu1.Unlock()
+	<-l.done
+	l.fileMu.Lock()
+	l.logFileData.close()
+	l.fileMu.Unlock()
 }
 
 func (l *logger) IsOpen() bool {
+	l.fileMu.Lock()
+	defer l.fileMu.Unlock()
 	return l.logFileData.isOpen()
 }
 
@@ -171,24 +183,40 @@ func (l *logger) Log(msg string) {
 	l.queue <- msg
 }
 
+/*
+LogFileName returns the name of the file being written. It can be called from any goroutine.
+*/
 func (l *logger) LogFileName() string {
+	l.fileMu.Lock()
+	defer l.fileMu.Unlock()
 	if l.logFileData.fileName == "" {
 		return l.fileNameMask
 	}
 	return l.logFileData.fileName
 }
 
+/*
+QueueDepth returns the number of messages waiting to be written.
+*/
+func (l *logger) QueueDepth() int {
+	return len(l.queue)
+}
+
 func (l *logger) deQueue() {
+	defer close(l.done)
 	for msg := range l.queue {
 		t := time.Now()
 		if t.After(l.nextFileCheck) {
 			l.nextFileCheck = getNextMonitorTime(l.monitorSeconds)
 			l.datePrefix = newDatePrefix(t)
+			l.fileMu.Lock()
 			l.logFileData = l.logFileData.reOpen(l.path, l.fileNameMask, t)
+			l.fileMu.Unlock()
 		}
 
+		// Only deQueue changes logFileData so it can be used here without the lock
 		out := buildLogLine(msg, l.datePrefix, t)
-		if l.IsOpen() {
+		if l.logFileData.isOpen() {
 			l.logFileData.logFile.WriteString(out)
 			if l.consoleOut {
 				os.Stderr.WriteString(out)
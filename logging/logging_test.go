@@ -59,6 +59,54 @@ func TestLoggingFunctions(t *testing.T) {
 	AssertEquals(t, "FLi0999", fixedLenInt(999, 4), "0999")
 }
 
+func TestLogFileNameWhileLogging(t *testing.T) {
+	// The file is checked for every message. Run with -race to check LogFileName is safe
+	l, err := NewLogger(t.TempDir(), "roll-%H-%M-%S.log", 0, false, false)
+	if err != nil {
+		t.Fatalf("NewLogger failed: %s", err.Error())
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			if !strings.HasPrefix(l.LogFileName(), "roll-") || !l.IsOpen() {
+				t.Errorf("LogFileName:%s", l.LogFileName())
+				return
+			}
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		l.Log("Line")
+	}
+	<-done
+	l.Close()
+}
+
+func TestLoggingCloseFlushes(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLogger(dir, "flush.log", 60, false, false)
+	if err != nil {
+		t.Fatalf("NewLogger failed: %s", err.Error())
+	}
+	for i := 0; i < 200; i++ {
+		l.Log("Line")
+	}
+	l.Log("Last Line")
+	l.Close()
+	l.Close() // Second close is ignored
+	b, err := os.ReadFile(filepath.Join(dir, "flush.log"))
+	if err != nil {
+		t.Fatalf("Log file not found: %s", err.Error())
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 201 {
+		t.Fatalf("Log should have 201 lines. Found %d", len(lines))
+	}
+	if !strings.HasSuffix(lines[200], "Last Line") {
+		t.Fatalf("Last line is wrong: %s", lines[200])
+	}
+}
+
 func TestLoggingSink(t *testing.T) {
 	l, err := NewLogger("", "goWebServer-test.log", 10, false, false)
 	if err != nil {
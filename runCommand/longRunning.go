@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 )
 
 type LongRunningProcess struct {
@@ -15,6 +16,7 @@ type LongRunningProcess struct {
 	Description string // Description from config exec list
 	PID         int    // The process id PID from the running process
 	CanStop     bool   // The ability to terminate the process while running
+	StopOnExit  bool   // Terminate the process when the server stops
 }
 
 type LongRunningManager struct {
@@ -57,7 +59,7 @@ func NewLongRunningManager(path string, log func(string)) (*LongRunningManager,
 	return lrm, nil
 }
 
-func (p *LongRunningManager) AddLongRunningProcessData(id string, desc string, cmdList []string, canStop bool) error {
+func (p *LongRunningManager) AddLongRunningProcessData(id string, desc string, cmdList []string, canStop bool, stopOnExit bool) error {
 	if len(cmdList) == 0 {
 		return fmt.Errorf("detached command: Exec:%s is empty", id)
 	}
@@ -90,6 +92,7 @@ func (p *LongRunningManager) AddLongRunningProcessData(id string, desc string, c
 		PID:         0,
 		Description: desc,
 		CanStop:     canStop,
+		StopOnExit:  stopOnExit,
 	}
 	return nil
 }
@@ -104,10 +107,56 @@ func (p *LongRunningManager) Update() {
 	}
 }
 
+/*
+Terminate the running processes that are StopOnExit. Processes are started in their own
+process group so the group is sent SIGTERM. Other processes are left running.
+
+Returns a message for each process for the log.
+*/
+func (p *LongRunningManager) StopOnExit() []string {
+	msgs := []string{}
+	if !p.enabled {
+		return msgs
+	}
+	for _, v := range p.longRunningProcess {
+		pid := FindProcessIdWithName(v.Exec)
+		if pid == 0 {
+			continue
+		}
+		if !v.StopOnExit {
+			msgs = append(msgs, fmt.Sprintf("Detached process left running: ID[%s] PID:%d", v.ID, pid))
+			continue
+		}
+		err := syscall.Kill(-pid, syscall.SIGTERM)
+		if err != nil {
+			err = syscall.Kill(pid, syscall.SIGTERM)
+		}
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("Detached process could not be stopped: ID[%s] PID:%d Error:%s", v.ID, pid, err.Error()))
+		} else {
+			msgs = append(msgs, fmt.Sprintf("Detached process stopped: ID[%s] PID:%d", v.ID, pid))
+		}
+	}
+	return msgs
+}
+
 func (p *LongRunningManager) Len() int {
 	return len(p.longRunningProcess)
 }
 
+/*
+Running returns the number of processes that had a PID at the last Update.
+*/
+func (p *LongRunningManager) Running() int {
+	n := 0
+	for _, v := range p.longRunningProcess {
+		if v.PID > 0 {
+			n++
+		}
+	}
+	return n
+}
+
 func (p *LongRunningManager) String() string {
 	if p.enabled {
 		return fmt.Sprintf("ExecPath:%s", p.path)
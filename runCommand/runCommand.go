@@ -13,6 +13,8 @@ import (
 	"strings"
 	"syscall"
 	"time"
+
+	"github.com/stuartdd/goWebApp/config"
 )
 
 // Cmd[0] is the script name (shell, cmd,,,). Each parameter is Cmd[n]
@@ -202,7 +204,7 @@ func (p *execData) RunSystemProcess(execDir string) ([]byte, []byte, int) {
 	sob := stdout.Bytes()
 	if p.StdOutLog != "" {
 		if len(sob) > 0 {
-			err = os.WriteFile(p.StdOutLog, sob, 0644)
+			err = config.WriteFileAtomic(p.StdOutLog, sob, 0644)
 			if err != nil {
 				panic(NewExecError("Could not write to StdOut log", p.id, fmt.Sprintf("Config error:%s", err.Error()), http.StatusFailedDependency))
 			}
@@ -211,7 +213,7 @@ func (p *execData) RunSystemProcess(execDir string) ([]byte, []byte, int) {
 	seb := stderr.Bytes()
 	if p.StdErrLog != "" {
 		if len(seb) > 0 {
-			err = os.WriteFile(p.StdErrLog, seb, 0644)
+			err = config.WriteFileAtomic(p.StdErrLog, seb, 0644)
 			if err != nil {
 				panic(NewExecError("Could not write to StdErr log", p.id, fmt.Sprintf("Config error:%s", err.Error()), http.StatusFailedDependency))
 			}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+const SessionCookieName = "goWebAppSession"
+const authRealm = "goWebApp"
+
+/*
+Used when config SessionKey is undefined.
+
+Created once so that a config reload does not end all of the current sessions.
+*/
+var fallbackSessionKey = newRandomKey()
+
+func newRandomKey() []byte {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to create a random session key: %s", err.Error()))
+	}
+	return b
+}
+
+/*
+Issues and verifies signed session tokens.
+
+Token format: base64(user|expiryUnixSeconds) + "." + base64(HMAC-SHA256)
+*/
+type SessionManager struct {
+	key      []byte
+	duration time.Duration
+}
+
+func NewSessionManager(key string, duration time.Duration) *SessionManager {
+	if key == "" {
+		return &SessionManager{key: fallbackSessionKey, duration: duration}
+	}
+	return &SessionManager{key: []byte(key), duration: duration}
+}
+
+func (s *SessionManager) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *SessionManager) NewToken(user string, now time.Time) (string, time.Time) {
+	expires := now.Add(s.duration)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s|%d", user, expires.Unix())))
+	return payload + "." + s.sign(payload), expires
+}
+
+/*
+Verify returns the user from a token created by NewToken.
+
+Returns an error if the token is malformed, the signature is invalid or the token has expired.
+*/
+func (s *SessionManager) Verify(token string, now time.Time) (string, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", fmt.Errorf("session token is malformed")
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.sign(payload))) {
+		return "", fmt.Errorf("session token signature is invalid")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", fmt.Errorf("session token payload is invalid")
+	}
+	user, exp, ok := strings.Cut(string(data), "|")
+	if !ok {
+		return "", fmt.Errorf("session token payload is malformed")
+	}
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("session token expiry is invalid")
+	}
+	if now.Unix() > expUnix {
+		return "", fmt.Errorf("session token has expired")
+	}
+	return user, nil
+}
+
+/*
+Password hashing is slow by design. Basic auth sends the password with every request so
+successful checks are remembered. The stored hash is part of the key so a changed password is re-checked.
+*/
+type verifiedCredentials struct {
+	mu   sync.Mutex
+	list map[string]bool
+}
+
+func newVerifiedCredentials() *verifiedCredentials {
+	return &verifiedCredentials{list: map[string]bool{}}
+}
+
+func (v *verifiedCredentials) check(configData *config.ConfigData, user, password string) bool {
+	ud := configData.GetUserData(user)
+	if ud == nil || !ud.HasPassword() {
+		return false
+	}
+	sum := sha256.Sum256([]byte(user + "\x00" + password + "\x00" + ud.PasswordHash))
+	key := string(sum[:])
+	v.mu.Lock()
+	ok := v.list[key]
+	v.mu.Unlock()
+	if ok {
+		return true
+	}
+	if !configData.AuthenticateUser(user, password) {
+		return false
+	}
+	v.mu.Lock()
+	v.list[key] = true
+	v.mu.Unlock()
+	return true
+}
+
+/*
+Derive the authenticated user from the request.
+
+Basic auth is checked first then the session cookie.
+Returns an empty string if the request has no valid credentials.
+An invalid or expired session cookie is removed and treated as no credentials.
+Panics with 401 if Basic auth credentials are present but invalid.
+*/
+func (h *ServerHandler) authenticate(w http.ResponseWriter, r *http.Request) string {
+	user, password, ok := r.BasicAuth()
+	if ok {
+		if h.credentials.check(h.config, user, password) {
+			return user
+		}
+		panic(config.NewServerError("Authentication failed", http.StatusUnauthorized, fmt.Sprintf("Basic auth failed for User=%s", user)))
+	}
+	cookie, err := r.Cookie(SessionCookieName)
+	if err == nil && cookie.Value != "" {
+		user, err := h.sessions.Verify(cookie.Value, time.Now())
+		if err == nil && h.config.GetUserData(user) != nil {
+			return user
+		}
+		h.clearSessionCookie(w)
+		vf := h.logger.VerboseFunction()
+		if vf != nil {
+			vf(fmt.Sprintf("Session cookie rejected: %v", err))
+		}
+	}
+	return ""
+}
+
+/*
+Login with Basic auth or a JSON body {"user":"..", "password":".."}.
+
+A signed session cookie is returned so the password is not required for each request.
+*/
+func (h *ServerHandler) login(w http.ResponseWriter, r *http.Request) map[string]interface{} {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 4096))
+		if err != nil {
+			panic(config.NewServerError("Failed to read login data", http.StatusBadRequest, err.Error()))
+		}
+		creds := struct {
+			User     string `json:"user"`
+			Password string `json:"password"`
+		}{}
+		err = json.Unmarshal(body, &creds)
+		if err != nil {
+			panic(config.NewServerError("Login data is not understood", http.StatusBadRequest, err.Error()))
+		}
+		user = creds.User
+		password = creds.Password
+	}
+	if !h.credentials.check(h.config, user, password) {
+		panic(config.NewServerError("Authentication failed", http.StatusUnauthorized, fmt.Sprintf("Login failed for User=%s", user)))
+	}
+	token, expires := h.sessions.NewToken(user, time.Now())
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return map[string]interface{}{"user": user, "expires": expires.Format(time.RFC3339)}
+}
+
+func (h *ServerHandler) clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
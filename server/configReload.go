@@ -0,0 +1,187 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+/*
+The config and everything derived from it. Published as a single value so that
+each request sees one consistent snapshot.
+*/
+type configState struct {
+	config   *config.ConfigData
+	sessions *SessionManager
+}
+
+func newConfigState(cfg *config.ConfigData) *configState {
+	return &configState{config: cfg, sessions: NewSessionManager(cfg.GetSessionKey(), cfg.GetSessionDuration())}
+}
+
+/*
+Reported in /server/status
+*/
+type ConfigReloadStatus struct {
+	IntervalSeconds int
+	LastCheck       string `json:",omitempty"`
+	LastReload      string `json:",omitempty"`
+	LastReason      string `json:",omitempty"`
+	LastError       string `json:",omitempty"`
+	Reloads         int
+	Failures        int
+}
+
+type configPublisher struct {
+	current  atomic.Pointer[configState]
+	mu       sync.Mutex // Serialises reloads. Protects status and modTimes
+	status   ConfigReloadStatus
+	modTimes map[string]time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newConfigPublisher(cfg *config.ConfigData) *configPublisher {
+	p := &configPublisher{stop: make(chan struct{})}
+	p.current.Store(newConfigState(cfg))
+	p.modTimes = fileModTimes(cfg.GetWatchedFiles())
+	p.status.IntervalSeconds = int(cfg.GetReloadInterval().Seconds())
+	return p
+}
+
+func fileModTimes(files []string) map[string]time.Time {
+	m := map[string]time.Time{}
+	for _, f := range files {
+		st, err := os.Stat(f)
+		if err == nil {
+			m[f] = st.ModTime()
+		} else {
+			m[f] = time.Time{}
+		}
+	}
+	return m
+}
+
+func (p *configPublisher) load() *configState {
+	return p.current.Load()
+}
+
+func (p *configPublisher) reloadStatus() ConfigReloadStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+func (p *configPublisher) statusJson() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b, err := json.Marshal(p.status)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+/*
+Returns true if any watched file has been modified, created or removed since the last reload.
+*/
+func (p *configPublisher) filesChanged() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status.LastCheck = time.Now().Format(time.ANSIC)
+	now := fileModTimes(p.current.Load().config.GetWatchedFiles())
+	if len(now) != len(p.modTimes) {
+		return true
+	}
+	for f, t := range now {
+		prev, ok := p.modTimes[f]
+		if !ok || !prev.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Re-load the config with NewConfigData. The new config is published only if there are no errors.
+
+The watched file times are recorded even if the reload fails so that a broken file is not
+re-loaded on every check. The next change to the files will try again.
+*/
+func (p *configPublisher) reload(reason string) (*config.ConfigData, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	current := p.current.Load().config
+	p.modTimes = fileModTimes(current.GetWatchedFiles())
+	p.status.LastReason = reason
+	cfg, err := reloadConfigData(current)
+	if err != nil {
+		p.status.Failures++
+		p.status.LastError = err.Error()
+		return nil, err
+	}
+	p.current.Store(newConfigState(cfg))
+	p.modTimes = fileModTimes(cfg.GetWatchedFiles())
+	p.status.Reloads++
+	p.status.LastError = ""
+	p.status.LastReload = time.Now().Format(time.ANSIC)
+	p.status.IntervalSeconds = int(cfg.GetReloadInterval().Seconds())
+	return cfg, nil
+}
+
+/*
+NewConfigData can panic (for example if the UserDataPath file is invalid). This is
+returned as an error so the current config is retained.
+*/
+func reloadConfigData(current *config.ConfigData) (cfg *config.ConfigData, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			cfg = nil
+			err = fmt.Errorf("config Reload Failed: %v", rec)
+		}
+	}()
+	configErrors := config.NewConfigErrorData()
+	cfg = config.NewConfigData(current.ConfigName, current.ModuleName, current.Debugging, false, current.IsVerbose, configErrors)
+	if configErrors.ErrorCount() > 0 || cfg == nil {
+		return nil, fmt.Errorf("config Reload Failed with %d errors. %s", configErrors.ErrorCount(), configErrors.String())
+	}
+	return cfg, nil
+}
+
+func (p *configPublisher) close() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+}
+
+/*
+Check the watched files every ReloadConfigSeconds and re-load the config if they change.
+
+The interval is read from the current config each time so a reload can change it.
+Returns when the interval is 0 or the publisher is closed.
+*/
+func (h *ServerHandler) watchConfig() {
+	for {
+		interval := h.publisher.load().config.GetReloadInterval()
+		if interval <= 0 {
+			h.Log("Config: Automatic reload is OFF")
+			return
+		}
+		select {
+		case <-h.publisher.stop:
+			return
+		case <-time.After(interval):
+			if h.publisher.filesChanged() {
+				err := h.ReloadConfig("on file change")
+				if err != nil {
+					h.Log(fmt.Sprintf("Config: Failed to re-load. Current config retained. %s", err.Error()))
+				}
+			}
+		}
+	}
+}
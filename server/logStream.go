@@ -0,0 +1,213 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+// How often the log file is checked for new lines
+var logStreamPoll = 500 * time.Millisecond
+
+// The most that is read from the log file in one go
+const logStreamChunk = 256 * 1024
+
+// A comment is sent if nothing else is sent for this long. Stops proxies closing the connection.
+var logStreamKeepAlive = 15 * time.Second
+
+/*
+A position in a log file. Sent as the SSE event id so a client can resume with Last-Event-ID.
+
+The form is '<fileName>:<offset>'. The offset is the byte after the last line sent.
+*/
+type logCursor struct {
+	name   string
+	offset int64
+}
+
+func (c logCursor) String() string {
+	return fmt.Sprintf("%s:%d", c.name, c.offset)
+}
+
+func parseLogCursor(id string) (logCursor, bool) {
+	i := strings.LastIndex(id, ":")
+	if i < 1 {
+		return logCursor{}, false
+	}
+	ofs, err := strconv.ParseInt(id[i+1:], 10, 64)
+	if err != nil || ofs < 0 {
+		return logCursor{}, false
+	}
+	name := id[:i]
+	if name != filepath.Base(name) {
+		return logCursor{}, false // Must be a file in the log directory
+	}
+	return logCursor{name: name, offset: ofs}, true
+}
+
+/*
+Serve /server/log/stream.
+
+Without Last-Event-ID the stream starts at the end of the current log file. With a valid
+Last-Event-ID it starts after the last line the client received, even if that was in an
+earlier (rolled over) log file.
+
+Query 'filter' sends only lines that contain the value. Ids still advance so a resume does not
+re-send filtered lines.
+*/
+func (h *ServerHandler) streamLog(w http.ResponseWriter, r *http.Request) {
+	if !h.logger.IsOpen() {
+		panic(config.NewServerError("Log is not open", http.StatusNotFound, "Log stream: The log is not open. All logging is to the console"))
+	}
+	dir := h.config.GetLogData().Path
+	cursor, ok := parseLogCursor(r.Header.Get("Last-Event-ID"))
+	if !ok {
+		cursor = logCursor{name: h.logger.LogFileName()}
+		st, err := os.Stat(filepath.Join(dir, cursor.name))
+		if err == nil {
+			cursor.offset = st.Size()
+		}
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.Header().Set("Server", h.config.GetServerName())
+	w.WriteHeader(http.StatusOK)
+	tailLog(r.Context(), h.publisher.stop, w, dir, h.logger.LogFileName, cursor, r.URL.Query().Get("filter"))
+}
+
+/*
+Send the lines of the log file as SSE events until the client goes away or 'stop' is closed.
+
+'current' returns the name of the file the logger is writing. When it changes the rest of
+the cursor file is sent and then each later file is sent from the start until the current file is reached.
+*/
+func tailLog(ctx context.Context, stop <-chan struct{}, w http.ResponseWriter, dir string, current func() string, cursor logCursor, filter string) {
+	rc := http.NewResponseController(w)
+	fmt.Fprintf(w, "retry: %d\n\n", logStreamPoll.Milliseconds()*4)
+	rc.Flush()
+	lastSend := time.Now()
+	for {
+		name := current()
+		sent, more, err := sendLogLines(w, dir, &cursor, filter)
+		if err != nil {
+			return // The client has gone
+		}
+		if more {
+			rc.Flush()
+			continue
+		}
+		if cursor.name != name {
+			// All of the previous file has been sent. Follow the next file towards the current one.
+			cursor = logCursor{name: nextLogFile(dir, cursor.name, name)}
+			continue
+		}
+		if sent == 0 && time.Since(lastSend) >= logStreamKeepAlive {
+			_, err = io.WriteString(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+			sent = 1
+		}
+		if sent > 0 {
+			rc.Flush()
+			lastSend = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-time.After(logStreamPoll):
+		}
+	}
+}
+
+/*
+The log file after 'name' in name order. Log file names (FileNameMask) sort by the time they were opened
+so these are the files that rolled over while a client was away. Only files with the same extension as
+'current' are considered. Returns 'current' if there is no file between 'name' and 'current'.
+*/
+func nextLogFile(dir string, name string, current string) string {
+	if name >= current {
+		return current
+	}
+	next := current
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		n := e.Name()
+		if !e.IsDir() && n > name && n < next && filepath.Ext(n) == filepath.Ext(current) {
+			next = n
+		}
+	}
+	return next
+}
+
+/*
+Send complete lines from the cursor position towards the end of the file. A partial line at the
+end of the file is left for the next call. At most logStreamChunk bytes are read.
+
+If the file is shorter than the cursor (truncated or replaced) it is sent from the start.
+Returns the number of events written and true if there is more to read now.
+An error is only returned if the write fails.
+*/
+func sendLogLines(w io.Writer, dir string, cursor *logCursor, filter string) (int, bool, error) {
+	f, err := os.Open(filepath.Join(dir, cursor.name))
+	if err != nil {
+		return 0, false, nil
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return 0, false, nil
+	}
+	if st.Size() < cursor.offset {
+		cursor.offset = 0
+	}
+	remaining := st.Size() - cursor.offset
+	if remaining == 0 {
+		return 0, false, nil
+	}
+	more := remaining > logStreamChunk
+	data := make([]byte, min(remaining, logStreamChunk))
+	n, _ := f.ReadAt(data, cursor.offset)
+	data = data[:n]
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 && !more {
+		return 0, false, nil
+	}
+	block := data[:end+1]
+	if end < 0 {
+		block = data // A line longer than a chunk is split
+	}
+	sent := 0
+	var b bytes.Buffer
+	for _, line := range bytes.SplitAfter(block, []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		cursor.offset += int64(len(line))
+		if filter != "" && !bytes.Contains(line, []byte(filter)) {
+			continue
+		}
+		b.WriteString("id: ")
+		b.WriteString(cursor.String())
+		b.WriteString("\ndata: ")
+		b.Write(bytes.TrimRight(line, "\r\n"))
+		b.WriteString("\n\n")
+		sent++
+	}
+	if b.Len() > 0 {
+		_, err = w.Write(b.Bytes())
+	}
+	return sent, more, err
+}
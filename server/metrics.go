@@ -0,0 +1,358 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Route labels for requests that do not match a url matcher
+const routeHome = "/"
+const routeStatic = "static"
+const routeUnmatched = "unmatched"
+const routeDav = "/dav"
+
+// Request methods used as a label. Other methods are counted as "other" so clients cannot add labels without limit
+var metricsMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions,
+	"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
+// Upper bounds (seconds) of the request latency histogram buckets
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type routeMethodKey struct {
+	route  string
+	method string
+}
+
+type requestCountKey struct {
+	route  string
+	method string
+	class  string // 2xx, 4xx etc
+}
+
+type execCountKey struct {
+	id string
+	rc string
+}
+
+type latencyHistogram struct {
+	counts []uint64 // One per latencyBuckets. Not cumulative
+	count  uint64
+	sum    float64
+}
+
+/*
+Counters for the /metrics endpoint.
+
+Shared by every request (and every config snapshot). Values are held from server start.
+*/
+type serverMetrics struct {
+	mu          sync.Mutex
+	requests    map[requestCountKey]uint64
+	latency     map[routeMethodKey]*latencyHistogram
+	execs       map[execCountKey]uint64
+	bytesServed map[string]uint64 // By route. Written by serveFile
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		requests:    map[requestCountKey]uint64{},
+		latency:     map[routeMethodKey]*latencyHistogram{},
+		execs:       map[execCountKey]uint64{},
+		bytesServed: map[string]uint64{},
+	}
+}
+
+func (m *serverMetrics) observeRequest(route, method string, status int, d time.Duration) {
+	method = methodLabel(method)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestCountKey{route: route, method: method, class: statusClass(status)}]++
+	k := routeMethodKey{route: route, method: method}
+	h, ok := m.latency[k]
+	if !ok {
+		h = &latencyHistogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latency[k] = h
+	}
+	sec := d.Seconds()
+	for i, b := range latencyBuckets {
+		if sec <= b {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += sec
+}
+
+func (m *serverMetrics) observeExec(execId string, rc int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.execs[execCountKey{id: execId, rc: strconv.Itoa(rc)}]++
+}
+
+func (m *serverMetrics) observeFileBytes(route string, n int64) {
+	if n <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytesServed[route] += uint64(n)
+}
+
+func methodLabel(method string) string {
+	if !slices.Contains(metricsMethods, method) {
+		return "other"
+	}
+	return method
+}
+
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "other"
+	}
+	return fmt.Sprintf("%dxx", status/100)
+}
+
+/*
+Values sampled when /metrics is requested.
+*/
+type metricsGauges struct {
+	upSince           time.Time
+	logQueueDepth     int
+	longRunningConfig int
+	longRunningActive int
+	configReloads     int
+	configFailures    int
+}
+
+/*
+Write all metrics in the Prometheus text exposition format.
+
+Series are sorted so the output is stable between scrapes.
+*/
+func (m *serverMetrics) writeTo(w io.Writer, g *metricsGauges) {
+	var b bytes.Buffer
+	m.mu.Lock()
+
+	writeMetricHeader(&b, "gowebapp_http_requests_total", "counter", "Requests by route template, method and status class.")
+	reqKeys := make([]requestCountKey, 0, len(m.requests))
+	for k := range m.requests {
+		reqKeys = append(reqKeys, k)
+	}
+	sort.Slice(reqKeys, func(i, j int) bool {
+		return reqKeys[i].route+" "+reqKeys[i].method+" "+reqKeys[i].class < reqKeys[j].route+" "+reqKeys[j].method+" "+reqKeys[j].class
+	})
+	for _, k := range reqKeys {
+		writeMetric(&b, "gowebapp_http_requests_total", labels("route", k.route, "method", k.method, "code", k.class), float64(m.requests[k]))
+	}
+
+	writeMetricHeader(&b, "gowebapp_http_request_duration_seconds", "histogram", "Request latency by route template and method.")
+	latKeys := make([]routeMethodKey, 0, len(m.latency))
+	for k := range m.latency {
+		latKeys = append(latKeys, k)
+	}
+	sort.Slice(latKeys, func(i, j int) bool {
+		return latKeys[i].route+" "+latKeys[i].method < latKeys[j].route+" "+latKeys[j].method
+	})
+	for _, k := range latKeys {
+		h := m.latency[k]
+		var cumulative uint64
+		for i, le := range latencyBuckets {
+			cumulative += h.counts[i]
+			writeMetric(&b, "gowebapp_http_request_duration_seconds_bucket", labels("route", k.route, "method", k.method, "le", formatFloat(le)), float64(cumulative))
+		}
+		writeMetric(&b, "gowebapp_http_request_duration_seconds_bucket", labels("route", k.route, "method", k.method, "le", "+Inf"), float64(h.count))
+		writeMetric(&b, "gowebapp_http_request_duration_seconds_sum", labels("route", k.route, "method", k.method), h.sum)
+		writeMetric(&b, "gowebapp_http_request_duration_seconds_count", labels("route", k.route, "method", k.method), float64(h.count))
+	}
+
+	writeMetricHeader(&b, "gowebapp_file_served_bytes_total", "counter", "Bytes of file content served by route template.")
+	routes := make([]string, 0, len(m.bytesServed))
+	for r := range m.bytesServed {
+		routes = append(routes, r)
+	}
+	sort.Strings(routes)
+	for _, r := range routes {
+		writeMetric(&b, "gowebapp_file_served_bytes_total", labels("route", r), float64(m.bytesServed[r]))
+	}
+
+	writeMetricHeader(&b, "gowebapp_exec_invocations_total", "counter", "Completed Exec commands by exec id and exit code.")
+	execKeys := make([]execCountKey, 0, len(m.execs))
+	for k := range m.execs {
+		execKeys = append(execKeys, k)
+	}
+	sort.Slice(execKeys, func(i, j int) bool {
+		return execKeys[i].id+" "+execKeys[i].rc < execKeys[j].id+" "+execKeys[j].rc
+	})
+	for _, k := range execKeys {
+		writeMetric(&b, "gowebapp_exec_invocations_total", labels("exec", k.id, "rc", k.rc), float64(m.execs[k]))
+	}
+	m.mu.Unlock()
+
+	writeGauge(&b, "gowebapp_logger_queue_depth", "Log messages waiting to be written.", float64(g.logQueueDepth))
+	writeGauge(&b, "gowebapp_long_running_processes", "Detached Exec commands known to the long running process manager.", float64(g.longRunningConfig))
+	writeGauge(&b, "gowebapp_long_running_processes_running", "Detached Exec commands with a running process.", float64(g.longRunningActive))
+	writeMetricHeader(&b, "gowebapp_config_reloads_total", "counter", "Successful config reloads.")
+	writeMetric(&b, "gowebapp_config_reloads_total", "", float64(g.configReloads))
+	writeMetricHeader(&b, "gowebapp_config_reload_failures_total", "counter", "Failed config reloads.")
+	writeMetric(&b, "gowebapp_config_reload_failures_total", "", float64(g.configFailures))
+	writeGauge(&b, "gowebapp_uptime_seconds", "Seconds since the server started.", time.Since(g.upSince).Seconds())
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	writeGauge(&b, "go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	writeGauge(&b, "go_memstats_heap_alloc_bytes", "Number of heap bytes allocated and still in use.", float64(ms.HeapAlloc))
+	writeGauge(&b, "go_memstats_sys_bytes", "Number of bytes obtained from system.", float64(ms.Sys))
+	w.Write(b.Bytes())
+}
+
+func writeMetricHeader(b *bytes.Buffer, name, kind, help string) {
+	b.WriteString("# HELP ")
+	b.WriteString(name)
+	b.WriteRune(' ')
+	b.WriteString(help)
+	b.WriteString("\n# TYPE ")
+	b.WriteString(name)
+	b.WriteRune(' ')
+	b.WriteString(kind)
+	b.WriteRune('\n')
+}
+
+func writeGauge(b *bytes.Buffer, name, help string, value float64) {
+	writeMetricHeader(b, name, "gauge", help)
+	writeMetric(b, name, "", value)
+}
+
+func writeMetric(b *bytes.Buffer, name, labels string, value float64) {
+	b.WriteString(name)
+	b.WriteString(labels)
+	b.WriteRune(' ')
+	b.WriteString(formatFloat(value))
+	b.WriteRune('\n')
+}
+
+/*
+Returns {n1="v1",n2="v2"} from name, value pairs. Values are escaped.
+*/
+func labels(nameValue ...string) string {
+	var b strings.Builder
+	b.WriteRune('{')
+	for i := 0; i+1 < len(nameValue); i += 2 {
+		if i > 0 {
+			b.WriteRune(',')
+		}
+		b.WriteString(nameValue[i])
+		b.WriteString("=\"")
+		b.WriteString(labelEscaper.Replace(nameValue[i+1]))
+		b.WriteRune('"')
+	}
+	b.WriteRune('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+/*
+Wraps the ResponseWriter to record the status code and the number of bytes written.
+*/
+type metricsWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func newMetricsWriter(w http.ResponseWriter) *metricsWriter {
+	return &metricsWriter{ResponseWriter: w}
+}
+
+func (mw *metricsWriter) WriteHeader(status int) {
+	if mw.status == 0 {
+		mw.status = status
+	}
+	mw.ResponseWriter.WriteHeader(status)
+}
+
+func (mw *metricsWriter) Write(b []byte) (int, error) {
+	if mw.status == 0 {
+		mw.status = http.StatusOK
+	}
+	n, err := mw.ResponseWriter.Write(b)
+	mw.written += int64(n)
+	return n, err
+}
+
+/*
+Allows http.ServeFile to use the underlying writer's ReadFrom (sendfile) when it has one.
+*/
+func (mw *metricsWriter) ReadFrom(r io.Reader) (int64, error) {
+	if mw.status == 0 {
+		mw.status = http.StatusOK
+	}
+	n, err := io.Copy(mw.ResponseWriter, r)
+	mw.written += n
+	return n, err
+}
+
+func (mw *metricsWriter) Flush() {
+	if f, ok := mw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Used by http.ResponseController
+func (mw *metricsWriter) Unwrap() http.ResponseWriter {
+	return mw.ResponseWriter
+}
+
+func (mw *metricsWriter) Status() int {
+	if mw.status == 0 {
+		return http.StatusOK
+	}
+	return mw.status
+}
+
+/*
+Returns the number of bytes written to w so far. 0 if w is not a metricsWriter.
+*/
+func bytesWritten(w http.ResponseWriter) int64 {
+	if mw, ok := w.(*metricsWriter); ok {
+		return mw.written
+	}
+	return 0
+}
+
+func (h *ServerHandler) writeMetrics(w http.ResponseWriter) {
+	if h.longRunning.IsEnabled() {
+		h.longRunning.Update()
+	}
+	st := h.publisher.reloadStatus()
+	g := &metricsGauges{
+		upSince:           h.upSince,
+		logQueueDepth:     h.logger.QueueDepth(),
+		longRunningConfig: h.longRunning.Len(),
+		longRunningActive: h.longRunning.Running(),
+		configReloads:     st.Reloads,
+		configFailures:    st.Failures,
+	}
+	w.Header().Set("Content-Type", metricsContentType)
+	w.Header().Set("Server", h.config.GetServerName())
+	w.WriteHeader(http.StatusOK)
+	h.metrics.writeTo(w, g)
+}
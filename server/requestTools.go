@@ -2,23 +2,48 @@ package server
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
+
+	"github.com/stuartdd/goWebApp/config"
 )
 
 type RootUrlList struct {
-	ids map[string]bool
+	ids      map[string]bool
+	root     *routeNode
+	matchers []*urlRequestMatcher
+}
+
+/*
+A node in the route trie. Each node is a segment of a template url.
+
+Literal segments are held in 'children'. A '*' segment is held in 'wildcard'.
+If a template url ends at this node then 'methods' holds the matcher for each request type.
+*/
+type routeNode struct {
+	children map[string]*routeNode
+	wildcard *routeNode
+	methods  map[string]*urlRequestMatcher
+}
+
+func newRouteNode() *routeNode {
+	return &routeNode{children: map[string]*routeNode{}, wildcard: nil, methods: nil}
 }
 
 func NewRootUrlList() *RootUrlList {
-	return &RootUrlList{ids: map[string]bool{}}
+	return &RootUrlList{ids: map[string]bool{}, root: newRouteNode(), matchers: []*urlRequestMatcher{}}
 }
 
-func (rl *RootUrlList) AddUrlRequestMatcher(templateUrl string, reqType string, shouldLog bool) *urlRequestMatcher {
-	return rl.add(newUrlRequestMatcher(templateUrl, reqType, shouldLog))
+/*
+Register a matcher. The caller must have the 'role' to use it. See config.CheckRole.
+*/
+func (rl *RootUrlList) AddUrlRequestMatcher(templateUrl string, reqType string, shouldLog bool, role config.Role) *urlRequestMatcher {
+	return rl.add(newUrlRequestMatcher(templateUrl, reqType, shouldLog, role))
 }
 
 func (p *RootUrlList) HasRoot(name string) bool {
@@ -28,16 +53,125 @@ func (p *RootUrlList) HasRoot(name string) bool {
 
 func (p *RootUrlList) String() string {
 	var buffer bytes.Buffer
-	for n, _ := range p.ids {
+	names := make([]string, 0, len(p.ids))
+	for n := range p.ids {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
 		buffer.WriteString(n)
 		buffer.WriteString(",")
 	}
 	return buffer.String()
 }
 
+/*
+RouteTable returns every registered matcher, one per line, sorted by url then request type.
+
+Used for diagnostics. Logged on server start when verbose.
+*/
+func (p *RootUrlList) RouteTable() string {
+	list := make([]*urlRequestMatcher, len(p.matchers))
+	copy(list, p.matchers)
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Template() == list[j].Template() {
+			return list[i].ReqType < list[j].ReqType
+		}
+		return list[i].Template() < list[j].Template()
+	})
+	var buffer bytes.Buffer
+	for _, m := range list {
+		buffer.WriteString(fmt.Sprintf("Route: %-7s %-6s %s", m.ReqType, m.role, m.Template()))
+		if m.shouldLog {
+			buffer.WriteString(" (logged)")
+		}
+		buffer.WriteRune('\n')
+	}
+	return buffer.String()
+}
+
+/*
+Route finds the matcher for the request in a single pass of the route trie.
+
+Literal segments take priority over '*' segments. If a literal segment leads to a dead end
+the '*' segment at the same position is tried instead.
+
+Returns the matcher and its parameters when found.
+If the url matches but the request type does not, the matcher is nil and the allowed request types are returned.
+If the url does not match at all the matcher is nil and the allowed list is empty.
+*/
+func (p *RootUrlList) Route(requestParts []string, reqType string, rqi *RequestInfo) (*urlRequestMatcher, map[string]string, []string) {
+	if len(requestParts) == 0 {
+		return nil, nil, nil
+	}
+	reqType = strings.ToUpper(reqType)
+	allowed := map[string]bool{}
+	m := p.root.find(requestParts, 0, reqType, allowed)
+	if m == nil {
+		list := make([]string, 0, len(allowed))
+		for n := range allowed {
+			list = append(list, n)
+		}
+		sort.Strings(list)
+		return nil, nil, list
+	}
+	if rqi != nil {
+		rqi.Log(m.shouldLog)
+	}
+	return m, m.params(requestParts), nil
+}
+
+func (n *routeNode) find(requestParts []string, pos int, reqType string, allowed map[string]bool) *urlRequestMatcher {
+	if pos == len(requestParts) {
+		if n.methods == nil {
+			return nil
+		}
+		m, ok := n.methods[reqType]
+		if ok {
+			return m
+		}
+		for rt := range n.methods {
+			allowed[rt] = true
+		}
+		return nil
+	}
+	child, ok := n.children[requestParts[pos]]
+	if ok {
+		m := child.find(requestParts, pos+1, reqType, allowed)
+		if m != nil {
+			return m
+		}
+	}
+	if n.wildcard != nil {
+		return n.wildcard.find(requestParts, pos+1, reqType, allowed)
+	}
+	return nil
+}
+
 func (p *RootUrlList) add(info *urlRequestMatcher) *urlRequestMatcher {
 	if len(info.Parts) > 0 {
 		p.ids[info.Parts[0]] = true
+		node := p.root
+		for _, part := range info.Parts {
+			if part == "*" {
+				if node.wildcard == nil {
+					node.wildcard = newRouteNode()
+				}
+				node = node.wildcard
+			} else {
+				child, ok := node.children[part]
+				if !ok {
+					child = newRouteNode()
+					node.children[part] = child
+				}
+				node = child
+			}
+		}
+		if node.methods == nil {
+			node.methods = map[string]*urlRequestMatcher{}
+		}
+		node.methods[info.ReqType] = info
+		p.matchers = append(p.matchers, info)
 	}
 	return info
 }
@@ -81,10 +215,11 @@ type urlRequestMatcher struct {
 	Parts     []string
 	ReqType   string
 	shouldLog bool
+	role      config.Role
 	Len       int
 }
 
-func newUrlRequestMatcher(templateUrl string, reqType string, shouldLog bool) *urlRequestMatcher {
+func newUrlRequestMatcher(templateUrl string, reqType string, shouldLog bool, role config.Role) *urlRequestMatcher {
 	s := strings.Split(strings.TrimSpace(templateUrl), "/")
 	if s[0] == "" {
 		s = s[1:]
@@ -93,21 +228,37 @@ func newUrlRequestMatcher(templateUrl string, reqType string, shouldLog bool) *u
 		Parts:     s,
 		ReqType:   strings.ToUpper(reqType),
 		shouldLog: shouldLog,
+		role:      role,
 		Len:       len(s),
 	}
 }
 
+func (p *urlRequestMatcher) Template() string {
+	return "/" + strings.Join(p.Parts, "/")
+}
+
+func (p *urlRequestMatcher) Role() config.Role {
+	return p.role
+}
+
 func (p *urlRequestMatcher) String() string {
-	var buffer bytes.Buffer
-	len := 0
-	buffer.WriteRune('/')
-	for _, v := range p.Parts {
-		buffer.WriteString(v)
-		len = buffer.Len()
-		buffer.WriteRune('/')
+	return fmt.Sprintf("Req:  %s:%s", p.ReqType, p.Template())
+}
+
+/*
+Derive the parameters from the request url parts.
+
+A '*' in the template is named by the template part before it. So '/files/user/*' gives the parameter 'user'.
+A '*' that follows a '*' is not named.
+*/
+func (p *urlRequestMatcher) params(requestParts []string) map[string]string {
+	params := map[string]string{}
+	for i := 1; i < p.Len && i < len(requestParts); i++ {
+		if p.Parts[i] == "*" && p.Parts[i-1] != "*" {
+			params[p.Parts[i-1]] = requestParts[i]
+		}
 	}
-	buffer.Truncate(len)
-	return fmt.Sprintf("Req:  %s:%s", p.ReqType, buffer.String())
+	return params
 }
 
 func (p *urlRequestMatcher) Match(requestParts []string, reqType string, rqi *RequestInfo) (map[string]string, bool, bool) {
@@ -120,26 +271,30 @@ func (p *urlRequestMatcher) Match(requestParts []string, reqType string, rqi *Re
 	if p.ReqType != strings.ToUpper(reqType) {
 		return nil, false, p.shouldLog
 	}
-	params := map[string]string{}
 	for i := 1; i < p.Len; i++ {
-		if p.Parts[i] != "*" {
-			if p.Parts[i] != requestParts[i] {
-				return params, false, p.shouldLog
-			}
-		} else {
-			if p.Parts[i-1] != "*" {
-				params[p.Parts[i-1]] = requestParts[i]
-			}
+		if p.Parts[i] != "*" && p.Parts[i] != requestParts[i] {
+			return p.params(requestParts[:i]), false, p.shouldLog
 		}
 	}
 	if rqi != nil {
 		rqi.Log(p.shouldLog)
 	}
-	return params, true, p.shouldLog
+	return p.params(requestParts), true, p.shouldLog
 }
 
-func SendToHost(port string, path string) (*[]byte, int, error) {
-	url := fmt.Sprintf("http://localHost%s/%s", port, path)
+/*
+Send a GET request to this server on localhost.
+
+If useTLS is true the request uses https. The certificate is not verified as the
+server is on the same machine and the certificate may be self signed.
+If user is not empty the request uses Basic authentication.
+*/
+func SendToHost(port string, path string, useTLS bool, user string, password string) (*[]byte, int, error) {
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	url := fmt.Sprintf("%s://localHost%s/%s", scheme, port, strings.TrimPrefix(path, "/"))
 	fmt.Printf("Client-Request:%s\n", url)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -147,7 +302,13 @@ func SendToHost(port string, path string) (*[]byte, int, error) {
 		fmt.Printf("Client-Error:%s\n", em.Error())
 		return nil, -1, em
 	}
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
 	client := http.Client{Timeout: 5 * time.Second}
+	if useTLS {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
 	// send the request
 	res, err := client.Do(req)
 	if err != nil {
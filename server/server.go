@@ -4,11 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/stuartdd/goWebApp/config"
@@ -26,6 +29,7 @@ type ActionId int
 const (
 	Exit ActionId = iota
 	Ignore
+	Reload
 )
 
 type LoggableError interface {
@@ -55,67 +59,167 @@ func NewActionEvent(id ActionId, rc string, fallback int, m string) *ActionEvent
 
 var rootUrlList = NewRootUrlList()
 
-var getPingMatch = rootUrlList.AddUrlRequestMatcher("/ping", "GET", shouldLogNo)
-var getIsUpMatch = rootUrlList.AddUrlRequestMatcher("/isup", "GET", shouldLogNo)
-
-var getServerStatusMatch = rootUrlList.AddUrlRequestMatcher("/server/status", "GET", shouldLogYes)
-var getReloadConfigMatch = rootUrlList.AddUrlRequestMatcher("/server/config", "GET", shouldLogYes)
-var getServerTimeMatch = rootUrlList.AddUrlRequestMatcher("/server/time", "GET", shouldLogNo)
-var getServerUsersMatch = rootUrlList.AddUrlRequestMatcher("/server/users", "GET", shouldLogYes)
-var getServerRestartMatch = rootUrlList.AddUrlRequestMatcher("/server/restart", "GET", shouldLogYes)
-var getServerExitMatch = rootUrlList.AddUrlRequestMatcher(ServerExitUrl, "GET", shouldLogYes)
-var getServerLogMatch = rootUrlList.AddUrlRequestMatcher("/server/log", "GET", shouldLogNo)
-var delServerLogMatch = rootUrlList.AddUrlRequestMatcher("/server/log/*", "DELETE", shouldLogYes)
+var getPingMatch = rootUrlList.AddUrlRequestMatcher("/ping", "GET", shouldLogNo, config.RoleGuest)
+var getIsUpMatch = rootUrlList.AddUrlRequestMatcher("/isup", "GET", shouldLogNo, config.RoleGuest)
+
+var getServerStatusMatch = rootUrlList.AddUrlRequestMatcher("/server/status", "GET", shouldLogYes, config.RoleGuest)
+var getReloadConfigMatch = rootUrlList.AddUrlRequestMatcher("/server/config", "GET", shouldLogYes, config.RoleAdmin)
+var getServerTimeMatch = rootUrlList.AddUrlRequestMatcher("/server/time", "GET", shouldLogNo, config.RoleGuest)
+var getServerUsersMatch = rootUrlList.AddUrlRequestMatcher("/server/users", "GET", shouldLogYes, config.RoleGuest)
+var getServerRestartMatch = rootUrlList.AddUrlRequestMatcher("/server/restart", "GET", shouldLogYes, config.RoleAdmin)
+var getServerExitMatch = rootUrlList.AddUrlRequestMatcher(ServerExitUrl, "GET", shouldLogYes, config.RoleAdmin)
+var getServerLogMatch = rootUrlList.AddUrlRequestMatcher("/server/log", "GET", shouldLogNo, config.RoleAdmin)
+var delServerLogMatch = rootUrlList.AddUrlRequestMatcher("/server/log/*", "DELETE", shouldLogYes, config.RoleAdmin)
+var getServerLogStreamMatch = rootUrlList.AddUrlRequestMatcher("/server/log/stream", "GET", shouldLogNo, config.RoleAdmin)
+var getServerSharesMatch = rootUrlList.AddUrlRequestMatcher("/server/shares", "GET", shouldLogYes, config.RoleAdmin)
+var delServerSharesMatch = rootUrlList.AddUrlRequestMatcher("/server/shares/*", "DELETE", shouldLogYes, config.RoleAdmin)
 
 // Exec a script via an ID in config:"Exec" section.
 // Script must be in  config:"ExecPath":
-// User will be "admin"
-var getExecMatch = rootUrlList.AddUrlRequestMatcher("/exec/*", "GET", shouldLogYes)
-var getPropUserNameValueMatch = rootUrlList.AddUrlRequestMatcher("/prop/user/*/name/*/value/*", "GET", shouldLogYes)
-var getPropUserNameMatch = rootUrlList.AddUrlRequestMatcher("/prop/user/*/name/*", "GET", shouldLogYes)
-var getPropUserMatch = rootUrlList.AddUrlRequestMatcher("/prop/user/*", "GET", shouldLogYes)
+// User will be "admin". Non admin users must be listed in the Exec "Users"
+var getExecMatch = rootUrlList.AddUrlRequestMatcher("/exec/*", "GET", shouldLogYes, config.RoleUser)
+var getPropUserNameValueMatch = rootUrlList.AddUrlRequestMatcher("/prop/user/*/name/*/value/*", "GET", shouldLogYes, config.RoleUser)
+var getPropUserNameMatch = rootUrlList.AddUrlRequestMatcher("/prop/user/*/name/*", "GET", shouldLogYes, config.RoleGuest)
+var getPropUserMatch = rootUrlList.AddUrlRequestMatcher("/prop/user/*", "GET", shouldLogYes, config.RoleGuest)
 
 // Get File asAdmin user. Location (loc) must be defined in admin user.
 // var getFileLocNameMatch = rootUrlList.AddUrlRequestMatcher("/files/loc/*/name/*", "GET", shouldLogYes)
-var getFileUserLocPathMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*/path/*", "GET", shouldLogYes)
-var getFileUserLocMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*", "GET", shouldLogYes)
-var getFileUserLocTreeMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*/tree", "GET", shouldLogYes)
+var getFileUserLocPathMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*/path/*", "GET", shouldLogYes, config.RoleGuest)
+var getFileUserLocMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*", "GET", shouldLogYes, config.RoleGuest)
+var getFileUserLocTreeMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*/tree", "GET", shouldLogYes, config.RoleGuest)
 
 // Specific File GET matchers Sub for FastFile!
-var getFileUserLocNameMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*/name/*", "GET", shouldLogYes)
-var getFileUserLocPathNameMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*/path/*/name/*", "GET", shouldLogYes)
+var getFileUserLocNameMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*/name/*", "GET", shouldLogYes, config.RoleGuest)
+var getFileUserLocPathNameMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*/path/*/name/*", "GET", shouldLogYes, config.RoleGuest)
 
 // File NON GET matchers
-var delFileUserLocNameMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*/name/*", "DELETE", shouldLogYes)
-var postFileUserLocNameMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*/name/*", "POST", shouldLogYes)
-var postFileUserLocPathNameMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*/path/*/name/*", "POST", shouldLogYes)
-
-var getPathsUserLocMatch = rootUrlList.AddUrlRequestMatcher("/paths/user/*/loc/*", "GET", shouldLogYes)
-
-var getTestUserLocNameMatch = rootUrlList.AddUrlRequestMatcher("/test/user/*/loc/*/name/*", "GET", shouldLogNo)
+var delFileUserLocNameMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*/name/*", "DELETE", shouldLogYes, config.RoleUser)
+var delFileUserLocPathNameMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*/path/*/name/*", "DELETE", shouldLogYes, config.RoleUser)
+var postFileUserLocNameMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*/name/*", "POST", shouldLogYes, config.RoleUser)
+var postFileUserLocPathNameMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*/path/*/name/*", "POST", shouldLogYes, config.RoleUser)
+
+// multipart/form-data with one or more files. Each file is saved in the loc (or path) using its own name
+var postFileUserLocMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*", "POST", shouldLogYes, config.RoleUser)
+var postFileUserLocPathMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*/path/*", "POST", shouldLogYes, config.RoleUser)
+
+// Move or copy a file. The 'Destination' header is the /files/user/*/loc/*[/path/*]/name/* url of the new file
+var moveFileUserLocNameMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*/name/*", "MOVE", shouldLogYes, config.RoleUser)
+var moveFileUserLocPathNameMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*/path/*/name/*", "MOVE", shouldLogYes, config.RoleUser)
+var copyFileUserLocNameMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*/name/*", "COPY", shouldLogYes, config.RoleUser)
+var copyFileUserLocPathNameMatch = rootUrlList.AddUrlRequestMatcher("/files/user/*/loc/*/path/*/name/*", "COPY", shouldLogYes, config.RoleUser)
+
+var getPathsUserLocMatch = rootUrlList.AddUrlRequestMatcher("/paths/user/*/loc/*", "GET", shouldLogYes, config.RoleGuest)
+
+// Previous versions of a file. List, download (GET with id) or roll back (POST with id)
+var getVersionsUserLocNameMatch = rootUrlList.AddUrlRequestMatcher("/versions/user/*/loc/*/name/*", "GET", shouldLogYes, config.RoleGuest)
+var getVersionsUserLocPathNameMatch = rootUrlList.AddUrlRequestMatcher("/versions/user/*/loc/*/path/*/name/*", "GET", shouldLogYes, config.RoleGuest)
+var getVersionUserLocNameIdMatch = rootUrlList.AddUrlRequestMatcher("/versions/user/*/loc/*/name/*/id/*", "GET", shouldLogYes, config.RoleGuest)
+var getVersionUserLocPathNameIdMatch = rootUrlList.AddUrlRequestMatcher("/versions/user/*/loc/*/path/*/name/*/id/*", "GET", shouldLogYes, config.RoleGuest)
+var postVersionUserLocNameIdMatch = rootUrlList.AddUrlRequestMatcher("/versions/user/*/loc/*/name/*/id/*", "POST", shouldLogYes, config.RoleUser)
+var postVersionUserLocPathNameIdMatch = rootUrlList.AddUrlRequestMatcher("/versions/user/*/loc/*/path/*/name/*/id/*", "POST", shouldLogYes, config.RoleUser)
+
+// A thumbnail of a picture. Generated and cached in the users thumbnail location if there is not one
+var getThumbnailUserLocNameMatch = rootUrlList.AddUrlRequestMatcher("/thumbnail/user/*/loc/*/name/*", "GET", shouldLogYes, config.RoleGuest)
+var getThumbnailUserLocPathNameMatch = rootUrlList.AddUrlRequestMatcher("/thumbnail/user/*/loc/*/path/*/name/*", "GET", shouldLogYes, config.RoleGuest)
+
+// EXIF metadata for a JPEG picture. Date taken, camera, orientation, size and GPS position
+var getMetadataUserLocNameMatch = rootUrlList.AddUrlRequestMatcher("/metadata/user/*/loc/*/name/*", "GET", shouldLogYes, config.RoleGuest)
+var getMetadataUserLocPathNameMatch = rootUrlList.AddUrlRequestMatcher("/metadata/user/*/loc/*/path/*/name/*", "GET", shouldLogYes, config.RoleGuest)
+
+// Metadata for one file. ?sha256=true adds a checksum
+var getStatUserLocNameMatch = rootUrlList.AddUrlRequestMatcher("/stat/user/*/loc/*/name/*", "GET", shouldLogYes, config.RoleGuest)
+var getStatUserLocPathNameMatch = rootUrlList.AddUrlRequestMatcher("/stat/user/*/loc/*/path/*/name/*", "GET", shouldLogYes, config.RoleGuest)
+
+// Deleted files. List, restore (POST) or purge (DELETE) one item or empty the trash
+var getTrashUserMatch = rootUrlList.AddUrlRequestMatcher("/trash/user/*", "GET", shouldLogYes, config.RoleUser)
+var delTrashUserMatch = rootUrlList.AddUrlRequestMatcher("/trash/user/*", "DELETE", shouldLogYes, config.RoleUser)
+var postTrashUserIdMatch = rootUrlList.AddUrlRequestMatcher("/trash/user/*/id/*", "POST", shouldLogYes, config.RoleUser)
+var delTrashUserIdMatch = rootUrlList.AddUrlRequestMatcher("/trash/user/*/id/*", "DELETE", shouldLogYes, config.RoleUser)
+
+// Find files in a location (or path) and its sub directories
+var getSearchUserLocMatch = rootUrlList.AddUrlRequestMatcher("/search/user/*/loc/*", "GET", shouldLogYes, config.RoleGuest)
+var getSearchUserLocPathMatch = rootUrlList.AddUrlRequestMatcher("/search/user/*/loc/*/path/*", "GET", shouldLogYes, config.RoleGuest)
+
+// Pictures grouped by the date they were taken. 'onthisday' is pictures taken on today's month and day in earlier years
+var getTimelineUserLocMatch = rootUrlList.AddUrlRequestMatcher("/timeline/user/*/loc/*", "GET", shouldLogYes, config.RoleGuest)
+var getTimelineUserLocPathMatch = rootUrlList.AddUrlRequestMatcher("/timeline/user/*/loc/*/path/*", "GET", shouldLogYes, config.RoleGuest)
+var getOnThisDayUserLocMatch = rootUrlList.AddUrlRequestMatcher("/onthisday/user/*/loc/*", "GET", shouldLogYes, config.RoleGuest)
+var getOnThisDayUserLocPathMatch = rootUrlList.AddUrlRequestMatcher("/onthisday/user/*/loc/*/path/*", "GET", shouldLogYes, config.RoleGuest)
+
+// Download a directory as a zip (or ?format=tgz). POST a JSON list of names to select files
+var getArchiveUserLocMatch = rootUrlList.AddUrlRequestMatcher("/archive/user/*/loc/*", "GET", shouldLogYes, config.RoleGuest)
+var getArchiveUserLocPathMatch = rootUrlList.AddUrlRequestMatcher("/archive/user/*/loc/*/path/*", "GET", shouldLogYes, config.RoleGuest)
+var postArchiveUserLocMatch = rootUrlList.AddUrlRequestMatcher("/archive/user/*/loc/*", "POST", shouldLogYes, config.RoleGuest)
+var postArchiveUserLocPathMatch = rootUrlList.AddUrlRequestMatcher("/archive/user/*/loc/*/path/*", "POST", shouldLogYes, config.RoleGuest)
+
+// Create a signed link to a file. The link (/share/<token>) can be used by anyone until it expires
+var postShareUserLocNameMatch = rootUrlList.AddUrlRequestMatcher("/share/user/*/loc/*/name/*", "POST", shouldLogYes, config.RoleUser)
+var postShareUserLocPathNameMatch = rootUrlList.AddUrlRequestMatcher("/share/user/*/loc/*/path/*/name/*", "POST", shouldLogYes, config.RoleUser)
+var getShareMatch = rootUrlList.AddUrlRequestMatcher("/share/*", "GET", shouldLogYes, config.RoleGuest)
+
+// Create (with parents) or delete a directory. Delete of a non empty directory needs ?recursive=true
+var postPathsUserLocPathMatch = rootUrlList.AddUrlRequestMatcher("/paths/user/*/loc/*/path/*", "POST", shouldLogYes, config.RoleUser)
+var delPathsUserLocPathMatch = rootUrlList.AddUrlRequestMatcher("/paths/user/*/loc/*/path/*", "DELETE", shouldLogYes, config.RoleUser)
+
+var getTestUserLocNameMatch = rootUrlList.AddUrlRequestMatcher("/test/user/*/loc/*/name/*", "GET", shouldLogNo, config.RoleGuest)
+
+// Login returns a session cookie. Logout removes it.
+var postAuthLoginMatch = rootUrlList.AddUrlRequestMatcher("/auth/login", "POST", shouldLogYes, config.RoleGuest)
+var getAuthLogoutMatch = rootUrlList.AddUrlRequestMatcher("/auth/logout", "GET", shouldLogYes, config.RoleGuest)
+
+// Prometheus text format. Not logged as it is scraped frequently.
+var getMetricsMatch = rootUrlList.AddUrlRequestMatcher("/metrics", "GET", shouldLogNo, config.RoleAdmin)
 
 type ServerHandler struct {
-	config      *config.ConfigData
+	config      *config.ConfigData // The config snapshot for this request. See ServeHTTP
 	actionQueue chan *ActionEvent
 	logger      logging.Logger
 	upSince     time.Time
 	longRunning *runCommand.LongRunningManager
+	sessions    *SessionManager // Derived from config. Part of the same snapshot
+	credentials *verifiedCredentials
+	publisher   *configPublisher // Holds the current config. Shared by all requests
+	metrics     *serverMetrics   // Shared by all requests
+	route       string           // The matcher template for this request. Set by serveHTTP for metrics
 }
 
 func NewServerHandler(configData *config.ConfigData, actionQueue chan *ActionEvent, lrm *runCommand.LongRunningManager, logger logging.Logger, upSince time.Time) *ServerHandler {
 	if lrm == nil {
 		lrm = runCommand.NewLongRunningManagerDisabled()
 	}
-
+	publisher := newConfigPublisher(configData)
 	return &ServerHandler{
 		config:      configData,
 		actionQueue: actionQueue,
 		logger:      logger,
 		longRunning: lrm,
 		upSince:     upSince,
+		sessions:    publisher.load().sessions,
+		credentials: newVerifiedCredentials(),
+		publisher:   publisher,
+		metrics:     newServerMetrics(),
 	}
 }
 
+/*
+Config returns the current (most recently published) config.
+*/
+func (h *ServerHandler) Config() *config.ConfigData {
+	return h.publisher.load().config
+}
+
+/*
+Returns a copy of the handler with the current config snapshot.
+A reload during the request does not change the config the request is using.
+*/
+func (h *ServerHandler) forRequest() *ServerHandler {
+	st := h.publisher.load()
+	rh := *h
+	rh.config = st.config
+	rh.sessions = st.sessions
+	return &rh
+}
+
 func (p *ServerHandler) GetUpSince() time.Time {
 	return p.upSince
 }
@@ -129,6 +233,13 @@ func (h *ServerHandler) close() {
 }
 
 func (h *ServerHandler) serveFile(w http.ResponseWriter, r *http.Request, name string, verboseFunc func(string), shouldLog bool) {
+	h.serveFileAs(w, r, name, name, verboseFunc, shouldLog)
+}
+
+/*
+Serve file 'name' with the Content-Type for 'typeName'. Used when the file name has no extension.
+*/
+func (h *ServerHandler) serveFileAs(w http.ResponseWriter, r *http.Request, name string, typeName string, verboseFunc func(string), shouldLog bool) {
 	stat, err := os.Stat(name)
 	if err != nil {
 		panic(config.NewServerError("File not found", http.StatusNotFound, fmt.Sprintf("File not found. :%s", h.config.GetPathForDisplay(name))))
@@ -140,11 +251,61 @@ func (h *ServerHandler) serveFile(w http.ResponseWriter, r *http.Request, name s
 		verboseFunc(fmt.Sprintf("FastFile: %s", h.config.GetPathForDisplay(name)))
 	}
 	w.Header().Set("Server", h.config.GetServerName())
-	w.Header().Set("Content-Type", config.LookupContentType(name))
+	w.Header().Set("Content-Type", config.LookupContentType(typeName))
+	before := bytesWritten(w)
 	http.ServeFile(w, r, name)
+	h.metrics.observeFileBytes(h.route, bytesWritten(w)-before)
+}
+
+/*
+The parameters of the 'Destination' header for MOVE and COPY.
+
+It can be a path or an absolute url. It must be a file url with a user, loc, optional path and name.
+*/
+func destinationParams(r *http.Request) map[string]string {
+	dest := strings.TrimSpace(r.Header.Get("Destination"))
+	if dest == "" {
+		panic(config.NewServerError("Destination header is required", http.StatusBadRequest, fmt.Sprintf("Req:  %s:%s has no Destination", r.Method, r.URL.Path)))
+	}
+	u, err := url.Parse(dest)
+	if err != nil {
+		panic(config.NewServerError("Invalid Destination header", http.StatusBadRequest, fmt.Sprintf("Destination:%s %s", dest, err.Error())))
+	}
+	parts := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
+	matcher, p, _ := rootUrlList.Route(parts, r.Method, nil)
+	switch matcher {
+	case moveFileUserLocNameMatch, moveFileUserLocPathNameMatch, copyFileUserLocNameMatch, copyFileUserLocPathNameMatch:
+		return p
+	}
+	panic(config.NewServerError("Invalid Destination header", http.StatusBadRequest, fmt.Sprintf("Destination:%s is not a file url", dest)))
+}
+
+/*
+Stream the archive. Once started the status cannot be changed so a failure is only logged
+and the client gets an incomplete archive.
+*/
+func (h *ServerHandler) serveArchive(w http.ResponseWriter, ah *controllers.ArchiveHandler, logFunc func(string)) {
+	w.Header().Set("Server", h.config.GetServerName())
+	w.Header().Set("Content-Type", ah.MimeType())
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": ah.FileName()}))
+	w.WriteHeader(http.StatusOK)
+	n, err := ah.WriteTo(w)
+	h.metrics.observeFileBytes(h.route, n)
+	if err != nil {
+		logFunc(fmt.Sprintf("Archive: %s failed after %d bytes. %s", ah.FileName(), n, err.Error()))
+	}
 }
 
 func (h *ServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	mw := newMetricsWriter(w)
+	rh := h.forRequest()
+	rh.route = routeUnmatched
+	rh.serveHTTP(mw, r)
+	h.metrics.observeRequest(rh.route, r.Method, mw.Status(), time.Since(start))
+}
+
+func (h *ServerHandler) serveHTTP(w http.ResponseWriter, r *http.Request) {
 	logFunc := h.logger.Log
 	verboseFunc := h.logger.VerboseFunction()
 	defer func() {
@@ -162,6 +323,9 @@ func (h *ServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				le = config.NewPanicError(fmt.Sprintf("%v", rec), 404)
 			}
 			logFunc(le.LogError())
+			if le.Status() == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", authRealm))
+			}
 			h.writeResponse(w, controllers.NewResponseData(le.Status()).SetHasErrors(true).WithContentMapAsJson(le.Map(), r.URL.Query()), true)
 		}
 	}()
@@ -169,6 +333,7 @@ func (h *ServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	urlPath := strings.TrimSpace(r.URL.Path)
 	if urlPath == "/" {
 		if h.config.HasStaticWebData {
+			h.route = routeHome
 			homePage := h.config.GetStaticWebData().GetHomePage()
 			if h.config.ShouldTemplateFile(homePage) {
 				h.writeResponse(w, controllers.StaticFileTemplate(homePage, controllers.NewUrlRequestParts(h.config).WithQuery(r.URL.Query()).WithHeader(r.Header), logFunc), true)
@@ -190,6 +355,15 @@ func (h *ServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// WebDAV methods and paths do not fit the url matchers. The handler resolves /dav/<user>/<loc>/<path>
+	if requestUrlparts[0] == controllers.DavRoot {
+		h.route = routeDav
+		identity := h.authenticate(w, r)
+		h.config.CheckRole(identity, controllers.DavMethodRole(r.Method))
+		controllers.NewDavHandler(h.config, identity, verboseFunc).Serve(w, r)
+		return
+	}
+
 	// Is the root of the url cached in matcherRequestIds
 	requestMatchesRoot := rootUrlList.HasRoot(requestUrlparts[0])
 
@@ -217,6 +391,7 @@ func (h *ServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		if staticFileName != "" {
+			h.route = routeStatic
 			// if we derived a static file name then return the file ASAP
 			if h.config.ShouldTemplateFile(staticFileName) {
 				h.writeResponse(w, controllers.StaticFileTemplate(staticFileName, controllers.NewUrlRequestParts(h.config).WithQuery(r.URL.Query()).WithHeader(r.Header), verboseFunc), true)
@@ -228,7 +403,7 @@ func (h *ServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		// Url is not a static file (yet!) so carry on..
 	}
 
-	urlRequestParts := controllers.NewUrlRequestParts(h.config).WithQuery(r.URL.Query()).WithHeader(r.Header)
+	urlRequestParts := controllers.NewUrlRequestParts(h.config).WithQuery(r.URL.Query()).WithHeader(r.Header).WithOperation(controllers.OperationForMethod(r.Method))
 	if !requestMatchesRoot {
 		// The root of the url does not match any Matcher so 404!
 		h.writeErrorResponse(w, "Resource not found", http.StatusNotFound, fmt.Sprintf("Req:  %s:%s%s", r.Method, urlPath, urlRequestParts.QueryAsString()))
@@ -236,174 +411,201 @@ func (h *ServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	requestInfo := NewRequestInfo(r.Method, urlPath, r.URL.RawQuery, logFunc, verboseFunc)
 
-	_, ok, shouldLog := getPingMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
-		// Panic Check Done
-		h.writeResponse(w, controllers.NewResponseData(http.StatusOK).WithContentWithCauseAsJson("Ping", nil), shouldLog)
+	matcher, p, allowed := rootUrlList.Route(requestUrlparts, r.Method, requestInfo)
+	if matcher == nil {
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			h.writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed, fmt.Sprintf("Req:  %s:%s%s Allow:%s", r.Method, urlPath, urlRequestParts.QueryAsString(), allowed))
+			return
+		}
+		h.writeErrorResponse(w, "Resource not found", http.StatusNotFound, fmt.Sprintf("Req:  %s:%s%s", r.Method, urlPath, urlRequestParts.QueryAsString()))
 		return
 	}
-	_, ok, shouldLog = getIsUpMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
+	h.route = matcher.Template()
+	shouldLog := matcher.shouldLog
+	identity := h.authenticate(w, r)
+	urlRequestParts.WithIdentity(identity)
+	h.config.CheckRole(identity, matcher.Role())
+
+	switch matcher {
+	case getPingMatch:
+		// Panic Check Done
+		h.writeResponse(w, controllers.NewResponseData(http.StatusOK).WithContentWithCauseAsJson("Ping", nil), shouldLog)
+	case getIsUpMatch:
 		// Panic Check Done
 		h.writeResponse(w, controllers.NewResponseData(http.StatusOK).WithContentWithCauseAsJson("ServerIsUp", nil), shouldLog)
-		return
-	}
-
-	_, ok, shouldLog = getServerTimeMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
+	case getServerTimeMatch:
 		// Panic Check Done
 		h.writeResponse(w, controllers.NewResponseData(http.StatusOK).WithContentMapAsJson(controllers.GetTimeAsMap(), nil), shouldLog)
-		return
-	}
-	_, ok, shouldLog = getFileUserLocNameMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
-		tn := r.URL.Query().Get("thumbnail")
-		name := controllers.GetFastFileName(h.config, requestUrlparts, urlPath, (tn == "true"))
-		h.serveFile(w, r, name, verboseFunc, shouldLog)
-		return
-	}
-	_, ok, shouldLog = getFileUserLocPathNameMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
-		tn := r.URL.Query().Get("thumbnail")
-		name := controllers.GetFastFileName(h.config, requestUrlparts, urlPath, (tn == "true"))
-		h.serveFile(w, r, name, verboseFunc, shouldLog)
-		return
-	}
-	_, ok, shouldLog = getTestUserLocNameMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
+	case getFileUserLocNameMatch, getFileUserLocPathNameMatch, getTestUserLocNameMatch:
+		//  Service using FastFiles
+		h.config.CheckLocationAccess(p["user"], p["loc"], identity, config.OpRead)
 		tn := r.URL.Query().Get("thumbnail")
 		name := controllers.GetFastFileName(h.config, requestUrlparts, urlPath, (tn == "true"))
+		h.config.CheckPathAccess(name, config.OpRead)
+		if controllers.IsResizeRequest(r.URL.Query()) {
+			// w, h, fit or format. Served from the resize cache
+			name = controllers.GetResizedFileName(h.config, name, r.URL.Query(), verboseFunc)
+		}
 		h.serveFile(w, r, name, verboseFunc, shouldLog)
-		return
-	}
-	p, ok, shouldLog := getFileUserLocPathMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
-		h.writeResponse(w, controllers.NewDirHandler(urlRequestParts.WithParameters(p), h.config, true, verboseFunc).Submit(), shouldLog)
-		return
-	}
-	p, ok, shouldLog = getFileUserLocMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
+	case getFileUserLocPathMatch, getFileUserLocMatch:
 		// Panic Check Done
 		h.writeResponse(w, controllers.NewDirHandler(urlRequestParts.WithParameters(p), h.config, true, verboseFunc).Submit(), shouldLog)
-		return
-	}
-	p, ok, shouldLog = getPathsUserLocMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
+	case getPathsUserLocMatch:
 		// Panic Check Done
 		h.writeResponse(w, controllers.NewDirHandler(urlRequestParts.WithParameters(p), h.config, false, verboseFunc).Submit(), shouldLog)
-		return
-	}
-	p, ok, shouldLog = getFileUserLocTreeMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
+	case getVersionsUserLocNameMatch, getVersionsUserLocPathNameMatch:
+		// Panic Check Done
+		h.writeResponse(w, controllers.NewVersionsHandler(urlRequestParts.WithParameters(p), h.config, false, verboseFunc).Submit(), shouldLog)
+	case getVersionUserLocNameIdMatch, getVersionUserLocPathNameIdMatch:
+		// Panic Check Done
+		urlRequestParts.WithParameters(p)
+		h.serveFileAs(w, r, controllers.GetVersionFileName(urlRequestParts), urlRequestParts.GetParam(controllers.NameParam), verboseFunc, shouldLog)
+	case postVersionUserLocNameIdMatch, postVersionUserLocPathNameIdMatch:
+		// Panic Check Done
+		h.writeResponse(w, controllers.NewVersionsHandler(urlRequestParts.WithParameters(p), h.config, true, verboseFunc).Submit(), shouldLog)
+	case getThumbnailUserLocNameMatch, getThumbnailUserLocPathNameMatch:
+		// Panic Check Done
+		h.serveFile(w, r, controllers.GetThumbnailFileName(urlRequestParts.WithParameters(p), h.config, verboseFunc), verboseFunc, shouldLog)
+	case getMetadataUserLocNameMatch, getMetadataUserLocPathNameMatch:
+		// Panic Check Done
+		h.writeResponse(w, controllers.NewExifHandler(urlRequestParts.WithParameters(p), h.config, verboseFunc).Submit(), shouldLog)
+	case getStatUserLocNameMatch, getStatUserLocPathNameMatch:
+		// Panic Check Done
+		h.writeResponse(w, controllers.NewStatHandler(urlRequestParts.WithParameters(p), h.config, verboseFunc).Submit(), shouldLog)
+	case postShareUserLocNameMatch, postShareUserLocPathNameMatch:
+		// Panic Check Done
+		h.writeResponse(w, controllers.NewShareHandler(urlRequestParts.WithParameters(p).WithOperation(config.OpRead), h.config, verboseFunc).Submit(), shouldLog)
+	case getShareMatch:
+		// Panic Check Done
+		// Only the first part of a download is counted. Players request videos in parts
+		rng := r.Header.Get("Range")
+		h.serveFile(w, r, controllers.GetSharedFileName(h.config, p[controllers.ShareRoot], rng == "" || strings.HasPrefix(rng, "bytes=0-"), verboseFunc), verboseFunc, shouldLog)
+	case getTrashUserMatch:
+		// Panic Check Done
+		h.writeResponse(w, controllers.NewTrashHandler(urlRequestParts.WithParameters(p), h.config, "list", verboseFunc).Submit(), shouldLog)
+	case postTrashUserIdMatch:
+		// Panic Check Done
+		h.writeResponse(w, controllers.NewTrashHandler(urlRequestParts.WithParameters(p), h.config, "restore", verboseFunc).Submit(), shouldLog)
+	case delTrashUserMatch, delTrashUserIdMatch:
+		// Panic Check Done
+		h.writeResponse(w, controllers.NewTrashHandler(urlRequestParts.WithParameters(p), h.config, "purge", verboseFunc).Submit(), shouldLog)
+	case getSearchUserLocMatch, getSearchUserLocPathMatch:
+		// Panic Check Done
+		h.writeResponse(w, controllers.NewSearchHandler(urlRequestParts.WithParameters(p), h.config, r, verboseFunc).Submit(), shouldLog)
+	case getTimelineUserLocMatch, getTimelineUserLocPathMatch:
+		// Panic Check Done
+		h.writeResponse(w, controllers.NewTimelineHandler(urlRequestParts.WithParameters(p), h.config, r, false, verboseFunc).Submit(), shouldLog)
+	case getOnThisDayUserLocMatch, getOnThisDayUserLocPathMatch:
+		// Panic Check Done
+		h.writeResponse(w, controllers.NewTimelineHandler(urlRequestParts.WithParameters(p), h.config, r, true, verboseFunc).Submit(), shouldLog)
+	case getArchiveUserLocMatch, getArchiveUserLocPathMatch, postArchiveUserLocMatch, postArchiveUserLocPathMatch:
+		// Panic Check Done. Panics before the response is started. A POST only selects the files
+		h.serveArchive(w, controllers.NewArchiveHandler(urlRequestParts.WithParameters(p).WithOperation(config.OpRead), h.config, r, verboseFunc).Prepare(), logFunc)
+	case postPathsUserLocPathMatch:
+		// Panic Check Done
+		h.writeResponse(w, controllers.NewCreatePathHandler(urlRequestParts.WithParameters(p), h.config, verboseFunc).Submit(), shouldLog)
+	case delPathsUserLocPathMatch:
+		// Panic Check Done
+		h.writeResponse(w, controllers.NewDeletePathHandler(urlRequestParts.WithParameters(p), h.config, verboseFunc).Submit(), shouldLog)
+	case getFileUserLocTreeMatch:
 		// Panic Check Done
 		h.writeResponse(w, controllers.NewTreeHandler(urlRequestParts.WithParameters(p), h.config).Submit(), shouldLog)
-		return
-	}
-	//  Service using FastFiles
-	p, ok, shouldLog = delFileUserLocNameMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
+	case delFileUserLocNameMatch, delFileUserLocPathNameMatch:
 		// Panic Check Done
 		h.writeResponse(w, controllers.NewDeleteFileHandler(urlRequestParts.WithParameters(p), h.config, verboseFunc).Submit(), shouldLog)
-		return
-	}
-
-	p, ok, shouldLog = getPropUserNameValueMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
+	case getPropUserNameValueMatch, getPropUserNameMatch:
 		// Panic Check Done
+		h.config.CheckUserAccess(p["user"], identity)
 		h.writeResponse(w, controllers.NewResponseData(200).WithContentBytes([]byte(h.config.GetSetUserProp(p))).WithMimeType("txt").AndLogContent(true), shouldLog)
-		return
-	}
-	p, ok, shouldLog = getPropUserNameMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
-		// Panic Check Done
-		//
-		h.writeResponse(w, controllers.NewResponseData(200).WithContentBytes([]byte(h.config.GetSetUserProp(p))).WithMimeType("txt").AndLogContent(true), shouldLog)
-		return
-	}
-	p, ok, shouldLog = getPropUserMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
+	case getPropUserMatch:
 		// Panic Check Done
+		h.config.CheckUserAccess(p["user"], identity)
 		h.writeResponse(w, controllers.GetPropertiesForUser(urlRequestParts.WithParameters(p), h.config), shouldLog)
-		return
-	}
-	p, ok, shouldLog = postFileUserLocPathNameMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
+	case postFileUserLocPathNameMatch, postFileUserLocNameMatch:
 		// Panic Check Done
 		h.writeResponse(w, controllers.NewPostFileHandler(urlRequestParts.WithParameters(p), h.config, r, false, verboseFunc).Submit(), shouldLog)
-		return
-	}
-
-	p, ok, shouldLog = getExecMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
-		// Panic Check ????
-		h.writeResponse(w, controllers.NewExecHandler(urlRequestParts.WithParameters(p).AsAdmin(), h.config.GetExecPath(), nil, logFunc, verboseFunc).Submit(), shouldLog)
-		return
-	}
-	p, ok, shouldLog = postFileUserLocNameMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
+	case postFileUserLocMatch, postFileUserLocPathMatch:
+		h.writeResponse(w, controllers.NewMultipartUploadHandler(urlRequestParts.WithParameters(p), h.config, r, verboseFunc).Submit(), shouldLog)
+	case moveFileUserLocNameMatch, moveFileUserLocPathNameMatch, copyFileUserLocNameMatch, copyFileUserLocPathNameMatch:
 		// Panic Check Done
-		h.writeResponse(w, controllers.NewPostFileHandler(urlRequestParts.WithParameters(p), h.config, r, false, verboseFunc).Submit(), shouldLog)
-		return
-	}
-	_, ok, shouldLog = getServerRestartMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
+		to := controllers.NewUrlRequestParts(h.config).WithIdentity(identity).WithParameters(destinationParams(r))
+		overwrite := strings.EqualFold(strings.TrimSpace(r.Header.Get("Overwrite")), "T")
+		h.writeResponse(w, controllers.NewMoveCopyHandler(urlRequestParts.WithParameters(p), to, h.config, r.Method == "MOVE", overwrite, verboseFunc).Submit(), shouldLog)
+	case getExecMatch:
+		// Panic Check ????
+		h.config.CheckExecAccess(identity, p[controllers.ExecParam])
+		resp := controllers.NewExecHandler(urlRequestParts.WithParameters(p).AsAdmin(), h.config.GetExecPath(), nil, logFunc, verboseFunc).Submit()
+		if execId, rc, ok := resp.ExecResult(); ok {
+			h.metrics.observeExec(execId, rc)
+		}
+		h.writeResponse(w, resp, shouldLog)
+	case getServerRestartMatch:
 		// Panic Check Done
 		a := NewActionEvent(Exit, urlRequestParts.GetOptionalQuery("rc", "23"), 23, "Restart Requested")
 		h.actionQueue <- a
 		h.writeResponse(w, controllers.NewResponseData(http.StatusAccepted).WithContentMapAsJson(map[string]interface{}{"Status": "RESTARTED"}, nil), shouldLog)
-		return
-	}
-	_, ok, shouldLog = getServerExitMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
+	case getServerExitMatch:
 		// Panic Check Done
 		a := NewActionEvent(Exit, urlRequestParts.GetOptionalQuery("rc", "11"), 11, "Exit Requested")
 		h.actionQueue <- a
 		h.writeResponse(w, controllers.NewResponseData(http.StatusAccepted).WithContentWithCauseAsJson(a.String(), nil), shouldLog)
-		return
-	}
-	// Panic Check Done
-	_, ok, shouldLog = getServerStatusMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
+	case getServerStatusMatch:
+		// Panic Check Done
 		if h.longRunning.IsEnabled() {
 			h.longRunning.Update()
 		}
-		h.writeResponse(w, controllers.NewResponseData(http.StatusOK).WithContentBytes(controllers.GetServerStatusAsJson(h.config, h.logger.LogFileName(), h.GetUpSince(), h.longRunning.ToJson())), shouldLog)
-		return
-	}
-
-	_, ok, shouldLog = getServerUsersMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
+		h.writeResponse(w, controllers.NewResponseData(http.StatusOK).WithContentBytes(controllers.GetServerStatusAsJson(h.config, h.logger.LogFileName(), h.GetUpSince(), h.longRunning.ToJson(), h.publisher.statusJson())), shouldLog)
+	case getServerUsersMatch:
 		// Panic Check Done
 		h.writeResponse(w, controllers.NewResponseData(http.StatusOK).WithContentMapAsJson(controllers.GetUsersAsMap(h.config.GetUsers()), nil), shouldLog)
-		return
-	}
-
-	p, ok, shouldLog = delServerLogMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
+	case delServerLogMatch:
 		h.writeResponse(w, controllers.DelLog(h.config, p["log"], h.logger.LogFileName(), urlRequestParts.Query), shouldLog)
-		return
-	}
-	_, ok, shouldLog = getServerLogMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
+	case getServerSharesMatch:
+		// Panic Check Done
+		h.writeResponse(w, controllers.ListShares(h.config), shouldLog)
+	case delServerSharesMatch:
+		// Panic Check Done
+		h.writeResponse(w, controllers.RevokeShare(h.config, p["shares"], urlRequestParts.Query, verboseFunc), shouldLog)
+	case getServerLogMatch:
 		// Panic Check ????
 		ofs := urlRequestParts.AsAdmin().GetOptionalQuery("offset", "0")
 		h.writeResponse(w, controllers.GetLog(h.config, h.logger.LogFileName(), ofs), shouldLog)
-		return
-	}
-	_, ok, shouldLog = getReloadConfigMatch.Match(requestUrlparts, r.Method, requestInfo)
-	if ok {
-		configErrors := config.NewConfigErrorData()
-		cfg := config.NewConfigData(h.config.ConfigName, h.config.ModuleName, h.config.Debugging, false, h.config.IsVerbose, configErrors)
-		if configErrors.ErrorCount() == 0 {
-			h.config = cfg
-			h.Log(fmt.Sprintf("Config: %s file reload on demand!", h.config.ConfigName))
+	case getServerLogStreamMatch:
+		h.streamLog(w, r)
+	case postAuthLoginMatch:
+		h.writeResponse(w, controllers.NewResponseData(http.StatusOK).WithContentMapAsJson(h.login(w, r), nil), shouldLog)
+	case getAuthLogoutMatch:
+		h.clearSessionCookie(w)
+		h.writeResponse(w, controllers.NewResponseData(http.StatusOK).WithContentWithCauseAsJson("Logged out", nil), shouldLog)
+	case getMetricsMatch:
+		h.writeMetrics(w)
+	case getReloadConfigMatch:
+		err := h.ReloadConfig("on demand")
+		if err == nil {
 			h.writeResponse(w, controllers.NewResponseData(http.StatusOK).WithContentWithCauseAsJson("Config Reloaded", nil), shouldLog)
 		} else {
-			h.writeErrorResponse(w, "Config: Failed to re-load", http.StatusInternalServerError, fmt.Sprintf("Config Reload Failed with %d errors", configErrors.ErrorCount()))
+			h.writeErrorResponse(w, "Config: Failed to re-load", http.StatusInternalServerError, err.Error())
 		}
-		return
+	default:
+		// A matcher was registered in rootUrlList but has no case above.
+		h.writeErrorResponse(w, "Resource not found", http.StatusNotFound, fmt.Sprintf("Req:  %s:%s%s. No handler for %s", r.Method, urlPath, urlRequestParts.QueryAsString(), matcher))
 	}
-	h.writeErrorResponse(w, "Resource not found", http.StatusNotFound, fmt.Sprintf("Req:  %s:%s%s", r.Method, urlPath, urlRequestParts.QueryAsString()))
+}
+
+/*
+Re-load the config file. If there are errors the current config is retained.
+
+The new config is published for new requests. Requests in progress keep their snapshot.
+'reason' is logged. For example 'on demand' or 'by SIGHUP'.
+*/
+func (h *ServerHandler) ReloadConfig(reason string) error {
+	cfg, err := h.publisher.reload(reason)
+	if err != nil {
+		return err
+	}
+	h.Log(fmt.Sprintf("Config: %s file reload %s!", cfg.ConfigName, reason))
+	return nil
 }
 
 func (p *ServerHandler) writeErrorResponse(w http.ResponseWriter, cause string, status int, log string) {
@@ -424,6 +626,9 @@ func (p *ServerHandler) writeResponse(w http.ResponseWriter, resp *controllers.R
 			}
 		}
 	}
+	for n, v := range resp.Header {
+		w.Header()[n] = v
+	}
 	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Server", p.config.GetServerName())
 	w.WriteHeader(resp.Status)
@@ -431,32 +636,78 @@ func (p *ServerHandler) writeResponse(w http.ResponseWriter, resp *controllers.R
 }
 
 type WebAppServer struct {
-	Handler  *ServerHandler
-	Server   *http.Server
-	ExitCode int
+	Handler      *ServerHandler
+	Server       *http.Server
+	Redirect     *http.Server // Plain HTTP to HTTPS redirect. nil if not required
+	ExitCode     int
+	shutdownOnce sync.Once
+	shutdownDone chan struct{} // Closed when Close has completed
 }
 
 func NewWebAppServer(configData *config.ConfigData, actionQueue chan *ActionEvent, lrm *runCommand.LongRunningManager, logger logging.Logger) (*WebAppServer, error) {
 	handler := NewServerHandler(configData, actionQueue, lrm, logger, time.Now())
-	return &WebAppServer{
+	was := &WebAppServer{
 		Handler: handler,
 		Server: &http.Server{
 			Addr:    configData.GetPortString(),
 			Handler: handler,
 		},
-		ExitCode: 0,
-	}, nil
+		ExitCode:     0,
+		shutdownDone: make(chan struct{}),
+	}
+	if configData.IsTLS() {
+		cert, key := configData.GetTLSFiles()
+		cr, err := NewCertReloader(cert, key, logger.Log)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate. %s", err.Error())
+		}
+		was.Server.TLSConfig = cr.TLSConfig()
+		if configData.GetRedirectPortString() != "" {
+			was.Redirect = &http.Server{
+				Addr:    configData.GetRedirectPortString(),
+				Handler: NewRedirectHandler(configData.GetPortString()),
+			}
+		}
+	}
+	return was, nil
 }
 
 func (p *WebAppServer) Log(s string) {
 	p.Handler.Log(s)
 }
 
+/*
+Close stops the server. It is safe to call more than once. Every call waits for the first to complete.
+
+New connections are refused and in-flight requests are given config ShutdownSeconds to complete.
+Connections still open after that are closed. Detached processes are then stopped (according to
+their Exec OnExit policy) and finally the logger is flushed and closed.
+
+Returns the exit code given to the first call.
+*/
 func (p *WebAppServer) Close(rc int) int {
-	p.ExitCode = rc
-	p.Handler.close()
-	p.Server.Shutdown(context.TODO())
-	return rc
+	p.shutdownOnce.Do(func() {
+		defer close(p.shutdownDone)
+		p.ExitCode = rc
+		p.Handler.publisher.close()
+		timeout := p.Handler.Config().GetShutdownTimeout()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if p.Redirect != nil {
+			p.Redirect.Shutdown(ctx)
+		}
+		err := p.Server.Shutdown(ctx)
+		if err != nil {
+			p.Log(fmt.Sprintf("Server Shutdown   :Requests did not complete in %s. Closing connections. %s", timeout, err.Error()))
+			p.Server.Close()
+		}
+		for _, m := range p.Handler.longRunning.StopOnExit() {
+			p.Log(m)
+		}
+		p.Handler.close()
+	})
+	<-p.shutdownDone
+	return p.ExitCode
 }
 
 func (p *WebAppServer) Start() int {
@@ -464,6 +715,7 @@ func (p *WebAppServer) Start() int {
 	if p.Handler.config.IsVerbose {
 		s, _ := p.Handler.config.String()
 		p.Log(s)
+		p.Log(fmt.Sprintf("Server Routes     :\n%s", rootUrlList.RouteTable()))
 	}
 	p.Log(fmt.Sprintf("Server Started    :%s.", p.Handler.GetUpSince().Format(time.ANSIC)))
 	if p.Handler.logger.IsOpen() {
@@ -472,6 +724,10 @@ func (p *WebAppServer) Start() int {
 		p.Log("Server Log        :Is not Open. All logging is to the console")
 	}
 	p.Log(fmt.Sprintf("Server Port       %s.", p.Handler.config.GetPortString()))
+	if p.Handler.config.IsTLS() {
+		cert, _ := p.Handler.config.GetTLSFiles()
+		p.Log(fmt.Sprintf("Server TLS        :%s.", p.Handler.config.GetPathForDisplay(cert)))
+	}
 	p.Log(fmt.Sprintf("Server Root       :%s.", p.Handler.config.GetPathForDisplay(p.Handler.config.CurrentPath)))
 	p.Log(fmt.Sprintf("Server Data Root  :%s.", p.Handler.config.GetPathForDisplay(p.Handler.config.GetServerDataRoot())))
 	if p.Handler.config.HasStaticWebData {
@@ -492,7 +748,33 @@ func (p *WebAppServer) Start() int {
 	}
 	p.Log(fmt.Sprintf("User Properties   :%s.", p.Handler.config.GetPathForDisplay(p.Handler.config.UserProps.Details())))
 
-	err := p.Server.ListenAndServe()
+	if p.Redirect != nil {
+		p.Log(fmt.Sprintf("Server Redirect   %s --> https%s.", p.Redirect.Addr, p.Server.Addr))
+		go func() {
+			err := p.Redirect.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				p.Log(fmt.Sprintf("Server Redirect Error :%s.", err.Error()))
+			}
+		}()
+	}
+
+	p.Log(fmt.Sprintf("Upload Expiry     :%s.", p.Handler.config.GetUploadExpiry()))
+	go p.Handler.sweepUploads()
+	p.Log(fmt.Sprintf("Trash Retention   :%s.", p.Handler.config.GetTrashRetention()))
+	go p.Handler.sweepTrash()
+
+	if p.Handler.config.GetReloadInterval() > 0 {
+		p.Log(fmt.Sprintf("Config Reload     :Every %s.", p.Handler.config.GetReloadInterval()))
+		go p.Handler.watchConfig()
+	}
+
+	var err error
+	if p.Server.TLSConfig != nil {
+		// Certificates are provided by TLSConfig.GetCertificate
+		err = p.Server.ListenAndServeTLS("", "")
+	} else {
+		err = p.Server.ListenAndServe()
+	}
 	if err != nil {
 		if errors.Is(err, http.ErrServerClosed) {
 			p.Log("Server Shutdown Clean")
@@ -507,7 +789,7 @@ func (p *WebAppServer) Start() int {
 }
 
 func (p *WebAppServer) String() string {
-	cAsString, err := p.Handler.config.String()
+	cAsString, err := p.Handler.Config().String()
 	if err != nil {
 		return fmt.Sprintf("Server Error: In 'Handler.config.String()': %s", err.Error())
 	}
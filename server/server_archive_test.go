@@ -0,0 +1,121 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func zipNames(t *testing.T, body []byte) []string {
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("Invalid zip: %s", err.Error())
+	}
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestArchive(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/archive/user/stuart/loc/pics", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("Zip. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Content-Disposition") != "attachment; filename=s-pics.zip" {
+		t.Fatalf("Content-Disposition:%s", rr.Header().Get("Content-Disposition"))
+	}
+	// Filtered by filterFiles and includes sub directories
+	names := strings.Join(zipNames(t, rr.Body.Bytes()), ",")
+	if names != "pic1.jpeg,s-testfolder/s-testdir1/testdata.json,s-testfolder/t5.json,s-testfolder/testdata2.json,t1.JSON,t2.Data" {
+		t.Fatalf("Zip names:%s", names)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/archive/user/stuart/loc/pics/path/s-testfolder?format=tgz", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/gzip" {
+		t.Fatalf("Tgz. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	gr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	tarNames := []string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(tr)
+		if int64(len(b)) != hdr.Size {
+			t.Fatalf("Tar %s size %d expected %d", hdr.Name, len(b), hdr.Size)
+		}
+		tarNames = append(tarNames, hdr.Name)
+	}
+	slices.Sort(tarNames)
+	if strings.Join(tarNames, ",") != "s-testdir1/testdata.json,t5.json,testdata2.json" {
+		t.Fatalf("Tar names:%v", tarNames)
+	}
+
+	// A POSTed selection. Names can be encoded
+	enc := "X0X" + base64.StdEncoding.EncodeToString([]byte("s-testfolder/s-testdir1"))
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/archive/user/stuart/loc/pics", strings.NewReader("[\"pic1.jpeg\",\""+enc+"\"]")))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Selection. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	names = strings.Join(zipNames(t, rr.Body.Bytes()), ",")
+	if names != "pic1.jpeg,s-testfolder/s-testdir1/testdata.json" {
+		t.Fatalf("Selection names:%s", names)
+	}
+
+	// Repeated names and a name inside a selected directory are only added once
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/archive/user/stuart/loc/pics", strings.NewReader("[\"pic1.jpeg\",\"pic1.jpeg\",\"s-testfolder/t5.json\",\"s-testfolder\"]")))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Duplicates. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	names = strings.Join(zipNames(t, rr.Body.Bytes()), ",")
+	if names != "pic1.jpeg,s-testfolder/s-testdir1/testdata.json,s-testfolder/t5.json,s-testfolder/testdata2.json" {
+		t.Fatalf("Duplicate names:%s", names)
+	}
+
+	for _, tc := range []struct {
+		method, url, body string
+		status            int
+	}{
+		{"POST", "/archive/user/stuart/loc/pics", "[\"../../bob\"]", http.StatusBadRequest},
+		{"POST", "/archive/user/stuart/loc/pics", "[\"missing.jpeg\"]", http.StatusNotFound},
+		{"POST", "/archive/user/stuart/loc/pics", "[\".trash\"]", http.StatusBadRequest},
+		{"POST", "/archive/user/stuart/loc/pics", "[\"_x\"]", http.StatusBadRequest},
+		{"POST", "/archive/user/stuart/loc/pics", "[\"s-testfolder/.hidden\"]", http.StatusBadRequest},
+		{"POST", "/archive/user/stuart/loc/pics", "not json", http.StatusBadRequest},
+		{"GET", "/archive/user/stuart/loc/pics?format=rar", "", http.StatusBadRequest},
+		{"GET", "/archive/user/stuart/loc/pics/path/nothere", "", http.StatusNotFound},
+	} {
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body)))
+		if rr.Code != tc.status {
+			t.Fatalf("%s %s %s. Status:%d expected %d", tc.method, tc.url, tc.body, rr.Code, tc.status)
+		}
+	}
+}
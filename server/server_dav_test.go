@@ -0,0 +1,133 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+func davRequest(t *testing.T, h *ServerHandler, method string, url string, body string, headers map[string]string, status int) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	h.ServeHTTP(rr, r)
+	if rr.Code != status {
+		t.Fatalf("%s %s Status:%d expected %d Body:%s", method, url, rr.Code, status, rr.Body.String())
+	}
+	return rr
+}
+
+func TestDav(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	configData.ConfigFileData.KeepVersions = map[string]int{"picsPlus": 2}
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+	trash := filepath.Join(configData.GetUserRoot("stuart"), config.TrashDirName)
+	os.RemoveAll(trash)
+	defer os.RemoveAll(trash)
+	dir := filepath.Join(configData.GetUserData("stuart").Locations["picsPlus"], "davdir")
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	base := "/dav/stuart/picsPlus/"
+
+	rr := davRequest(t, h, "OPTIONS", base, "", nil, http.StatusOK)
+	if rr.Header().Get("DAV") != "1, 2" {
+		t.Fatalf("OPTIONS DAV:%s", rr.Header().Get("DAV"))
+	}
+
+	// Hidden and filtered names are not listed
+	rr = davRequest(t, h, "PROPFIND", base, "", map[string]string{"Depth": "1"}, http.StatusMultiStatus)
+	list := rr.Body.String()
+	if !strings.Contains(list, "<D:href>/dav/stuart/picsPlus/t5.json</D:href>") || !strings.Contains(list, "<D:href>/dav/stuart/picsPlus/s-testdir1/</D:href>") || strings.Contains(list, ".versions") {
+		t.Fatalf("PROPFIND:%s", list)
+	}
+	rr = davRequest(t, h, "PROPFIND", "/dav/stuart", "", map[string]string{"Depth": "1"}, http.StatusMultiStatus)
+	if !strings.Contains(rr.Body.String(), "<D:href>/dav/stuart/picsPlus/</D:href>") {
+		t.Fatalf("PROPFIND locations:%s", rr.Body.String())
+	}
+	davRequest(t, h, "PROPFIND", base, "", map[string]string{"Depth": "infinity"}, http.StatusForbidden)
+
+	davRequest(t, h, "MKCOL", base+"davdir", "", nil, http.StatusCreated)
+	davRequest(t, h, "MKCOL", base+"davdir", "", nil, http.StatusMethodNotAllowed)
+	davRequest(t, h, "PUT", base+"davdir/a.json", "{\"a\":1}", nil, http.StatusCreated)
+	davRequest(t, h, "PUT", base+"davdir/a.json", "{\"a\":2}", nil, http.StatusNoContent)
+	davRequest(t, h, "PUT", base+"davdir/a.exe", "X", nil, http.StatusForbidden)
+	davRequest(t, h, "PUT", base+"nodir/a.json", "X", nil, http.StatusConflict)
+	rr = davRequest(t, h, "GET", base+"davdir/a.json", "", nil, http.StatusOK)
+	if rr.Body.String() != "{\"a\":2}" {
+		t.Fatalf("GET:%s", rr.Body.String())
+	}
+	// The replaced file is kept as a version but cannot be reached
+	if _, err := os.Stat(filepath.Join(dir, config.VersionsDirName)); err != nil {
+		t.Fatalf("PUT did not keep a version")
+	}
+	davRequest(t, h, "GET", base+"davdir/.versions", "", nil, http.StatusNotFound)
+	davRequest(t, h, "GET", base+"davdir/../t5.json", "", nil, http.StatusNotFound)
+
+	davRequest(t, h, "COPY", base+"davdir/a.json", "", map[string]string{"Destination": "http://localhost" + base + "davdir/b.json"}, http.StatusCreated)
+	davRequest(t, h, "MOVE", base+"davdir/b.json", "", map[string]string{"Destination": base + "davdir/a.json", "Overwrite": "F"}, http.StatusPreconditionFailed)
+	davRequest(t, h, "MOVE", base+"davdir/b.json", "", map[string]string{"Destination": base + "davdir/c.json"}, http.StatusCreated)
+	if _, err := os.Stat(filepath.Join(dir, "b.json")); err == nil {
+		t.Fatalf("MOVE left the source")
+	}
+	davRequest(t, h, "DELETE", base+"davdir/c.json", "", nil, http.StatusNoContent)
+	if entries, _ := os.ReadDir(trash); len(entries) == 0 {
+		t.Fatalf("DELETE did not use the trash")
+	}
+
+	// A directory is not moved onto its parent and an existing directory is not replaced
+	davRequest(t, h, "MKCOL", base+"davdir/sub", "", nil, http.StatusCreated)
+	davRequest(t, h, "MKCOL", base+"davdir/sub/deep", "", nil, http.StatusCreated)
+	davRequest(t, h, "PUT", base+"davdir/sub/deep/d.json", "{}", nil, http.StatusCreated)
+	davRequest(t, h, "MOVE", base+"davdir/sub/deep", "", map[string]string{"Destination": base + "davdir/sub"}, http.StatusForbidden)
+	davRequest(t, h, "COPY", base+"davdir/sub", "", map[string]string{"Destination": base + "davdir"}, http.StatusForbidden)
+	davRequest(t, h, "MKCOL", base+"davdir/other", "", nil, http.StatusCreated)
+	davRequest(t, h, "COPY", base+"davdir/other", "", map[string]string{"Destination": base + "davdir/sub"}, http.StatusPreconditionFailed)
+	davRequest(t, h, "MOVE", base+"davdir/other", "", map[string]string{"Destination": base + "davdir/sub"}, http.StatusPreconditionFailed)
+	if _, err := os.Stat(filepath.Join(dir, "sub", "deep", "d.json")); err != nil {
+		t.Fatalf("Destination directory was removed")
+	}
+
+	// Locks apply to other requests until the token is given
+	lockInfo := "<?xml version=\"1.0\"?><D:lockinfo xmlns:D=\"DAV:\"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype><D:owner>me</D:owner></D:lockinfo>"
+	rr = davRequest(t, h, "LOCK", base+"davdir/a.json", lockInfo, map[string]string{"Timeout": "Second-60"}, http.StatusOK)
+	token := rr.Header().Get("Lock-Token")
+	if !strings.HasPrefix(token, "<opaquelocktoken:") || !strings.Contains(rr.Body.String(), "<D:timeout>Second-60</D:timeout>") {
+		t.Fatalf("LOCK Token:%s Body:%s", token, rr.Body.String())
+	}
+	davRequest(t, h, "LOCK", base+"davdir/a.json", lockInfo, nil, http.StatusLocked)
+	davRequest(t, h, "PUT", base+"davdir/a.json", "X", nil, http.StatusLocked)
+	davRequest(t, h, "DELETE", base+"davdir", "", nil, http.StatusLocked)
+	davRequest(t, h, "PUT", base+"davdir/a.json", "{}", map[string]string{"If": "(" + token + ")"}, http.StatusNoContent)
+	davRequest(t, h, "LOCK", base+"davdir/a.json", "", map[string]string{"If": "(" + token + ")"}, http.StatusOK)
+	davRequest(t, h, "UNLOCK", base+"davdir/a.json", "", map[string]string{"Lock-Token": "<opaquelocktoken:x>"}, http.StatusConflict)
+	davRequest(t, h, "UNLOCK", base+"davdir/a.json", "", map[string]string{"Lock-Token": token}, http.StatusNoContent)
+	davRequest(t, h, "PUT", base+"davdir/a.json", "X", nil, http.StatusNoContent)
+
+	// Only the owner href or text is kept. Other XML is not returned to other clients
+	injected := strings.Replace(lockInfo, "<D:owner>me</D:owner>", "<D:owner><D:href>mailto:me@x</D:href><D:bad/></D:owner>", 1)
+	rr = davRequest(t, h, "LOCK", base+"davdir/a.json", injected, nil, http.StatusOK)
+	token = rr.Header().Get("Lock-Token")
+	rr = davRequest(t, h, "PROPFIND", base+"davdir/a.json", "", map[string]string{"Depth": "0"}, http.StatusMultiStatus)
+	if !strings.Contains(rr.Body.String(), "<D:owner><D:href>mailto:me@x</D:href></D:owner>") || strings.Contains(rr.Body.String(), "<D:bad/>") {
+		t.Fatalf("LOCK owner href:%s", rr.Body.String())
+	}
+	davRequest(t, h, "UNLOCK", base+"davdir/a.json", "", map[string]string{"Lock-Token": token}, http.StatusNoContent)
+	injected = strings.Replace(lockInfo, "<D:owner>me</D:owner>", "<D:owner>me &lt;x&gt;<D:bad/></D:owner>", 1)
+	rr = davRequest(t, h, "LOCK", base+"davdir/a.json", injected, nil, http.StatusOK)
+	if !strings.Contains(rr.Body.String(), "<D:owner>me &lt;x&gt;</D:owner>") || strings.Contains(rr.Body.String(), "<D:bad/>") {
+		t.Fatalf("LOCK owner text:%s", rr.Body.String())
+	}
+	davRequest(t, h, "UNLOCK", base+"davdir/a.json", "", map[string]string{"Lock-Token": rr.Header().Get("Lock-Token")}, http.StatusNoContent)
+
+	davRequest(t, h, "DELETE", base+"davdir", "", nil, http.StatusNoContent)
+	davRequest(t, h, "DELETE", base, "", nil, http.StatusForbidden)
+	davRequest(t, h, "PROPFIND", "/dav/nobody/picsPlus/", "", map[string]string{"Depth": "0"}, http.StatusNotFound)
+}
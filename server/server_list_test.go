@@ -0,0 +1,166 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testListFile struct {
+	Size int64 `json:"size"`
+	Name struct {
+		Name string `json:"name"`
+	} `json:"name"`
+	Modified     string `json:"modified"`
+	Mime         string `json:"mime"`
+	HasOriginal  *bool  `json:"hasOriginal"`
+	HasThumbnail *bool  `json:"hasThumbnail"`
+}
+
+type testList struct {
+	Files []testListFile `json:"files"`
+	Total int            `json:"total"`
+}
+
+func getList(t *testing.T, h *ServerHandler, url string) (*testList, string) {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("%s Status:%d Body:%s", url, rr.Code, rr.Body.String())
+	}
+	l := &testList{}
+	err := json.Unmarshal(rr.Body.Bytes(), l)
+	if err != nil {
+		t.Fatalf("%s Invalid json:%s", url, rr.Body.String())
+	}
+	names := []string{}
+	for _, f := range l.Files {
+		names = append(names, f.Name.Name)
+	}
+	return l, strings.Join(names, ",")
+}
+
+func TestListSortPageMeta(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+	pics := configData.GetUserData("stuart").Locations["pics"]
+	plus := configData.GetUserData("stuart").Locations["picsPlus"]
+	os.Chtimes(filepath.Join(pics, "t1.JSON"), time.Now(), time.Now().Add(-3*time.Hour))
+	os.Chtimes(filepath.Join(pics, "t2.Data"), time.Now(), time.Now().Add(-2*time.Hour))
+	os.Chtimes(filepath.Join(pics, "pic1.jpeg"), time.Now(), time.Now().Add(-1*time.Hour))
+
+	// Default is name descending, as before
+	l, names := getList(t, h, "/files/user/stuart/loc/pics")
+	if names != "t2.Data,t1.JSON,pic1.jpeg" || l.Total != 3 || l.Files[0].Modified != "" {
+		t.Fatalf("Default list:%s %+v", names, l)
+	}
+	if _, names = getList(t, h, "/files/user/stuart/loc/pics?sort=mtime&order=asc"); names != "t1.JSON,t2.Data,pic1.jpeg" {
+		t.Fatalf("mtime list:%s", names)
+	}
+	if _, names = getList(t, h, "/files/user/stuart/loc/pics?sort=size"); !strings.HasPrefix(names, "pic1.jpeg,") {
+		t.Fatalf("size list:%s", names)
+	}
+	l, names = getList(t, h, "/files/user/stuart/loc/pics?sort=name&order=asc&offset=1&limit=1")
+	if names != "t1.JSON" || l.Total != 3 {
+		t.Fatalf("Paged list:%s %+v", names, l)
+	}
+	if _, names = getList(t, h, "/files/user/stuart/loc/pics?offset=5"); names != "" {
+		t.Fatalf("Past the end:%s", names)
+	}
+
+	// A thumbnail list (pics) checks for originals in picsPlus. ThumbNailTrim is not set so names are the same
+	os.WriteFile(filepath.Join(plus, "pic1.jpeg"), []byte("x"), 0644)
+	defer os.Remove(filepath.Join(plus, "pic1.jpeg"))
+	l, _ = getList(t, h, "/files/user/stuart/loc/pics?meta=true&originals=picsPlus&thumbnails=picsPlus&order=asc")
+	f := l.Files[0]
+	if f.Name.Name != "pic1.jpeg" || f.Mime != "image/jpeg" || f.Modified == "" || f.HasOriginal == nil || !*f.HasOriginal || !*f.HasThumbnail {
+		t.Fatalf("Meta:%+v", f)
+	}
+	if *l.Files[1].HasOriginal || *l.Files[1].HasThumbnail {
+		t.Fatalf("Meta:%+v", l.Files[1])
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/files/user/stuart/loc/pics?sort=colour", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Invalid sort. Status:%d", rr.Code)
+	}
+}
+
+func TestStat(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+	file := filepath.Join(configData.GetUserData("stuart").Locations["pics"], "s-testfolder", "t5.json")
+	content, _ := os.ReadFile(file)
+	sum := sha256.Sum256(content)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/stat/user/stuart/loc/pics/path/s-testfolder/name/t5.json?sha256=true", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Stat. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	m := map[string]interface{}{}
+	err := json.Unmarshal(rr.Body.Bytes(), &m)
+	if err != nil {
+		t.Fatalf("Stat invalid json:%s", rr.Body.String())
+	}
+	if m["sha256"] != hex.EncodeToString(sum[:]) || m["size"] != float64(len(content)) || m["isDir"] != false || m["mime"] == nil || m["modified"] == nil {
+		t.Fatalf("Stat:%s", rr.Body.String())
+	}
+	if m["name"].(map[string]interface{})["name"] != "t5.json" || m["path"].(map[string]interface{})["name"] != "s-testfolder" {
+		t.Fatalf("Stat names:%s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/stat/user/stuart/loc/pics/name/s-testfolder", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "\"isDir\":true") || strings.Contains(rr.Body.String(), "sha256") {
+		t.Fatalf("Stat dir. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/stat/user/stuart/loc/pics/name/nothere.jpg", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("Stat missing. Status:%d", rr.Code)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+
+	// pic1.jpeg has no EXIF data. The size is from the JPEG frame
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/metadata/user/stuart/loc/pics/name/pic1.jpeg", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "\"exif\":{\"width\":299,\"height\":168}") {
+		t.Fatalf("Metadata. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	m := map[string]interface{}{}
+	err := json.Unmarshal(rr.Body.Bytes(), &m)
+	if err != nil || m["name"].(map[string]interface{})["name"] != "pic1.jpeg" {
+		t.Fatalf("Metadata invalid json:%s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/metadata/user/stuart/loc/pics/name/t1.JSON", nil))
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("Metadata not jpeg. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/metadata/user/stuart/loc/pics/name/nothere.jpeg", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("Metadata missing. Status:%d", rr.Code)
+	}
+
+	// Only JPEG files in a list have exif
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/files/user/stuart/loc/pics?exif=true", nil))
+	if rr.Code != http.StatusOK || strings.Count(rr.Body.String(), "\"exif\":") != 1 || !strings.Contains(rr.Body.String(), "\"exif\":{\"width\":299") {
+		t.Fatalf("List exif. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A ResponseWriter that can be read while the stream is writing to it
+type syncRecorder struct {
+	mu     sync.Mutex
+	header http.Header
+	b      bytes.Buffer
+}
+
+func (r *syncRecorder) Header() http.Header { return r.header }
+func (r *syncRecorder) WriteHeader(int)     {}
+func (r *syncRecorder) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.b.Write(b)
+}
+func (r *syncRecorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.b.String()
+}
+
+func waitForStream(t *testing.T, rec *syncRecorder, expected string) {
+	for i := 0; i < 100; i++ {
+		if strings.Contains(rec.String(), expected) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Stream does not contain '%s'\n%s", expected, rec.String())
+}
+
+func TestLogStream(t *testing.T) {
+	defer func(p time.Duration) { logStreamPoll = p }(logStreamPoll)
+	logStreamPoll = 10 * time.Millisecond
+	dir := t.TempDir()
+	var mu sync.Mutex
+	current := "day1.log"
+	currentFunc := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return current
+	}
+	os.WriteFile(filepath.Join(dir, "day1.log"), []byte("old line\n"), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rec := &syncRecorder{header: http.Header{}}
+	done := make(chan struct{})
+	go func() {
+		tailLog(ctx, nil, rec, dir, currentFunc, logCursor{name: "day1.log", offset: 9}, "keep")
+		close(done)
+	}()
+
+	f, _ := os.OpenFile(filepath.Join(dir, "day1.log"), os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("keep one\nskip two\nkeep thr")
+	waitForStream(t, rec, "id: day1.log:18\ndata: keep one\n\n")
+	f.WriteString("ee\n")
+	f.Close()
+	waitForStream(t, rec, "id: day1.log:38\ndata: keep three\n\n")
+
+	// Rollover. The new file is followed from the start
+	os.WriteFile(filepath.Join(dir, "day2.log"), []byte("keep four\n"), 0644)
+	mu.Lock()
+	current = "day2.log"
+	mu.Unlock()
+	waitForStream(t, rec, "id: day2.log:10\ndata: keep four\n\n")
+	cancel()
+	<-done
+
+	s := rec.String()
+	if strings.Contains(s, "old line") || strings.Contains(s, "skip two") {
+		t.Fatalf("Stream should start at the cursor and be filtered\n%s", s)
+	}
+
+	// Resume from an id in the previous file
+	cursor, ok := parseLogCursor("day1.log:18")
+	if !ok {
+		t.Fatalf("Failed to parse the event id")
+	}
+	var b bytes.Buffer
+	sent, more, _ := sendLogLines(&b, dir, &cursor, "")
+	if sent != 2 || more || cursor.offset != 38 {
+		t.Fatalf("Resume sent:%d more:%t offset:%d", sent, more, cursor.offset)
+	}
+	AssertContains(t, b.String(), []string{"data: skip two\n", "data: keep three\n"})
+
+	// Resume after two rollovers. The file in between is not skipped
+	os.WriteFile(filepath.Join(dir, "day3.log"), []byte("keep five\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "day2.txt"), []byte("keep not a log\n"), 0644)
+	if nextLogFile(dir, "day1.log", "day3.log") != "day2.log" || nextLogFile(dir, "day2.log", "day3.log") != "day3.log" || nextLogFile(dir, "day3.log", "day3.log") != "day3.log" {
+		t.Fatalf("Next log file is wrong")
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	rec = &syncRecorder{header: http.Header{}}
+	done = make(chan struct{})
+	go func() {
+		tailLog(ctx, nil, rec, dir, func() string { return "day3.log" }, logCursor{name: "day1.log", offset: 38}, "keep")
+		close(done)
+	}()
+	waitForStream(t, rec, "id: day3.log:10\ndata: keep five\n\n")
+	cancel()
+	<-done
+	AssertContains(t, rec.String(), []string{"id: day2.log:10\ndata: keep four\n\n"})
+	if strings.Contains(rec.String(), "not a log") {
+		t.Fatalf("Only log files should be sent\n%s", rec.String())
+	}
+
+	for _, id := range []string{"", "day1.log", "day1.log:x", "../x.log:1", ":5"} {
+		if _, ok := parseLogCursor(id); ok {
+			t.Fatalf("Event id '%s' should not be valid", id)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+func TestMetrics(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+	for _, url := range []string{"/ping", "/ping", "/files/user/stuart/loc/pics/name/t1.JSON", "/files/user/nobody/loc/pics", "/notaroute", "/not/a/route", "/exec/cat"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", url, nil))
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("FOO", "/notaroute", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BAR", "/notaroute", nil))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Metrics should return 200 not %d", rr.Code)
+	}
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("Metrics Content-Type is wrong: %s", rr.Header().Get("Content-Type"))
+	}
+	body := rr.Body.String()
+	AssertContains(t, body, []string{
+		"# TYPE gowebapp_http_requests_total counter",
+		"gowebapp_http_requests_total{route=\"/ping\",method=\"GET\",code=\"2xx\"} 2\n",
+		"gowebapp_http_requests_total{route=\"/files/user/*/loc/*\",method=\"GET\",code=\"4xx\"} 1\n",
+		"gowebapp_http_requests_total{route=\"unmatched\",method=\"GET\",code=\"4xx\"} 1\n",
+		"gowebapp_http_requests_total{route=\"static\",method=\"GET\",code=\"4xx\"} 1\n",
+		"gowebapp_http_requests_total{route=\"static\",method=\"other\",code=\"4xx\"} 2\n",
+		"# TYPE gowebapp_http_request_duration_seconds histogram",
+		"gowebapp_http_request_duration_seconds_bucket{route=\"/ping\",method=\"GET\",le=\"+Inf\"} 2\n",
+		"gowebapp_http_request_duration_seconds_count{route=\"/ping\",method=\"GET\"} 2\n",
+		"gowebapp_file_served_bytes_total{route=\"/files/user/*/loc/*/name/*\"} ",
+		"gowebapp_exec_invocations_total{exec=\"cat\",rc=\"1\"} 1\n",
+		"gowebapp_logger_queue_depth 0\n",
+		"gowebapp_long_running_processes 0\n",
+		"gowebapp_config_reloads_total 0\n",
+		"go_goroutines ",
+	})
+	if strings.Contains(body, "/metrics") {
+		t.Fatalf("The metrics request should be counted after it is written")
+	}
+}
+
+func TestMetricsAdminOnly(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	hash, _ := config.HashPassword("secret")
+	// Roles are enforced when the admin user has a password
+	configData.ConfigFileData.Users[config.AdminUserName] = config.UserData{Name: "Admin", Home: "stuart", Locations: map[string]string{}, Env: map[string]string{}, PasswordHash: hash}
+	bob := configData.ConfigFileData.Users["bob"]
+	bob.PasswordHash = hash
+	configData.ConfigFileData.Users["bob"] = bob
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+	for user, status := range map[string]int{"": http.StatusUnauthorized, "bob": http.StatusForbidden, config.AdminUserName: http.StatusOK} {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		if user != "" {
+			r.SetBasicAuth(user, "secret")
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		if rr.Code != status {
+			t.Fatalf("Metrics as '%s'. Status:%d expected %d", user, rr.Code, status)
+		}
+	}
+}
+
+func TestMetricsLabels(t *testing.T) {
+	l := labels("route", "a\"b\\c\nd", "method", "GET")
+	if l != "{route=\"a\\\"b\\\\c\\nd\",method=\"GET\"}" {
+		t.Fatalf("Labels not escaped: %s", l)
+	}
+	if statusClass(404) != "4xx" || statusClass(0) != "other" {
+		t.Fatalf("Status class is wrong")
+	}
+	if methodLabel("PROPFIND") != "PROPFIND" || methodLabel("GET") != "GET" || methodLabel("XYZ123") != "other" || methodLabel("get") != "other" {
+		t.Fatalf("Method label is wrong")
+	}
+}
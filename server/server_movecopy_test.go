@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+func moveCopy(h *ServerHandler, method string, url string, dest string, overwrite string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(method, url, nil)
+	if dest != "" {
+		req.Header.Set("Destination", dest)
+	}
+	if overwrite != "" {
+		req.Header.Set("Overwrite", overwrite)
+	}
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestMoveCopy(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	configData.ConfigFileData.KeepVersions = map[string]int{"usr": 2}
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+	plus := configData.GetUserData("stuart").Locations["picsPlus"]
+	usr := configData.GetUserData("stuart").Locations["usr"]
+	src := filepath.Join(plus, "mc-src.txt")
+	copied := filepath.Join(usr, "mc copy.txt")
+	moved := filepath.Join(usr, "mc-moved.txt")
+	for _, f := range []string{src, copied, moved} {
+		os.Remove(f)
+		defer os.Remove(f)
+	}
+	versions := filepath.Join(usr, config.VersionsDirName)
+	os.RemoveAll(versions)
+	defer os.RemoveAll(versions)
+	os.WriteFile(src, []byte("source"), 0644)
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(src, past, past)
+
+	// Destination name is X0X encoded
+	encName := "X0X" + base64.StdEncoding.EncodeToString([]byte("mc copy.txt"))
+	rr := moveCopy(h, "COPY", "/files/user/stuart/loc/picsPlus/name/mc-src.txt", "http://localhost/files/user/stuart/loc/usr/name/"+encName, "")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Copy. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	assertFileContent(t, copied, "source")
+	st, _ := os.Stat(copied)
+	if !st.ModTime().Equal(past) {
+		t.Fatalf("Copy should keep the modified time")
+	}
+	assertFileContent(t, src, "source")
+
+	// Fail if exists unless Overwrite is T
+	os.WriteFile(src, []byte("source2"), 0644)
+	rr = moveCopy(h, "COPY", "/files/user/stuart/loc/picsPlus/name/mc-src.txt", "/files/user/stuart/loc/usr/name/"+encName, "F")
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("Copy exists. Status:%d", rr.Code)
+	}
+	assertFileContent(t, copied, "source")
+	rr = moveCopy(h, "COPY", "/files/user/stuart/loc/picsPlus/name/mc-src.txt", "/files/user/stuart/loc/usr/name/"+encName, "T")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Copy overwrite. Status:%d", rr.Code)
+	}
+	assertFileContent(t, copied, "source2")
+	// The overwritten file is kept as a version
+	kept, _ := os.ReadDir(filepath.Join(versions, "mc copy.txt"))
+	if len(kept) != 1 {
+		t.Fatalf("Overwrite should keep a version. Found %d", len(kept))
+	}
+	assertFileContent(t, filepath.Join(versions, "mc copy.txt", kept[0].Name()), "source")
+
+	// Move to another location using a path
+	rr = moveCopy(h, "MOVE", "/files/user/stuart/loc/pics/path/s-testfolder/name/mc-src.txt", "/files/user/stuart/loc/usr/name/mc-moved.txt", "")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Move. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	assertFileContent(t, moved, "source2")
+	if _, err := os.Stat(src); err == nil {
+		t.Fatalf("Move should remove the source")
+	}
+	rr = moveCopy(h, "MOVE", "/files/user/stuart/loc/picsPlus/name/mc-src.txt", "/files/user/stuart/loc/usr/name/x.txt", "")
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("Move missing source. Status:%d", rr.Code)
+	}
+
+	// Destination is required and must be a file url
+	rr = moveCopy(h, "MOVE", "/files/user/stuart/loc/usr/name/mc-moved.txt", "", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("No Destination. Status:%d", rr.Code)
+	}
+	rr = moveCopy(h, "MOVE", "/files/user/stuart/loc/usr/name/mc-moved.txt", "/server/status", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Bad Destination. Status:%d", rr.Code)
+	}
+	rr = moveCopy(h, "MOVE", "/files/user/stuart/loc/usr/name/mc-moved.txt", "/files/user/stuart/loc/nowhere/name/x.txt", "")
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("Unknown loc. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	assertFileContent(t, moved, "source2")
+}
+
+func assertFileContent(t *testing.T, file string, expected string) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %s", file, err.Error())
+	}
+	if string(b) != expected {
+		t.Fatalf("File %s content '%s' expected '%s'", file, string(b), expected)
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func pathRequest(h *ServerHandler, method string, url string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(method, url, nil))
+	return rr
+}
+
+func TestCreateDeletePath(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+	plus := configData.GetUserData("stuart").Locations["picsPlus"]
+	top := filepath.Join(plus, "album-test")
+	os.RemoveAll(top)
+	defer os.RemoveAll(top)
+
+	enc := "X0X" + base64.StdEncoding.EncodeToString([]byte("album-test/2024 summer/day1"))
+	rr := pathRequest(h, "POST", "/paths/user/stuart/loc/picsPlus/path/"+enc)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Create. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	st, err := os.Stat(filepath.Join(top, "2024 summer", "day1"))
+	if err != nil || !st.IsDir() {
+		t.Fatalf("Nested directory was not created")
+	}
+	rr = pathRequest(h, "POST", "/paths/user/stuart/loc/picsPlus/path/"+enc)
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("Create existing. Status:%d", rr.Code)
+	}
+
+	// Not empty so recursive is required
+	rr = pathRequest(h, "DELETE", "/paths/user/stuart/loc/picsPlus/path/album-test")
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("Delete not empty. Status:%d", rr.Code)
+	}
+	rr = pathRequest(h, "DELETE", "/paths/user/stuart/loc/picsPlus/path/"+enc)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Delete empty. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	os.WriteFile(filepath.Join(top, "2024 summer", "a.txt"), []byte("a"), 0644)
+	rr = pathRequest(h, "DELETE", "/paths/user/stuart/loc/picsPlus/path/album-test?recursive=true")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Delete recursive. Status:%d", rr.Code)
+	}
+	if _, err := os.Stat(top); err == nil {
+		t.Fatalf("Directory was not deleted")
+	}
+	rr = pathRequest(h, "DELETE", "/paths/user/stuart/loc/picsPlus/path/album-test")
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("Delete missing. Status:%d", rr.Code)
+	}
+
+	// Cannot leave the location or remove the location itself
+	for _, p := range []string{"..", ".", "X0X" + base64.StdEncoding.EncodeToString([]byte("a/../../x"))} {
+		rr = pathRequest(h, "DELETE", "/paths/user/stuart/loc/picsPlus/path/"+p+"?recursive=true")
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("Delete '%s'. Status:%d", p, rr.Code)
+		}
+	}
+	if _, err := os.Stat(plus); err != nil {
+		t.Fatalf("Location was deleted")
+	}
+	os.WriteFile(top, []byte("file"), 0644)
+	rr = pathRequest(h, "DELETE", "/paths/user/stuart/loc/picsPlus/path/album-test")
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Delete file. Status:%d", rr.Code)
+	}
+}
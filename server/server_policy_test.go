@@ -0,0 +1,124 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+func TestLocationPolicy(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+	stuart := configData.ConfigFileData.Users["stuart"]
+	stuart.Policies = map[string]*config.LocationPolicy{
+		"pics": {ReadOnly: true, Readers: []string{"bob"}, FilterFiles: []string{".jpeg"}},
+		"usr":  {Allow: []string{config.OpRead, config.OpWrite}},
+	}
+	configData.ConfigFileData.Users["stuart"] = stuart
+	usr := stuart.Locations["usr"]
+	copied := filepath.Join(usr, "policy.jpeg")
+	os.Remove(copied)
+	defer os.Remove(copied)
+
+	request := func(method string, url string, headers map[string]string, status int) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(method, url, strings.NewReader("{}"))
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		h.ServeHTTP(rr, r)
+		if rr.Code != status {
+			t.Fatalf("%s %s Status:%d expected %d Body:%s", method, url, rr.Code, status, rr.Body.String())
+		}
+		return rr
+	}
+
+	// Read only. Only .jpeg files are listed
+	request("GET", "/files/user/stuart/loc/pics/name/pic1.jpeg", nil, http.StatusOK)
+	rr := request("GET", "/files/user/stuart/loc/pics", nil, http.StatusOK)
+	if !strings.Contains(rr.Body.String(), "pic1.jpeg") || strings.Contains(rr.Body.String(), "t1.JSON") {
+		t.Fatalf("Location FilterFiles:%s", rr.Body.String())
+	}
+	request("POST", "/files/user/stuart/loc/pics/name/new.json", nil, http.StatusForbidden)
+	request("DELETE", "/files/user/stuart/loc/pics/name/pic1.jpeg", nil, http.StatusForbidden)
+	request("POST", "/paths/user/stuart/loc/pics/path/newdir", nil, http.StatusForbidden)
+	request("MOVE", "/files/user/stuart/loc/pics/name/pic1.jpeg", map[string]string{"Destination": "/files/user/stuart/loc/usr/name/policy.jpeg"}, http.StatusForbidden)
+	request("PUT", "/dav/stuart/pics/new.jpeg", nil, http.StatusForbidden)
+	request("PROPFIND", "/dav/stuart/pics/", map[string]string{"Depth": "1"}, http.StatusMultiStatus)
+	if _, err := os.Stat(filepath.Join(stuart.Locations["pics"], "pic1.jpeg")); err != nil {
+		t.Fatalf("Read only file was changed")
+	}
+
+	// The read only location cannot be changed through another location or a traversal
+	request("POST", "/files/user/stuart/loc/usr/path/"+encodeValue("../s-pics")+"/name/new.json", nil, http.StatusForbidden)
+	request("POST", "/files/user/stuart/loc/home/path/s-pics/name/new.json", nil, http.StatusForbidden)
+	request("POST", "/files/user/stuart/loc/home/name/"+encodeValue("s-pics/new.json"), nil, http.StatusForbidden)
+	request("POST", "/files/user/stuart/loc/picsPlus/name/new.json", nil, http.StatusForbidden)
+	request("DELETE", "/files/user/stuart/loc/home/path/s-pics/name/pic1.jpeg", nil, http.StatusForbidden)
+	request("DELETE", "/paths/user/stuart/loc/home/path/s-pics", nil, http.StatusForbidden)
+	request("MOVE", "/files/user/stuart/loc/home/path/s-pics/name/pic1.jpeg", map[string]string{"Destination": "/files/user/stuart/loc/usr/name/policy.jpeg"}, http.StatusForbidden)
+	request("PUT", "/dav/stuart/home/s-pics/new.jpeg", nil, http.StatusForbidden)
+	request("GET", "/files/user/stuart/loc/home/path/s-pics/name/pic1.jpeg", nil, http.StatusOK)
+	if _, err := os.Stat(filepath.Join(stuart.Locations["pics"], "new.json")); err == nil {
+		t.Fatalf("File was written to a read only location")
+	}
+
+	// Copy reads from pics and writes to usr. Delete is not allowed in usr
+	request("COPY", "/files/user/stuart/loc/pics/name/pic1.jpeg", map[string]string{"Destination": "/files/user/stuart/loc/usr/name/policy.jpeg"}, http.StatusAccepted)
+	request("DELETE", "/files/user/stuart/loc/usr/name/policy.jpeg", nil, http.StatusForbidden)
+
+	// Other users can be readers when the user has a password
+	hash, _ := config.HashPassword("secret")
+	stuart.PasswordHash = hash
+	configData.ConfigFileData.Users["stuart"] = stuart
+	bob := configData.ConfigFileData.Users["bob"]
+	bob.PasswordHash = hash
+	configData.ConfigFileData.Users["bob"] = bob
+	basic := func(user string) map[string]string {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.SetBasicAuth(user, "secret")
+		return map[string]string{"Authorization": r.Header.Get("Authorization")}
+	}
+	request("GET", "/files/user/stuart/loc/pics/name/pic1.jpeg", basic("bob"), http.StatusOK)
+	request("GET", "/files/user/stuart/loc/pics", basic("bob"), http.StatusOK)
+	request("GET", "/files/user/stuart/loc/usr", basic("bob"), http.StatusForbidden)
+	request("GET", "/files/user/stuart/loc/pics", nil, http.StatusUnauthorized)
+	rr = request("PROPFIND", "/dav/stuart/", map[string]string{"Depth": "1", "Authorization": basic("bob")["Authorization"]}, http.StatusMultiStatus)
+	if !strings.Contains(rr.Body.String(), "/dav/stuart/pics/") || strings.Contains(rr.Body.String(), "/dav/stuart/usr/") {
+		t.Fatalf("DAV locations for a reader:%s", rr.Body.String())
+	}
+}
+
+func TestLocationPolicyNested(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+	stuart := configData.ConfigFileData.Users["stuart"]
+	parent := filepath.Join(stuart.Locations["home"], "nest")
+	inner := filepath.Join(parent, "original")
+	os.MkdirAll(inner, 0755)
+	defer os.RemoveAll(parent)
+	os.WriteFile(filepath.Join(inner, "keep.jpeg"), []byte("keep"), 0644)
+	stuart.Locations["original"] = inner
+	stuart.Policies = map[string]*config.LocationPolicy{"original": {ReadOnly: true}}
+	configData.ConfigFileData.Users["stuart"] = stuart
+
+	// A parent of a read only location cannot be deleted or moved
+	davRequest(t, h, "DELETE", "/paths/user/stuart/loc/home/path/nest?recursive=true", "", nil, http.StatusForbidden)
+	davRequest(t, h, "DELETE", "/dav/stuart/home/nest", "", nil, http.StatusForbidden)
+	davRequest(t, h, "MOVE", "/dav/stuart/home/nest", "", map[string]string{"Destination": "/dav/stuart/home/moved"}, http.StatusForbidden)
+	if _, err := os.Stat(filepath.Join(inner, "keep.jpeg")); err != nil {
+		t.Fatalf("Read only location was removed")
+	}
+
+	// Without the policy it can be deleted
+	stuart.Policies = nil
+	configData.ConfigFileData.Users["stuart"] = stuart
+	davRequest(t, h, "DELETE", "/paths/user/stuart/loc/home/path/nest?recursive=true", "", nil, http.StatusAccepted)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+func TestConfigReloadOnChange(t *testing.T) {
+	_, name := UpdateConfigAndLoad(t, func(cdff *config.ConfigDataFromFile) {
+		cdff.ServerName = "ReloadOne"
+		cdff.ReloadConfigSeconds = 1
+	}, nil, false)
+	defer os.Remove(name)
+	errList := config.NewConfigErrorData()
+	configData := config.NewConfigData(name, "goWebApp", false, false, false, errList)
+	if configData == nil || errList.ErrorCount() > 1 {
+		t.Fatal(errList.String())
+	}
+	log := &TLog{}
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, log, time.Now())
+	if h.publisher.filesChanged() {
+		t.Fatalf("Files should not have changed")
+	}
+
+	// A request holds its snapshot while the config is replaced
+	snapshot := h.forRequest()
+
+	UpdateConfigAndLoad(t, func(cdff *config.ConfigDataFromFile) {
+		cdff.ServerName = "ReloadTwo"
+		cdff.ReloadConfigSeconds = 1
+		delete(cdff.Users["stuart"].Locations, "picsMissing") // Any config error prevents a reload
+	}, nil, false)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(name, future, future)
+	if !h.publisher.filesChanged() {
+		t.Fatalf("Files should have changed")
+	}
+	err := h.ReloadConfig("on file change")
+	if err != nil {
+		t.Fatalf("Reload failed: %s", err.Error())
+	}
+	if h.Config().GetServerName() != "ReloadTwo" {
+		t.Fatalf("Config should have been reloaded. ServerName:%s", h.Config().GetServerName())
+	}
+	if snapshot.config.GetServerName() != "ReloadOne" {
+		t.Fatalf("Request snapshot should not change")
+	}
+	if h.publisher.filesChanged() {
+		t.Fatalf("Files should not have changed after reload")
+	}
+
+	// An invalid config is not published
+	os.WriteFile(name, []byte("{ Not JSON"), 0644)
+	err = h.ReloadConfig("on file change")
+	if err == nil {
+		t.Fatalf("Reload should fail for invalid config")
+	}
+	if h.Config().GetServerName() != "ReloadTwo" {
+		t.Fatalf("Previous config should be retained")
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/server/status", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Status should return 200 not %d", rr.Code)
+	}
+	AssertContains(t, rr.Body.String(), []string{"\"ConfigReload\":{", "\"IntervalSeconds\":1", "\"Reloads\":1", "\"Failures\":1", "\"LastReason\":\"on file change\""})
+	if rr.Header().Get("Server") != "ReloadTwo" {
+		t.Fatalf("Response should use the reloaded config")
+	}
+	AssertLogContains(t, log, []string{"file reload on file change!"})
+	if !strings.Contains(h.publisher.statusJson(), "Failed to understand the config data") {
+		t.Fatalf("Status should contain the last error. %s", h.publisher.statusJson())
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bytes"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+func getImage(t *testing.T, h *ServerHandler, url string, mimeType string) image.Image {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != mimeType {
+		t.Fatalf("%s Status:%d Type:%s Body:%s", url, rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+	img, format, err := image.Decode(bytes.NewReader(rr.Body.Bytes()))
+	if err != nil || "image/"+format != mimeType {
+		t.Fatalf("%s is not a %s image. %v", url, mimeType, err)
+	}
+	return img
+}
+
+func TestResize(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	cache := t.TempDir()
+	configData.ConfigFileData.ImageCache = &config.ImageCacheData{Path: cache}
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+	url := "/files/user/stuart/loc/pics/name/pic1.jpeg"
+
+	// pic1.jpeg is 299x168
+	for _, tc := range []struct {
+		query string
+		mime  string
+		w, h  int
+	}{
+		{"?w=100", "image/jpeg", 100, 56},
+		{"?h=84", "image/jpeg", 150, 84},
+		{"?w=100&h=100", "image/jpeg", 100, 56},
+		{"?w=100&h=100&fit=cover", "image/jpeg", 100, 100},
+		{"?w=50&h=80&fit=fill", "image/jpeg", 50, 80},
+		{"?w=1000", "image/jpeg", 299, 168}, // Not enlarged
+		{"?format=png", "image/png", 299, 168},
+	} {
+		img := getImage(t, h, url+tc.query, tc.mime)
+		if img.Bounds().Dx() != tc.w || img.Bounds().Dy() != tc.h {
+			t.Fatalf("%s size is %v", tc.query, img.Bounds())
+		}
+	}
+	entries, _ := os.ReadDir(cache)
+	if len(entries) != 7 {
+		t.Fatalf("Cache has %d entries", len(entries))
+	}
+	// Served from the cache
+	getImage(t, h, url+"?w=100", "image/jpeg")
+	entries, _ = os.ReadDir(cache)
+	if len(entries) != 7 {
+		t.Fatalf("Cache has %d entries after a repeat", len(entries))
+	}
+
+	// Without the queries the original is sent
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+	if rr.Code != http.StatusOK || rr.Body.Len() != 4821 {
+		t.Fatalf("Original. Status:%d Len:%d", rr.Code, rr.Body.Len())
+	}
+
+	for _, tc := range []struct {
+		url    string
+		status int
+	}{
+		{url + "?w=0", http.StatusBadRequest},
+		{url + "?w=abc", http.StatusBadRequest},
+		{url + "?fit=stretch", http.StatusBadRequest},
+		{url + "?format=gif", http.StatusBadRequest},
+		{"/files/user/stuart/loc/pics/name/t1.JSON?w=100", http.StatusUnsupportedMediaType},
+		{"/files/user/stuart/loc/pics/name/nothere.jpeg?w=100", http.StatusNotFound},
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", tc.url, nil))
+		if rr.Code != tc.status {
+			t.Fatalf("%s Status:%d expected %d Body:%s", tc.url, rr.Code, tc.status, rr.Body.String())
+		}
+	}
+}
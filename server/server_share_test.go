@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+func shareRequest(t *testing.T, h *ServerHandler, method string, url string, status int) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(method, url, nil))
+	if rr.Code != status {
+		t.Fatalf("%s %s Status:%d expected %d Body:%s", method, url, rr.Code, status, rr.Body.String())
+	}
+	return rr
+}
+
+func TestShare(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	configData.ConfigFileData.Shares = &config.ShareData{Key: "testKey"}
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+	sf := configData.GetUserSharesFile("stuart")
+	os.Remove(sf)
+	defer os.Remove(sf)
+	legacy := filepath.Join(configData.GetUserRoot("stuart"), config.LegacySharesFileName)
+	defer os.Remove(legacy)
+
+	rr := shareRequest(t, h, "POST", "/share/user/stuart/loc/pics/name/pic1.jpeg?hours=2&downloads=2", http.StatusCreated)
+	link := map[string]interface{}{}
+	json.Unmarshal(rr.Body.Bytes(), &link)
+	url, _ := link["url"].(string)
+	if link["name"] != "pic1.jpeg" || link["loc"] != "pics" || link["maxDownloads"] != 2.0 || len(url) < 20 {
+		t.Fatalf("Share:%v", link)
+	}
+
+	rr = shareRequest(t, h, "GET", url, http.StatusOK)
+	if rr.Body.Len() != 4821 || rr.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("Shared file Len:%d Type:%s", rr.Body.Len(), rr.Header().Get("Content-Type"))
+	}
+	// A later part of the file is not another download
+	r := httptest.NewRequest("GET", url, nil)
+	r.Header.Set("Range", "bytes=100-")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusPartialContent {
+		t.Fatalf("Range Status:%d", rr.Code)
+	}
+	shareRequest(t, h, "GET", url, http.StatusOK)
+	shareRequest(t, h, "GET", url, http.StatusGone)
+	shareRequest(t, h, "GET", url+"x", http.StatusNotFound)
+	shareRequest(t, h, "GET", "/share/abc", http.StatusNotFound)
+
+	rr = shareRequest(t, h, "POST", "/share/user/stuart/loc/pics/path/s-testfolder/name/t5.json", http.StatusCreated)
+	json.Unmarshal(rr.Body.Bytes(), &link)
+	url2 := link["url"].(string)
+	id2 := link["id"].(string)
+	if link["path"] != "s-testfolder" || link["maxDownloads"] != 0.0 {
+		t.Fatalf("Share with path:%v", link)
+	}
+	shareRequest(t, h, "GET", url2, http.StatusOK)
+
+	shareRequest(t, h, "POST", "/share/user/stuart/loc/pics/name/pic1.jpeg?hours=10000", http.StatusBadRequest)
+	shareRequest(t, h, "POST", "/share/user/stuart/loc/pics/name/nothere.jpeg", http.StatusNotFound)
+	shareRequest(t, h, "POST", "/share/user/stuart/loc/pics/name/s-testfolder", http.StatusForbidden)
+
+	list := struct {
+		Links []struct {
+			Id        string `json:"id"`
+			Url       string `json:"url"`
+			Downloads int    `json:"downloads"`
+		} `json:"links"`
+	}{}
+	rr = shareRequest(t, h, "GET", "/server/shares", http.StatusOK)
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list.Links) != 2 || list.Links[0].Id != id2 || list.Links[0].Url != url2 || list.Links[1].Downloads != 2 {
+		t.Fatalf("Share list:%s", rr.Body.String())
+	}
+
+	shareRequest(t, h, "DELETE", "/server/shares/"+id2, http.StatusAccepted)
+	shareRequest(t, h, "GET", url2, http.StatusNotFound)
+	shareRequest(t, h, "DELETE", "/server/shares/"+id2, http.StatusNotFound)
+
+	// The links are not in a location. A file from an earlier version is moved out of Home
+	if config.PathInLocation(configData.GetUserRoot("stuart"), sf) {
+		t.Fatalf("Share file %s is in the users Home", sf)
+	}
+	os.Rename(sf, legacy)
+	rr = shareRequest(t, h, "GET", "/server/shares", http.StatusOK)
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list.Links) != 1 {
+		t.Fatalf("Share list after move:%s", rr.Body.String())
+	}
+	if _, err := os.Stat(legacy); err == nil {
+		t.Fatalf("Share file %s was not moved", legacy)
+	}
+	shareRequest(t, h, "GET", "/files/user/stuart/loc/home/name/"+config.LegacySharesFileName, http.StatusNotFound)
+
+	// A missing file does not use up a download
+	plus := configData.GetUserData("stuart").Locations["picsPlus"]
+	once := filepath.Join(plus, "share-once.txt")
+	os.WriteFile(once, []byte("once"), 0644)
+	defer os.Remove(once)
+	rr = shareRequest(t, h, "POST", "/share/user/stuart/loc/picsPlus/name/share-once.txt?downloads=1", http.StatusCreated)
+	json.Unmarshal(rr.Body.Bytes(), &link)
+	onceUrl := link["url"].(string)
+	os.Rename(once, once+".x")
+	shareRequest(t, h, "GET", onceUrl, http.StatusNotFound)
+	os.Rename(once+".x", once)
+	shareRequest(t, h, "GET", onceUrl, http.StatusOK)
+	shareRequest(t, h, "GET", onceUrl, http.StatusGone)
+
+	// A policy added after the link was made applies. Here to a location nested in Home
+	rr = shareRequest(t, h, "POST", "/share/user/stuart/loc/home/path/s-pics/name/pic1.jpeg", http.StatusCreated)
+	json.Unmarshal(rr.Body.Bytes(), &link)
+	stuart := configData.ConfigFileData.Users["stuart"]
+	stuart.Policies = map[string]*config.LocationPolicy{"pics": {Allow: []string{config.OpWrite}}}
+	configData.ConfigFileData.Users["stuart"] = stuart
+	shareRequest(t, h, "GET", link["url"].(string), http.StatusForbidden)
+	stuart.Policies = nil
+	configData.ConfigFileData.Users["stuart"] = stuart
+	shareRequest(t, h, "GET", link["url"].(string), http.StatusOK)
+
+	// A new key ends all links
+	rr = shareRequest(t, h, "POST", "/share/user/stuart/loc/pics/name/pic1.jpeg", http.StatusCreated)
+	json.Unmarshal(rr.Body.Bytes(), &link)
+	configData.ConfigFileData.Shares.Key = "newKey"
+	shareRequest(t, h, "GET", link["url"].(string), http.StatusNotFound)
+}
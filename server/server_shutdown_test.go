@@ -0,0 +1,87 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSignalToAction(t *testing.T) {
+	a := SignalToAction(syscall.SIGTERM)
+	if a.Id != Exit || a.Rc != 0 {
+		t.Fatalf("SIGTERM should Exit with rc 0. %s", a)
+	}
+	a = SignalToAction(syscall.SIGINT)
+	if a.Id != Exit {
+		t.Fatalf("SIGINT should Exit. %s", a)
+	}
+	a = SignalToAction(syscall.SIGHUP)
+	if a.Id != Reload {
+		t.Fatalf("SIGHUP should Reload. %s", a)
+	}
+	a = SignalToAction(syscall.SIGUSR1)
+	if a.Id != Ignore {
+		t.Fatalf("SIGUSR1 should be ignored. %s", a)
+	}
+}
+
+func TestCloseDrainsRequests(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	was, err := NewWebAppServer(configData, make(chan *ActionEvent, 10), nil, &TLog{})
+	if err != nil {
+		t.Fatalf("NewWebAppServer failed: %s", err.Error())
+	}
+	was.Server.Addr = "127.0.0.1:18093"
+	was.Server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+	started := make(chan int)
+	go func() {
+		started <- was.Start()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	type result struct {
+		body string
+		err  error
+	}
+	resp := make(chan result)
+	go func() {
+		res, err := http.Get("http://127.0.0.1:18093/slow")
+		if err != nil {
+			resp <- result{err: err}
+			return
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		resp <- result{body: string(b), err: err}
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	begin := time.Now()
+	rc := was.Close(7)
+	if time.Since(begin) < 100*time.Millisecond {
+		t.Fatalf("Close should wait for the in-flight request")
+	}
+	if rc != 7 {
+		t.Fatalf("Close should return rc 7 not %d", rc)
+	}
+	r := <-resp
+	if r.err != nil || r.body != "done" {
+		t.Fatalf("In-flight request should complete. Body:%s Error:%v", r.body, r.err)
+	}
+	if was.Close(9) != 7 {
+		t.Fatalf("Second Close should return the first rc")
+	}
+	select {
+	case rc = <-started:
+		if rc != 7 {
+			t.Fatalf("Start should return rc 7 not %d", rc)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Start did not return after Close")
+	}
+}
@@ -6,12 +6,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -29,29 +33,34 @@ const thumbnailTrimSuffix = 4
 type TLog struct {
 	B       bytes.Buffer
 	RSCount int
+	mu      sync.Mutex // The server logs from its own goroutines
 }
 
 func (l *TLog) Close() {}
 func (l *TLog) Log(s string) {
+	l.mu.Lock()
 	l.B.WriteString("LOG: ")
 	l.B.WriteString(s)
 	l.B.WriteString("\n")
+	l.mu.Unlock()
 	os.Stdout.WriteString("LOG: ")
 	os.Stdout.WriteString(s)
 	os.Stdout.WriteString("\n")
 }
 
 func (l *TLog) LogVerbose(s string) {
+	l.mu.Lock()
 	l.B.WriteString("VERBOSE: ")
 	l.B.WriteString(s)
 	l.B.WriteString("\n")
+	l.mu.Unlock()
 	os.Stdout.WriteString("VERBOSE: ")
 	os.Stdout.WriteString(s)
 	os.Stdout.WriteString("\n")
 }
 
 func WriteLogToFile(path string) {
-	os.WriteFile(filepath.Join(path, "TLog.log"), logger.B.Bytes(), 0644)
+	os.WriteFile(filepath.Join(path, "TLog.log"), []byte(logger.Get()), 0644)
 }
 
 func (l *TLog) VerboseFunction() func(string) {
@@ -59,6 +68,8 @@ func (l *TLog) VerboseFunction() func(string) {
 }
 
 func (l *TLog) Get() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	return l.B.String()
 }
 func (l *TLog) IsOpen() bool {
@@ -67,13 +78,18 @@ func (l *TLog) IsOpen() bool {
 func (l *TLog) LogFileName() string {
 	return "DummyLogger.log"
 }
+func (l *TLog) QueueDepth() int {
+	return 0
+}
 func (l *TLog) Reset() {
+	l.mu.Lock()
 	if l.B.Len() == 0 {
 		l.RSCount = 0
 	} else {
 		l.RSCount = l.RSCount + 1
 	}
 	l.B.Truncate(0)
+	l.mu.Unlock()
 	l.Log(fmt.Sprintf("Log-Reset:%d", l.RSCount))
 }
 
@@ -88,27 +104,54 @@ const testPropertyFile = "../userProperties.json"
 
 func TestUrlRequestParamsMap(t *testing.T) {
 	rootUrlList := NewRootUrlList()
-	AssertMatch(t, "0", rootUrlList.AddUrlRequestMatcher("/a/b/*/c/*", "get", true), "/x/b/1/c/4", "GET", false, "")
-	AssertMatch(t, "1", rootUrlList.AddUrlRequestMatcher("/a/b/*/c/*", "get", true), "/a/b/1/x/4", "GET", false, "b=1")
-	AssertMatch(t, "2", rootUrlList.AddUrlRequestMatcher("/a/b/*/c/*", "get", true), "/a/b/1/c", "GET", false, "")
-	AssertMatch(t, "3", rootUrlList.AddUrlRequestMatcher("/a/b/*/c/*", "get", true), "/a/b/1/c/3", "GET", true, "b=1,c=3")
-	AssertMatch(t, "4", rootUrlList.AddUrlRequestMatcher("a", "get", true), "/a", "get", true, "")
-	AssertMatch(t, "5", rootUrlList.AddUrlRequestMatcher("a", "get", true), "a", "get", true, "")
-	AssertMatch(t, "5", rootUrlList.AddUrlRequestMatcher("/a", "get", true), "/a", "get", true, "")
-	AssertMatch(t, "6", rootUrlList.AddUrlRequestMatcher("/a/b/*/*/c/*", "get", true), "/a/b/1/2/c/3", "post", false, "")
-	AssertMatch(t, "7", rootUrlList.AddUrlRequestMatcher("/a/b/*/*/c/*", "get", true), "/a/b/1/2/C/3", "GET", false, "b=1")
-	AssertMatch(t, "8", rootUrlList.AddUrlRequestMatcher("/a/b/*/*/c/*", "get", true), "/a/b/1/2/c/3", "get", true, "b=1,c=3")
-	AssertMatch(t, "9", rootUrlList.AddUrlRequestMatcher("/a/b/*/*/c/*", "get", true), "/a/b/1/2/c/3", "GET", true, "b=1,c=3")
-	AssertMatch(t, "10", rootUrlList.AddUrlRequestMatcher("/a/*/b/*/c/*", "get", true), "/a/1/b/2/c/3", "GET", true, "a=1,b=2,c=3")
-	AssertMatch(t, "10", rootUrlList.AddUrlRequestMatcher("", "get", true), "/a/1/b/2/c/3", "GET", false, "")
-	AssertMatch(t, "11", rootUrlList.AddUrlRequestMatcher("", "get", true), "", "GET", false, "")
-	AssertMatch(t, "12", rootUrlList.AddUrlRequestMatcher("", "post", true), "", "GET", false, "")
-	rootUrlList.AddUrlRequestMatcher("b", "post", true)
+	AssertMatch(t, "0", rootUrlList.AddUrlRequestMatcher("/a/b/*/c/*", "get", true, config.RoleGuest), "/x/b/1/c/4", "GET", false, "")
+	AssertMatch(t, "1", rootUrlList.AddUrlRequestMatcher("/a/b/*/c/*", "get", true, config.RoleGuest), "/a/b/1/x/4", "GET", false, "b=1")
+	AssertMatch(t, "2", rootUrlList.AddUrlRequestMatcher("/a/b/*/c/*", "get", true, config.RoleGuest), "/a/b/1/c", "GET", false, "")
+	AssertMatch(t, "3", rootUrlList.AddUrlRequestMatcher("/a/b/*/c/*", "get", true, config.RoleGuest), "/a/b/1/c/3", "GET", true, "b=1,c=3")
+	AssertMatch(t, "4", rootUrlList.AddUrlRequestMatcher("a", "get", true, config.RoleGuest), "/a", "get", true, "")
+	AssertMatch(t, "5", rootUrlList.AddUrlRequestMatcher("a", "get", true, config.RoleGuest), "a", "get", true, "")
+	AssertMatch(t, "5", rootUrlList.AddUrlRequestMatcher("/a", "get", true, config.RoleGuest), "/a", "get", true, "")
+	AssertMatch(t, "6", rootUrlList.AddUrlRequestMatcher("/a/b/*/*/c/*", "get", true, config.RoleGuest), "/a/b/1/2/c/3", "post", false, "")
+	AssertMatch(t, "7", rootUrlList.AddUrlRequestMatcher("/a/b/*/*/c/*", "get", true, config.RoleGuest), "/a/b/1/2/C/3", "GET", false, "b=1")
+	AssertMatch(t, "8", rootUrlList.AddUrlRequestMatcher("/a/b/*/*/c/*", "get", true, config.RoleGuest), "/a/b/1/2/c/3", "get", true, "b=1,c=3")
+	AssertMatch(t, "9", rootUrlList.AddUrlRequestMatcher("/a/b/*/*/c/*", "get", true, config.RoleGuest), "/a/b/1/2/c/3", "GET", true, "b=1,c=3")
+	AssertMatch(t, "10", rootUrlList.AddUrlRequestMatcher("/a/*/b/*/c/*", "get", true, config.RoleGuest), "/a/1/b/2/c/3", "GET", true, "a=1,b=2,c=3")
+	AssertMatch(t, "10", rootUrlList.AddUrlRequestMatcher("", "get", true, config.RoleGuest), "/a/1/b/2/c/3", "GET", false, "")
+	AssertMatch(t, "11", rootUrlList.AddUrlRequestMatcher("", "get", true, config.RoleGuest), "", "GET", false, "")
+	AssertMatch(t, "12", rootUrlList.AddUrlRequestMatcher("", "post", true, config.RoleGuest), "", "GET", false, "")
+	rootUrlList.AddUrlRequestMatcher("b", "post", true, config.RoleGuest)
 	if rootUrlList.String() != "a,b," {
 		t.Fatal("RootUrlList is incorrect: Expected:a,b, Actual:", rootUrlList.String())
 	}
 }
 
+func TestRootUrlListRoute(t *testing.T) {
+	rl := NewRootUrlList()
+	tree := rl.AddUrlRequestMatcher("/files/user/*/loc/*/tree", "get", true, config.RoleGuest)
+	path := rl.AddUrlRequestMatcher("/files/user/*/loc/*/path/*", "get", true, config.RoleGuest)
+	name := rl.AddUrlRequestMatcher("/files/user/*/loc/*/name/*", "get", true, config.RoleGuest)
+	del := rl.AddUrlRequestMatcher("/files/user/*/loc/*/name/*", "delete", true, config.RoleUser)
+	lit := rl.AddUrlRequestMatcher("/a/b/x", "get", true, config.RoleGuest)
+	wild := rl.AddUrlRequestMatcher("/a/*/y", "get", true, config.RoleGuest)
+
+	AssertRoute(t, "1", rl, "/files/user/bob/loc/pics/tree", "GET", tree, "loc=pics,user=bob", "")
+	AssertRoute(t, "2", rl, "/files/user/bob/loc/pics/path/tree", "GET", path, "loc=pics,path=tree,user=bob", "")
+	AssertRoute(t, "3", rl, "/files/user/bob/loc/pics/name/n1", "get", name, "loc=pics,name=n1,user=bob", "")
+	AssertRoute(t, "4", rl, "/files/user/bob/loc/pics/name/n1", "DELETE", del, "loc=pics,name=n1,user=bob", "")
+	AssertRoute(t, "5", rl, "/files/user/bob/loc/pics/name/n1", "POST", nil, "", "DELETE,GET")
+	AssertRoute(t, "6", rl, "/files/user/bob/loc/pics/tree", "POST", nil, "", "GET")
+	AssertRoute(t, "7", rl, "/files/user/bob/loc/pics/xxx", "GET", nil, "", "")
+	AssertRoute(t, "8", rl, "/a/b/x", "GET", lit, "", "")
+	AssertRoute(t, "9", rl, "/a/b/y", "GET", wild, "a=b", "")
+	AssertRoute(t, "10", rl, "/a/b", "GET", nil, "", "")
+	AssertRoute(t, "11", rl, "", "GET", nil, "", "")
+
+	AssertContains(t, rl.RouteTable(), []string{"Route: GET     guest  /a/*/y (logged)\n", "Route: DELETE  user   /files/user/*/loc/*/name/*"})
+	if strings.Index(rl.RouteTable(), "/a/*/y") > strings.Index(rl.RouteTable(), "/files/") {
+		t.Fatalf("RouteTable is not sorted:\n%s", rl.RouteTable())
+	}
+}
+
 func TestGetSetPropNewFile(t *testing.T) {
 	os.Remove(testPropertyFile)
 	configData, _ := UpdateConfigAndLoad(t, func(cdff *config.ConfigDataFromFile) {
@@ -927,6 +970,176 @@ func TestServerIsUp(t *testing.T) {
 	}
 }
 
+func TestServerMethodNotAllowed(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+
+	if serverState != "Running" {
+		go RunServer(configData, logger)
+		time.Sleep(100 * time.Millisecond)
+	}
+	resp, _ := RunClientPost(t, configData, "server/time", 405, "\"msg\":\"Method Not Allowed\"", "")
+	AssertHeaderEqual(t, "TestServerMethodNotAllowed 1", resp, "Allow", "GET")
+	resp, _ = RunClientPost(t, configData, "files/user/stuart/loc/pics/tree", 405, "Method not allowed", "")
+	AssertHeaderEqual(t, "TestServerMethodNotAllowed 2", resp, "Allow", "GET")
+	RunClientPost(t, configData, "server/nothing", 404, "Resource not found", "")
+}
+
+func TestAuthentication(t *testing.T) {
+	hash, err := config.HashPassword("secret")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %s", err.Error())
+	}
+	configData, _ := UpdateConfigAndLoad(t, func(cdff *config.ConfigDataFromFile) {
+		ud := cdff.Users["stuart"]
+		ud.PasswordHash = hash
+		cdff.Users["stuart"] = ud
+		cdff.SessionKey = "TestAuthenticationKey"
+	}, nil, true)
+	StopServer(t, configData)
+	go RunServer(configData, logger)
+	time.Sleep(100 * time.Millisecond)
+	defer StopServer(t, configData)
+
+	// bob has no password so is open
+	RunClientGet(t, "TestAuthentication 1", configData, "files/user/bob/loc/home", 200, "?", -1, 0)
+	resp, _ := RunClientGet(t, "TestAuthentication 2", configData, "files/user/stuart/loc/home", 401, "?", -1, 0)
+	AssertHeaderContains(t, "TestAuthentication 2", resp, "Www-Authenticate", "Basic realm=")
+	RunClientGet(t, "TestAuthentication 3", configData, "files/user/stuart/loc/data/name/testdata.json", 401, "?", -1, 0)
+	RunClientGet(t, "TestAuthentication 4", configData, "prop/user/stuart", 401, "?", -1, 0)
+
+	RunClientAuth(t, "TestAuthentication 5", nil, configData, "GET", "files/user/stuart/loc/home", "stuart", "secret", "", 200)
+	RunClientAuth(t, "TestAuthentication 6", nil, configData, "GET", "files/user/stuart/loc/home", "stuart", "wrong", "", 401)
+	RunClientAuth(t, "TestAuthentication 7", nil, configData, "GET", "files/user/stuart/loc/home", "bob", "secret", "", 401)
+
+	jar, _ := cookiejar.New(nil)
+	RunClientAuth(t, "TestAuthentication 8", jar, configData, "POST", "auth/login", "", "", "{\"user\":\"stuart\", \"password\":\"wrong\"}", 401)
+	resp = RunClientAuth(t, "TestAuthentication 9", jar, configData, "POST", "auth/login", "", "", "{\"user\":\"stuart\", \"password\":\"secret\"}", 200)
+	AssertHeaderContains(t, "TestAuthentication 9", resp, "Set-Cookie", SessionCookieName+"=")
+	AssertHeaderContains(t, "TestAuthentication 9", resp, "Set-Cookie", "HttpOnly")
+	RunClientAuth(t, "TestAuthentication 10", jar, configData, "GET", "files/user/stuart/loc/home", "", "", "", 200)
+	RunClientAuth(t, "TestAuthentication 11", jar, configData, "GET", "auth/logout", "", "", "", 200)
+	RunClientAuth(t, "TestAuthentication 12", jar, configData, "GET", "files/user/stuart/loc/home", "", "", "", 401)
+	AssertLogContains(t, logger, []string{"Status:401. Msg:Authentication required", "Status:401. Msg:Authentication failed"})
+}
+
+func TestPathTraversal(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	hash, _ := config.HashPassword("secret")
+	stuart := configData.ConfigFileData.Users["stuart"]
+	stuart.PasswordHash = hash
+	configData.ConfigFileData.Users["stuart"] = stuart
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+	stuartFile := filepath.Join(stuart.Locations["home"], "t1.JSON")
+	before, err := os.ReadFile(stuartFile)
+	if err != nil {
+		t.Fatalf("TestPathTraversal: %s", err.Error())
+	}
+
+	request := func(method string, url string, status int) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(method, url, strings.NewReader("{}")))
+		if rr.Code != status {
+			t.Fatalf("%s %s Status:%d expected %d Body:%s", method, url, rr.Code, status, rr.Body.String())
+		}
+	}
+	// bob has no password. stuart's files must not be reachable through bob's locations
+	request("GET", "/files/user/stuart/loc/home/name/t1.JSON", http.StatusUnauthorized)
+	request("GET", "/files/user/bob/loc/home/name/t1.JSON", http.StatusOK)
+	request("GET", "/files/user/bob/loc/home/path/"+encodeValue("../stuart")+"/name/t1.JSON", http.StatusForbidden)
+	request("GET", "/files/user/bob/loc/home/path/../name/t1.JSON", http.StatusForbidden)
+	request("GET", "/files/user/bob/loc/home/name/"+encodeValue("../stuart/t1.JSON"), http.StatusForbidden)
+	request("GET", "/files/user/bob/loc/home/name/..", http.StatusForbidden)
+	request("GET", "/files/user/bob/loc/home/path/"+encodeValue("../stuart"), http.StatusForbidden)
+	request("GET", "/files/user/bob/loc/home/path/..", http.StatusForbidden)
+	request("GET", "/files/user/bob/loc/home/path/"+encodeValue("b-pics/../.."), http.StatusForbidden)
+	request("GET", "/files/user/bob/loc/home/path/"+encodeValue("b-pics/.."), http.StatusOK)
+	request("GET", "/metadata/user/bob/loc/home/path/"+encodeValue("../stuart")+"/name/t1.JSON", http.StatusForbidden)
+	request("POST", "/files/user/bob/loc/home/path/"+encodeValue("../stuart")+"/name/t1.JSON", http.StatusForbidden)
+	request("POST", "/files/user/bob/loc/home/name/"+encodeValue("../stuart/t1.JSON"), http.StatusForbidden)
+	request("DELETE", "/files/user/bob/loc/home/path/"+encodeValue("../stuart")+"/name/t1.JSON", http.StatusForbidden)
+	request("DELETE", "/files/user/bob/loc/home/path/../name/t1.JSON", http.StatusForbidden)
+	after, err := os.ReadFile(stuartFile)
+	if err != nil || !bytes.Equal(before, after) {
+		t.Fatalf("TestPathTraversal: %s was changed", stuartFile)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	hash, err := config.HashPassword("secret")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %s", err.Error())
+	}
+	hidden := true
+	configData, _ := UpdateConfigAndLoad(t, func(cdff *config.ConfigDataFromFile) {
+		cdff.Users[config.AdminUserName] = config.UserData{Hidden: &hidden, Name: "Admin", Home: "stuart", Locations: map[string]string{}, Env: map[string]string{}, PasswordHash: hash}
+		bob := cdff.Users["bob"]
+		bob.PasswordHash = hash
+		cdff.Users["bob"] = bob
+		stuart := cdff.Users["stuart"]
+		stuart.PasswordHash = hash
+		stuart.Role = "guest"
+		cdff.Users["stuart"] = stuart
+		cdff.Exec["ls"].Users = []string{"bob"}
+	}, nil, true)
+	log := &TLog{}
+	done := StartIsolatedServer(t, configData, log)
+
+	RunClientAuth(t, "TestRoles 1", nil, configData, "GET", "ping", "", "", "", 200)
+	RunClientAuth(t, "TestRoles 2", nil, configData, "GET", "server/log", "", "", "", 401)
+	RunClientAuth(t, "TestRoles 3", nil, configData, "GET", "server/log", "bob", "secret", "", 403)
+	RunClientAuth(t, "TestRoles 4", nil, configData, "GET", "server/log", config.AdminUserName, "secret", "", 200)
+	// Admin can read other users resources
+	RunClientAuth(t, "TestRoles 5", nil, configData, "GET", "files/user/bob/loc/home", config.AdminUserName, "secret", "", 200)
+	// Guest role is read only even for their own resources
+	RunClientAuth(t, "TestRoles 6", nil, configData, "GET", "files/user/stuart/loc/home", "stuart", "secret", "", 200)
+	RunClientAuth(t, "TestRoles 7", nil, configData, "POST", "files/user/stuart/loc/data/name/roles.json", "stuart", "secret", postDataFile1, 403)
+	// Exec is permitted per user
+	RunClientAuth(t, "TestRoles 8", nil, configData, "GET", "exec/ls", "", "", "", 401)
+	RunClientAuth(t, "TestRoles 9", nil, configData, "GET", "exec/ls", "stuart", "secret", "", 403)
+	RunClientAuth(t, "TestRoles 10", nil, configData, "GET", "exec/free", "bob", "secret", "", 403)
+	RunClientAuth(t, "TestRoles 11", nil, configData, "GET", "exec/ls", "bob", "secret", "", 200)
+
+	RunClientAuth(t, "TestRoles 12", nil, configData, "GET", "server/exit", "bob", "secret", "", 403)
+	// A pooled connection that has not sent a request delays Shutdown by up to 5 seconds
+	http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+	// As used by the -k option
+	if _, rc, _ := SendToHost(configData.GetPortString(), ServerExitUrl, false, "", ""); rc != http.StatusUnauthorized {
+		t.Fatalf("TestRoles 13: SendToHost without credentials. Status:%d expected 401", rc)
+	}
+	if _, rc, _ := SendToHost(configData.GetPortString(), ServerExitUrl, false, config.AdminUserName, "secret"); rc != http.StatusAccepted {
+		t.Fatalf("TestRoles 14: SendToHost as admin. Status:%d expected 202", rc)
+	}
+	select {
+	case <-done:
+	case <-time.After(configData.GetShutdownTimeout() + 5*time.Second):
+		t.Fatal("TestRoles: Server was not stopped")
+	}
+	AssertLogContains(t, log, []string{"Role admin required. bob has role user", "Role user required. stuart has role guest", "exec-id=free. bob is not permitted"})
+}
+
+func TestSessionManager(t *testing.T) {
+	sm := NewSessionManager("key", time.Minute)
+	now := time.Now()
+	token, _ := sm.NewToken("stuart", now)
+	user, err := sm.Verify(token, now)
+	if err != nil || user != "stuart" {
+		t.Fatalf("Token should verify as stuart. User:%s Error:%v", user, err)
+	}
+	_, err = sm.Verify(token, now.Add(2*time.Minute))
+	if err == nil {
+		t.Fatalf("Token should have expired")
+	}
+	_, err = NewSessionManager("other", time.Minute).Verify(token, now)
+	if err == nil {
+		t.Fatalf("Token should not verify with a different key")
+	}
+	_, err = sm.Verify("x"+token, now)
+	if err == nil {
+		t.Fatalf("Modified token should not verify")
+	}
+}
+
 func TestClient(t *testing.T) {
 	configData := loadConfigData(t, testConfigFile)
 	if serverState != "Running" {
@@ -965,6 +1178,28 @@ func RunClientPost(t *testing.T, config *config.ConfigData, path string, expecte
 	return res, ""
 }
 
+func RunClientAuth(t *testing.T, id string, jar http.CookieJar, config *config.ConfigData, method, path, user, password, data string, expectedStatus int) *http.Response {
+	requestURL := fmt.Sprintf("http://localhost%s/%s", config.GetPortString(), path)
+	req, err := http.NewRequest(method, requestURL, strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("RunClientAuth:id:%s. Request error: %s", id, err.Error())
+	}
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
+	client := &http.Client{Jar: jar}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("RunClientAuth:id:%s. Client error: %s", id, err.Error())
+	}
+	defer res.Body.Close()
+	io.ReadAll(res.Body)
+	if res.StatusCode != expectedStatus {
+		t.Fatalf("RunClientAuth:id:%s. Status for %s %s. Expected %d Actual %d", id, method, requestURL, expectedStatus, res.StatusCode)
+	}
+	return res
+}
+
 func RunClientDelete(t *testing.T, config *config.ConfigData, path string, expectedStatus int, data string) (*http.Response, string) {
 	myReader := strings.NewReader(data)
 	requestURL := fmt.Sprintf("http://localhost%s/%s", config.GetPortString(), path)
@@ -1090,6 +1325,63 @@ func RunServer(config *config.ConfigData, logger logging.Logger) {
 
 }
 
+/*
+Start a server of its own on a free port. It does not use or change serverState or the shared
+logger so the test does not depend on the tests before it.
+
+Returns when the server answers a ping. The returned channel gets the exit code when it stops.
+*/
+func StartIsolatedServer(t *testing.T, configData *config.ConfigData, log *TLog) chan int {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("StartIsolatedServer: No free port. %s", err.Error())
+	}
+	configData.ConfigFileData.Port = l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	actionQueue := make(chan *ActionEvent, 10)
+	var lrm *runCommand.LongRunningManager
+	if configData.GetExecPath() != "" {
+		lrm, err = runCommand.NewLongRunningManager(configData.GetExecPath(), log.Log)
+		if err != nil {
+			t.Fatalf("StartIsolatedServer: LongRunningManager. %s", err.Error())
+		}
+	}
+	webAppServer, err := NewWebAppServer(configData, actionQueue, lrm, log)
+	if err != nil {
+		t.Fatalf("StartIsolatedServer: NewWebAppServer. %s", err.Error())
+	}
+	go func() {
+		for ae := range actionQueue {
+			if ae != nil && ae.Id == Exit {
+				webAppServer.Close(ae.Rc)
+				return
+			}
+		}
+	}()
+	done := make(chan int, 1)
+	go func() {
+		done <- webAppServer.Start()
+	}()
+	t.Cleanup(func() {
+		webAppServer.Close(0)
+	})
+
+	pingUrl := fmt.Sprintf("http://localhost%s/ping", configData.GetPortString())
+	for i := 0; i < 100; i++ {
+		res, err := http.Get(pingUrl)
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode == http.StatusOK {
+				return done
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("StartIsolatedServer: %s did not respond", pingUrl)
+	return nil
+}
+
 func AssertHeaderEquals(t *testing.T, res *http.Response, headerName, expected0 string) {
 	hv := res.Header[headerName]
 	if len(hv) == 0 {
@@ -1231,6 +1523,28 @@ func AssertMatch(t *testing.T, message string, matcher *urlRequestMatcher, url s
 	}
 }
 
+func AssertRoute(t *testing.T, message string, rl *RootUrlList, url string, reqType string, expected *urlRequestMatcher, params string, allowed string) {
+	requestUriparts := strings.Split(strings.TrimSpace(url), "/")
+	if requestUriparts[0] == "" {
+		requestUriparts = requestUriparts[1:]
+	}
+	m, p, al := rl.Route(requestUriparts, reqType, nil)
+	if m != expected {
+		t.Fatalf("%s.\nRoute %s:%s. Expected %s. Actual %s", message, reqType, url, expected, m)
+	}
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k+"="+p[k])
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != params {
+		t.Fatalf("%s.\nExpected Params %s. Actual Params %s", message, params, strings.Join(keys, ","))
+	}
+	if strings.Join(al, ",") != allowed {
+		t.Fatalf("%s.\nExpected Allowed %s. Actual Allowed %s", message, allowed, strings.Join(al, ","))
+	}
+}
+
 func loadConfigData(t *testing.T, file string) *config.ConfigData {
 	errList := config.NewConfigErrorData()
 	configData := config.NewConfigData(file, "goWebApp", false, false, false, errList)
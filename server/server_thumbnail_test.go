@@ -0,0 +1,97 @@
+package server
+
+import (
+	"bytes"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+func TestThumbnail(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	configData.ConfigFileData.Thumbnails = &config.ThumbnailData{Location: "usr", Size: 50}
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+	pic := filepath.Join(configData.GetUserData("stuart").Locations["pics"], "pic1.jpeg")
+	info, err := os.Stat(pic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumb := filepath.Join(configData.GetUserData("stuart").Locations["usr"], info.ModTime().Format("2006_01_02_15_04_05_")+"pic1.jpeg.jpg")
+	os.Remove(thumb)
+	defer os.Remove(thumb)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/thumbnail/user/stuart/loc/pics/name/pic1.jpeg", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("Generate. Status:%d Type:%s Body:%s", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+	img, err := jpeg.Decode(bytes.NewReader(rr.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("Thumbnail is not a jpeg. %s", err.Error())
+	}
+	// pic1.jpeg is 299x168
+	if img.Bounds().Dx() != 50 || img.Bounds().Dy() != 28 {
+		t.Fatalf("Thumbnail size is %v", img.Bounds())
+	}
+	cached, err := os.Stat(thumb)
+	if err != nil {
+		t.Fatalf("Thumbnail was not cached. %s", err.Error())
+	}
+
+	// Served from the cache
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/thumbnail/user/stuart/loc/pics/name/pic1.jpeg", nil))
+	again, _ := os.Stat(thumb)
+	if rr.Code != http.StatusOK || rr.Body.Len() != int(cached.Size()) || !again.ModTime().Equal(cached.ModTime()) {
+		t.Fatalf("Cached. Status:%d Len:%d", rr.Code, rr.Body.Len())
+	}
+
+	// A thumbnail named by another tool is found using ThumbNailTrim
+	os.Remove(thumb)
+	other := filepath.Join(configData.GetUserData("stuart").Locations["usr"], "2020_01_01_00_00_00_pic1.jpeg.jpg")
+	os.WriteFile(other, []byte("other"), 0644)
+	defer os.Remove(other)
+	configData.ConfigFileData.ThumbnailTrim = []int{20, 4}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/thumbnail/user/stuart/loc/pics/name/pic1.jpeg", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "other" {
+		t.Fatalf("Trimmed. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+
+	// A read only thumbnail location is not written to. The thumbnail is still returned
+	os.Remove(other)
+	stuart := configData.ConfigFileData.Users["stuart"]
+	stuart.Policies = map[string]*config.LocationPolicy{"usr": {ReadOnly: true}}
+	configData.ConfigFileData.Users["stuart"] = stuart
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/thumbnail/user/stuart/loc/pics/name/pic1.jpeg", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("Read only. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	img, err = jpeg.Decode(bytes.NewReader(rr.Body.Bytes()))
+	if err != nil || img.Bounds().Dx() != 50 {
+		t.Fatalf("Read only thumbnail:%v %v", err, img)
+	}
+	if _, err := os.Stat(thumb); err == nil {
+		t.Fatalf("Thumbnail was written to a read only location")
+	}
+	stuart.Policies = nil
+	configData.ConfigFileData.Users["stuart"] = stuart
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/thumbnail/user/stuart/loc/pics/name/t1.JSON", nil))
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("Not an image. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/thumbnail/user/stuart/loc/pics/name/missing.jpeg", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("Missing. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testTimeline struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+	Years []struct {
+		Year   int `json:"year"`
+		Count  int `json:"count"`
+		Months []struct {
+			Month int `json:"month"`
+			Days  []struct {
+				Day   int `json:"day"`
+				Files []struct {
+					Path    string `json:"path"`
+					EncPath string `json:"encPath"`
+					Name    string `json:"name"`
+					EncName string `json:"encName"`
+					Taken   string `json:"taken"`
+					Source  string `json:"source"`
+				} `json:"files"`
+			} `json:"days"`
+		} `json:"months"`
+	} `json:"years"`
+}
+
+func getTimeline(t *testing.T, h *ServerHandler, url string) *testTimeline {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("%s Status:%d Body:%s", url, rr.Code, rr.Body.String())
+	}
+	tl := &testTimeline{}
+	err := json.Unmarshal(rr.Body.Bytes(), tl)
+	if err != nil {
+		t.Fatalf("%s invalid json:%s", url, rr.Body.String())
+	}
+	return tl
+}
+
+func TestTimeline(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+	plus := configData.GetUserData("stuart").Locations["picsPlus"]
+	for _, f := range []string{"2023_06_01_10_00_00_a.jpeg", "2021_06_01_08_00_00_b.jpeg", "s-testdir1/2024_02_01_10_12_00_c.jpeg", "2024_06_01_09_00_00_d.jpeg"} {
+		file := filepath.Join(plus, f)
+		os.WriteFile(file, []byte(f), 0644)
+		defer os.Remove(file)
+	}
+
+	// Files without a date are left out. Newest first
+	tl := getTimeline(t, h, "/timeline/user/stuart/loc/picsPlus")
+	if tl.Count != 4 || len(tl.Years) != 3 || tl.Years[0].Year != 2024 || tl.Years[0].Count != 2 || tl.Years[2].Year != 2021 {
+		t.Fatalf("Timeline:%+v", tl)
+	}
+	if len(tl.Years[0].Months) != 2 || tl.Years[0].Months[0].Month != 6 || tl.Years[0].Months[1].Month != 2 {
+		t.Fatalf("Timeline months:%+v", tl.Years[0])
+	}
+
+	tl = getTimeline(t, h, "/timeline/user/stuart/loc/picsPlus?year=2024&month=2")
+	if tl.Count != 1 {
+		t.Fatalf("Timeline 2024-02:%+v", tl)
+	}
+	f := tl.Years[0].Months[0].Days[0].Files[0]
+	if f.Path != "s-testdir1" || f.Name != "2024_02_01_10_12_00_c.jpeg" || f.Taken != "2024-02-01T10:12:00" || f.Source != "name" {
+		t.Fatalf("Timeline file:%+v", f)
+	}
+	// The encoded names work with the file endpoints
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/files/user/stuart/loc/picsPlus/path/"+f.EncPath+"/name/"+f.EncName, nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "s-testdir1/2024_02_01_10_12_00_c.jpeg" {
+		t.Fatalf("Timeline get file. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+
+	// Paths are relative to the location
+	tl = getTimeline(t, h, "/timeline/user/stuart/loc/pics?year=2021")
+	if tl.Count != 1 || tl.Years[0].Months[0].Days[0].Files[0].Path != "s-testfolder" {
+		t.Fatalf("Timeline pics:%+v", tl)
+	}
+
+	// Earlier years only
+	tl = getTimeline(t, h, "/onthisday/user/stuart/loc/picsPlus?date=2024-06-01")
+	if tl.Date != "2024-06-01" || tl.Count != 2 || tl.Years[0].Year != 2023 || tl.Years[1].Year != 2021 {
+		t.Fatalf("On this day:%+v", tl)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/onthisday/user/stuart/loc/picsPlus?date=June", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("On this day bad date. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+}
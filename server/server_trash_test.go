@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+	"github.com/stuartdd/goWebApp/controllers"
+)
+
+func trashRequest(t *testing.T, h *ServerHandler, method string, url string, status int) map[string]interface{} {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(method, url, nil))
+	if rr.Code != status {
+		t.Fatalf("%s %s Status:%d expected %d Body:%s", method, url, rr.Code, status, rr.Body.String())
+	}
+	m := map[string]interface{}{}
+	json.Unmarshal(rr.Body.Bytes(), &m)
+	return m
+}
+
+func TestTrash(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+	trash := filepath.Join(configData.GetUserRoot("stuart"), config.TrashDirName)
+	os.RemoveAll(trash)
+	defer os.RemoveAll(trash)
+	pics := configData.GetUserData("stuart").Locations["pics"]
+	fileA := filepath.Join(pics, "s-testfolder", "trashA.json")
+	fileB := filepath.Join(pics, "trashB.json")
+	defer os.Remove(fileA)
+	defer os.Remove(fileB)
+	os.WriteFile(fileA, []byte("A"), 0644)
+	os.WriteFile(fileB, []byte("B"), 0644)
+
+	m := trashRequest(t, h, "DELETE", "/files/user/stuart/loc/pics/path/s-testfolder/name/trashA.json", http.StatusAccepted)
+	idA, _ := m["trashId"].(string)
+	if idA == "" || m["cause"] != "File deleted OK" {
+		t.Fatalf("Delete to trash:%v", m)
+	}
+	if _, err := os.Stat(fileA); err == nil {
+		t.Fatalf("File should be in the trash")
+	}
+	m = trashRequest(t, h, "DELETE", "/files/user/stuart/loc/pics/name/trashB.json", http.StatusAccepted)
+	idB := m["trashId"].(string)
+
+	m = trashRequest(t, h, "GET", "/trash/user/stuart", http.StatusOK)
+	items := m["items"].([]interface{})
+	if len(items) != 2 {
+		t.Fatalf("Trash list:%v", m)
+	}
+	first := items[1].(map[string]interface{})
+	if first["id"] != idA || first["loc"] != "pics" || first["path"] != "s-testfolder" || first["name"] != "trashA.json" {
+		t.Fatalf("Trash item:%v", first)
+	}
+
+	// Restore to the original place. Not if a file is there now unless replace=true
+	os.WriteFile(fileA, []byte("new A"), 0644)
+	trashRequest(t, h, "POST", "/trash/user/stuart/id/"+idA, http.StatusPreconditionFailed)
+	trashRequest(t, h, "POST", "/trash/user/stuart/id/"+idA+"?replace=true", http.StatusAccepted)
+	assertFileContent(t, fileA, "A")
+	trashRequest(t, h, "POST", "/trash/user/stuart/id/"+idA, http.StatusNotFound)
+
+	// The trash cannot be reached through a location and a changed manifest cannot restore outside the location
+	trashRequest(t, h, "GET", "/files/user/stuart/loc/home/path/"+config.TrashDirName+"/name/manifest.json", http.StatusNotFound)
+	trashRequest(t, h, "GET", "/files/user/stuart/loc/home/path/"+config.TrashDirName, http.StatusNotFound)
+	trashRequest(t, h, "POST", "/files/user/stuart/loc/home/path/"+config.TrashDirName+"/name/manifest.json", http.StatusNotFound)
+	manifest := filepath.Join(trash, "manifest.json")
+	saved, _ := os.ReadFile(manifest)
+	for _, target := range [][]string{{"../..", "trashB.json"}, {"", ".."}, {config.VersionsDirName, "trashB.json"}} {
+		tampered := []*controllers.TrashItem{{Id: idB, Loc: "pics", Path: target[0], Name: target[1]}}
+		b, _ := json.Marshal(tampered)
+		os.WriteFile(manifest, b, 0644)
+		trashRequest(t, h, "POST", "/trash/user/stuart/id/"+idB, http.StatusForbidden)
+	}
+	os.WriteFile(manifest, saved, 0644)
+
+	// Purge one item
+	trashRequest(t, h, "DELETE", "/trash/user/stuart/id/"+idB, http.StatusAccepted)
+	if _, err := os.Stat(filepath.Join(trash, idB)); err == nil {
+		t.Fatalf("Purged file is still in the trash")
+	}
+
+	// Permanent delete does not use the trash
+	m = trashRequest(t, h, "DELETE", "/files/user/stuart/loc/pics/path/s-testfolder/name/trashA.json?permanent=true", http.StatusAccepted)
+	if m["trashId"] != nil {
+		t.Fatalf("Permanent delete:%v", m)
+	}
+	m = trashRequest(t, h, "GET", "/trash/user/stuart", http.StatusOK)
+	if len(m["items"].([]interface{})) != 0 {
+		t.Fatalf("Trash should be empty:%v", m)
+	}
+
+	// Automatic purge after the retention period. Empty the trash
+	os.WriteFile(fileA, []byte("A"), 0644)
+	os.WriteFile(fileB, []byte("B"), 0644)
+	trashRequest(t, h, "DELETE", "/files/user/stuart/loc/pics/path/s-testfolder/name/trashA.json", http.StatusAccepted)
+	if len(controllers.PurgeTrash(configData, time.Now(), time.Hour)) != 0 {
+		t.Fatalf("Nothing should be purged")
+	}
+	if len(controllers.PurgeTrash(configData, time.Now().Add(2*time.Hour), time.Hour)) != 1 {
+		t.Fatalf("Old item should be purged")
+	}
+	trashRequest(t, h, "DELETE", "/files/user/stuart/loc/pics/name/trashB.json", http.StatusAccepted)
+	m = trashRequest(t, h, "DELETE", "/trash/user/stuart", http.StatusAccepted)
+	entries, _ := os.ReadDir(trash)
+	if len(entries) != 1 || entries[0].Name() != "manifest.json" {
+		t.Fatalf("Trash should only contain the manifest: %v", entries)
+	}
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+	"github.com/stuartdd/goWebApp/controllers"
+)
+
+func postChunk(h *ServerHandler, url string, contentRange string, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", url, strings.NewReader(body))
+	req.Header.Set("Content-Range", contentRange)
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestChunkedUpload(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+	loc := configData.GetUserData("stuart").Locations["picsPlus"]
+	file := filepath.Join(loc, "chunked.txt")
+	os.Remove(file)
+	defer os.Remove(file)
+	defer os.RemoveAll(filepath.Join(loc, config.UploadDirName))
+	url := "/files/user/stuart/loc/picsPlus/name/chunked.txt"
+
+	rr := postChunk(h, url, "bytes */10", "")
+	if rr.Code != http.StatusPermanentRedirect || rr.Header().Get("Upload-Offset") != "0" || rr.Header().Get("Range") != "" {
+		t.Fatalf("Nothing received. Status:%d Headers:%v", rr.Code, rr.Header())
+	}
+	rr = postChunk(h, url, "bytes 0-3/10", "0123")
+	if rr.Code != http.StatusPermanentRedirect || rr.Header().Get("Range") != "bytes=0-3" {
+		t.Fatalf("First chunk. Status:%d Headers:%v", rr.Code, rr.Header())
+	}
+	// A gap is rejected with the current offset
+	rr = postChunk(h, url, "bytes 6-9/10", "6789")
+	if rr.Code != http.StatusRequestedRangeNotSatisfiable || rr.Header().Get("Upload-Offset") != "4" {
+		t.Fatalf("Gap. Status:%d Headers:%v", rr.Code, rr.Header())
+	}
+	// A client that lost the response asks for the offset and resumes. Overlap is allowed
+	rr = postChunk(h, url, "bytes */10", "")
+	if rr.Header().Get("Upload-Offset") != "4" {
+		t.Fatalf("Query. Status:%d Headers:%v", rr.Code, rr.Header())
+	}
+	if _, err := os.Stat(file); err == nil {
+		t.Fatalf("File should not exist until the upload is complete")
+	}
+	rr = postChunk(h, url, "bytes 2-9/10", "23456789")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Last chunk. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	content, _ := os.ReadFile(file)
+	if string(content) != "0123456789" {
+		t.Fatalf("Upload content is wrong: %s", string(content))
+	}
+	parts, _ := os.ReadDir(filepath.Join(loc, config.UploadDirName))
+	if len(parts) != 0 {
+		t.Fatalf("Partial file should have been renamed")
+	}
+	rr = postChunk(h, url, "bytes 0-3/10", "0123")
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("Existing file. Status:%d", rr.Code)
+	}
+	rr = postChunk(h, url, "bytes 0-10/10", "0123")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Invalid range. Status:%d", rr.Code)
+	}
+
+	// Stale partial uploads are removed
+	postChunk(h, "/files/user/stuart/loc/picsPlus/name/stale.txt", "bytes 0-1/5", "01")
+	if len(controllers.RemoveStaleUploads(configData, time.Now(), time.Hour)) != 0 {
+		t.Fatalf("Partial upload is not stale")
+	}
+	removed := controllers.RemoveStaleUploads(configData, time.Now().Add(2*time.Hour), time.Hour)
+	if len(removed) != 1 || !strings.HasSuffix(removed[0], "-5.part") {
+		t.Fatalf("Stale partial upload should be removed. %v", removed)
+	}
+}
+
+func TestChunkedUploadSerialised(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+	loc := configData.GetUserData("stuart").Locations["picsPlus"]
+	file := filepath.Join(loc, "serial.txt")
+	os.Remove(file)
+	defer os.Remove(file)
+	defer os.RemoveAll(filepath.Join(loc, config.UploadDirName))
+	url := "/files/user/stuart/loc/picsPlus/name/serial.txt"
+
+	// The first chunk is held part way through its body
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	first := make(chan int, 1)
+	go func() {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", url, pr)
+		req.Header.Set("Content-Range", "bytes 0-9/10")
+		h.ServeHTTP(rr, req)
+		first <- rr.Code
+	}()
+	pw.Write([]byte("01234"))
+
+	// The same chunk from a retry waits until the first has finished
+	second := make(chan int, 1)
+	go func() {
+		second <- postChunk(h, url, "bytes 0-9/10", "abcdefghij").Code
+	}()
+	select {
+	case code := <-second:
+		t.Fatalf("Second chunk should wait for the first. Status:%d", code)
+	case <-time.After(100 * time.Millisecond):
+	}
+	pw.Write([]byte("56789"))
+	pw.Close()
+	if code := <-first; code != http.StatusAccepted {
+		t.Fatalf("First chunk. Status:%d", code)
+	}
+	if code := <-second; code != http.StatusPreconditionFailed {
+		t.Fatalf("Second chunk. Status:%d", code)
+	}
+	content, _ := os.ReadFile(file)
+	if string(content) != "0123456789" {
+		t.Fatalf("Upload content is wrong: %s", string(content))
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+	loc := configData.GetUserData("stuart").Locations["picsPlus"]
+	for _, n := range []string{"mp1.txt", "mp2.txt"} {
+		os.Remove(filepath.Join(loc, n))
+		defer os.Remove(filepath.Join(loc, n))
+	}
+	post := func(query string, files map[string]string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		mw.WriteField("comment", "not a file")
+		for _, n := range []string{"mp1.txt", "mp2.txt"} {
+			if c, ok := files[n]; ok {
+				fw, _ := mw.CreateFormFile("files", n)
+				fw.Write([]byte(c))
+			}
+		}
+		mw.Close()
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/files/user/stuart/loc/picsPlus"+query, &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := post("", map[string]string{"mp1.txt": "one", "mp2.txt": "two"})
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Multipart save. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	AssertContains(t, rr.Body.String(), []string{"[{\"name\":\"mp1.txt\",\"status\":202,\"error\":false,", "\"size\":3}", "\"name\":\"mp2.txt\""})
+	// Save does not overwrite. Each file has its own status
+	os.Remove(filepath.Join(loc, "mp2.txt"))
+	rr = post("", map[string]string{"mp1.txt": "ONE", "mp2.txt": "TWO"})
+	if rr.Code != http.StatusMultiStatus {
+		t.Fatalf("Multipart partial. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	AssertContains(t, rr.Body.String(), []string{"{\"name\":\"mp1.txt\",\"status\":412,\"error\":true,\"cause\":\"File exists\"", "{\"name\":\"mp2.txt\",\"status\":202"})
+	rr = post("?action=append", map[string]string{"mp1.txt": "+1"})
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Multipart append. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	content, _ := os.ReadFile(filepath.Join(loc, "mp1.txt"))
+	if string(content) != "one+1" {
+		t.Fatalf("Append content is wrong: %s", string(content))
+	}
+	rr = post("?action=replace", map[string]string{"mp1.txt": "new"})
+	content, _ = os.ReadFile(filepath.Join(loc, "mp1.txt"))
+	if rr.Code != http.StatusAccepted || string(content) != "new" {
+		t.Fatalf("Multipart replace. Status:%d content:%s", rr.Code, string(content))
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/files/user/stuart/loc/picsPlus", strings.NewReader("raw")))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("A raw body should be rejected. Status:%d", rr.Code)
+	}
+}
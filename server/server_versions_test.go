@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stuartdd/goWebApp/config"
+)
+
+func TestVersions(t *testing.T) {
+	configData := loadConfigData(t, testConfigFile)
+	configData.ConfigFileData.KeepVersions = map[string]int{"picsPlus": 5, "stuart.picsPlus": 2}
+	h := NewServerHandler(configData, make(chan *ActionEvent, 10), nil, &TLog{}, time.Now())
+	plus := configData.GetUserData("stuart").Locations["picsPlus"]
+	file := filepath.Join(plus, "state.json")
+	os.Remove(file)
+	defer os.Remove(file)
+	defer os.RemoveAll(filepath.Join(plus, config.VersionsDirName))
+	url := "/files/user/stuart/loc/picsPlus/name/state.json"
+
+	for _, v := range []string{"{\"v\":1}", "{\"v\":2}", "{\"v\":3}", "{\"v\":4}"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("POST", url+"?action=replace", strings.NewReader(v)))
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("Replace. Status:%d Body:%s", rr.Code, rr.Body.String())
+		}
+	}
+	assertFileContent(t, file, "{\"v\":4}")
+
+	// 'stuart.picsPlus' is used before 'picsPlus'. Only 2 versions kept. Newest first
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/versions/user/stuart/loc/picsPlus/name/state.json", nil))
+	list := struct {
+		Versions []struct {
+			Id   string `json:"id"`
+			Size int64  `json:"size"`
+		} `json:"versions"`
+	}{}
+	err := json.Unmarshal(rr.Body.Bytes(), &list)
+	if err != nil || rr.Code != http.StatusOK || len(list.Versions) != 2 {
+		t.Fatalf("List. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	newest := list.Versions[0].Id
+	oldest := list.Versions[1].Id
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/versions/user/stuart/loc/picsPlus/name/state.json/id/"+oldest, nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "{\"v\":2}" || !strings.HasPrefix(rr.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("Download. Status:%d Type:%s Body:%s", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+
+	// Roll back to the oldest. The current content becomes a version
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/versions/user/stuart/loc/picsPlus/name/state.json/id/"+oldest, nil))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Roll back. Status:%d Body:%s", rr.Code, rr.Body.String())
+	}
+	assertFileContent(t, file, "{\"v\":2}")
+	entries, _ := os.ReadDir(filepath.Join(plus, config.VersionsDirName, "state.json"))
+	if len(entries) != 2 || entries[0].Name() != newest {
+		t.Fatalf("Versions after roll back:%v", entries)
+	}
+	b, _ := os.ReadFile(filepath.Join(plus, config.VersionsDirName, "state.json", entries[1].Name()))
+	if string(b) != "{\"v\":4}" {
+		t.Fatalf("Rolled back content was not kept:%s", string(b))
+	}
+
+	for _, id := range []string{"nothere", "..", oldest} {
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/versions/user/stuart/loc/picsPlus/name/state.json/id/"+id, nil))
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("Version '%s'. Status:%d", id, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+/*
+Map an OS signal to an action for the action queue.
+
+SIGINT and SIGTERM stop the server cleanly (rc 0). SIGHUP re-loads the config.
+*/
+func SignalToAction(sig os.Signal) *ActionEvent {
+	switch sig {
+	case syscall.SIGHUP:
+		return &ActionEvent{Id: Reload, Rc: 0, Msg: "Config reload requested by SIGHUP"}
+	case syscall.SIGINT, syscall.SIGTERM:
+		return &ActionEvent{Id: Exit, Rc: 0, Msg: fmt.Sprintf("Exit requested by %s", sig)}
+	}
+	return &ActionEvent{Id: Ignore, Rc: 0, Msg: fmt.Sprintf("Signal %s ignored", sig)}
+}
+
+/*
+Send SIGINT, SIGTERM and SIGHUP to the action queue.
+
+Returns a function that stops the notifications.
+*/
+func NotifySignals(actionQueue chan *ActionEvent) func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range sigs {
+			actionQueue <- SignalToAction(sig)
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(sigs)
+	}
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often the certificate files are checked for changes
+const certCheckInterval = 10 * time.Second
+
+/*
+Provides the certificate for each TLS handshake.
+
+If the certificate or key file is modified the pair is re-loaded. If the new pair fails to load
+the previous certificate is retained and the error is logged. A server restart is not required.
+*/
+type CertReloader struct {
+	mu        sync.Mutex
+	certFile  string
+	keyFile   string
+	cert      *tls.Certificate
+	certMod   time.Time
+	keyMod    time.Time
+	lastCheck time.Time
+	logFunc   func(string)
+}
+
+func NewCertReloader(certFile, keyFile string, logFunc func(string)) (*CertReloader, error) {
+	cr := &CertReloader{certFile: certFile, keyFile: keyFile, logFunc: logFunc}
+	err := cr.load()
+	if err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (cr *CertReloader) load() error {
+	certStat, err := os.Stat(cr.certFile)
+	if err != nil {
+		return err
+	}
+	keyStat, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	cr.cert = &cert
+	cr.certMod = certStat.ModTime()
+	cr.keyMod = keyStat.ModTime()
+	return nil
+}
+
+func (cr *CertReloader) changed() bool {
+	certStat, err := os.Stat(cr.certFile)
+	if err != nil {
+		return false
+	}
+	keyStat, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return false
+	}
+	return !certStat.ModTime().Equal(cr.certMod) || !keyStat.ModTime().Equal(cr.keyMod)
+}
+
+/*
+Check the files now. Returns true if a new certificate was loaded.
+*/
+func (cr *CertReloader) Reload() bool {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return cr.reload(time.Now())
+}
+
+func (cr *CertReloader) reload(now time.Time) bool {
+	cr.lastCheck = now
+	if !cr.changed() {
+		return false
+	}
+	err := cr.load()
+	if err != nil {
+		if cr.logFunc != nil {
+			cr.logFunc(fmt.Sprintf("TLS Error: Certificate reload failed. Previous certificate retained. %s", err.Error()))
+		}
+		return false
+	}
+	if cr.logFunc != nil {
+		cr.logFunc(fmt.Sprintf("TLS: Certificate reloaded from %s", cr.certFile))
+	}
+	return true
+}
+
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	now := time.Now()
+	if now.Sub(cr.lastCheck) >= certCheckInterval {
+		cr.reload(now)
+	}
+	return cr.cert, nil
+}
+
+func (cr *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cr.GetCertificate,
+	}
+}
+
+/*
+Redirect every request to the same host and path on the HTTPS port (for example ':8443').
+*/
+func NewRedirectHandler(httpsPort string) http.Handler {
+	port := strings.TrimPrefix(httpsPort, ":")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = strings.TrimSuffix(strings.TrimPrefix(r.Host, "["), "]") // No port. IPv6 is in brackets
+		}
+		target := "https://" + net.JoinHostPort(host, port) + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
+
+/*
+Returns localhost, the host name and all of the addresses of this machine.
+Used as the names in a self signed certificate.
+*/
+func LocalHostNames() []string {
+	names := []string{"localhost"}
+	hn, err := os.Hostname()
+	if err == nil && hn != "" && hn != "localhost" {
+		names = append(names, hn)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err == nil {
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if ok {
+				names = append(names, ipNet.IP.String())
+			}
+		}
+	}
+	return names
+}
+
+/*
+Create a self signed certificate and key (PEM) for the host names and ip addresses.
+
+The certificate is valid for 2 years. The key file is only readable by the owner.
+*/
+func GenerateSelfSignedCert(certFile, keyFile string, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"goWebApp self signed"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(2, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true, // Not a CA. The key cannot sign certificates for other hosts
+	}
+	for _, h := range hosts {
+		ip := net.ParseIP(h)
+		if ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600)
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	err := GenerateSelfSignedCert(certFile, keyFile, []string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("GenerateSelfSignedCert failed: %s", err.Error())
+	}
+	log := &TLog{}
+	cr, err := NewCertReloader(certFile, keyFile, log.Log)
+	if err != nil {
+		t.Fatalf("NewCertReloader failed: %s", err.Error())
+	}
+	c1, _ := cr.GetCertificate(&tls.ClientHelloInfo{})
+	leaf, err := x509.ParseCertificate(c1.Certificate[0])
+	if err != nil || leaf.IsCA || leaf.KeyUsage&x509.KeyUsageCertSign != 0 || leaf.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+		t.Fatalf("Certificate should be a server certificate, not a CA. %v", err)
+	}
+	if cr.Reload() {
+		t.Fatalf("Reload should not happen if the files are unchanged")
+	}
+
+	// Replace the certificate. Set the mod time as the file system may not have the resolution
+	err = GenerateSelfSignedCert(certFile, keyFile, []string{"localhost"})
+	if err != nil {
+		t.Fatalf("GenerateSelfSignedCert failed: %s", err.Error())
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	if !cr.Reload() {
+		t.Fatalf("Reload should happen if the files change")
+	}
+	c2, _ := cr.GetCertificate(&tls.ClientHelloInfo{})
+	if c1 == c2 {
+		t.Fatalf("Certificate should have been replaced")
+	}
+
+	// An invalid certificate retains the previous one
+	os.WriteFile(certFile, []byte("invalid"), 0644)
+	future = future.Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	if cr.Reload() {
+		t.Fatalf("Reload should fail for an invalid certificate")
+	}
+	c3, _ := cr.GetCertificate(&tls.ClientHelloInfo{})
+	if c3 != c2 {
+		t.Fatalf("Previous certificate should be retained")
+	}
+	AssertLogContains(t, log, []string{"TLS: Certificate reloaded", "TLS Error: Certificate reload failed"})
+}
+
+func TestRedirectHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://myhost:8080/files/user/bob/loc/home?a=1", nil)
+	NewRedirectHandler(":8443").ServeHTTP(rr, req)
+	if rr.Code != http.StatusMovedPermanently {
+		t.Fatalf("Redirect status should be 301 not %d", rr.Code)
+	}
+	loc := rr.Header().Get("Location")
+	if loc != "https://myhost:8443/files/user/bob/loc/home?a=1" {
+		t.Fatalf("Redirect Location is wrong: %s", loc)
+	}
+	for host, expected := range map[string]string{"[::1]:8080": "https://[::1]:8443/ping", "[::1]": "https://[::1]:8443/ping", "myhost": "https://myhost:8443/ping"} {
+		rr = httptest.NewRecorder()
+		req = httptest.NewRequest("GET", "/ping", nil)
+		req.Host = host
+		NewRedirectHandler(":8443").ServeHTTP(rr, req)
+		if rr.Header().Get("Location") != expected {
+			t.Fatalf("Redirect Location for host %s is %s not %s", host, rr.Header().Get("Location"), expected)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stuartdd/goWebApp/controllers"
+)
+
+// How often the trash is checked for items older than the retention period
+var trashSweepInterval = time.Hour
+
+/*
+Purge items deleted more than config TrashRetentionDays ago from every users trash.
+
+The retention is read from the current config each time. Returns when the publisher is closed.
+*/
+func (h *ServerHandler) sweepTrash() {
+	for {
+		select {
+		case <-h.publisher.stop:
+			return
+		case <-time.After(trashSweepInterval):
+			cfg := h.Config()
+			for _, item := range controllers.PurgeTrash(cfg, time.Now(), cfg.GetTrashRetention()) {
+				h.Log(fmt.Sprintf("Trash: Purged %s", item))
+			}
+		}
+	}
+}
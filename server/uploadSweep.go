@@ -0,0 +1,30 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stuartdd/goWebApp/controllers"
+)
+
+// How often partial uploads are checked for expiry
+var uploadSweepInterval = 10 * time.Minute
+
+/*
+Remove partial (chunked) uploads that have not been written to for config UploadExpiryMinutes.
+
+The expiry is read from the current config each time. Returns when the publisher is closed.
+*/
+func (h *ServerHandler) sweepUploads() {
+	for {
+		select {
+		case <-h.publisher.stop:
+			return
+		case <-time.After(uploadSweepInterval):
+			cfg := h.Config()
+			for _, f := range controllers.RemoveStaleUploads(cfg, time.Now(), cfg.GetUploadExpiry()) {
+				h.Log(fmt.Sprintf("Upload: Removed stale partial upload %s", cfg.GetPathForDisplay(f)))
+			}
+		}
+	}
+}